@@ -0,0 +1 @@
+console.log("Test add headers");
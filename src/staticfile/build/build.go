@@ -0,0 +1,117 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/detect"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/finalize"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/supply"
+	"github.com/paketo-buildpacks/packit/v2"
+)
+
+// Builder stages an app as a Cloud Native Buildpack. It runs the same supply and
+// finalize as the v2 buildpack, with the nginx layer standing in for the dep dir
+// and the app directory for the build dir.
+type Builder struct {
+	Manifest     supply.Manifest
+	Installer    supply.Installer
+	Log          *libbuildpack.Logger
+	Getenv       func(string) string
+	Command      finalize.Command
+	LaunchBinary string
+	SSOBinary    string
+}
+
+// CFStack is the cflinuxfs stack whose dependencies run on the CNB stack. The
+// Ubuntu 24.04 stacks match cflinuxfs5, and everything else cflinuxfs4.
+func CFStack(stackID string) string {
+	if strings.Contains(stackID, "noble") || strings.Contains(stackID, "cflinuxfs5") {
+		return "cflinuxfs5"
+	}
+	return "cflinuxfs4"
+}
+
+// layerStager is the supply.Stager of a layer.
+type layerStager struct {
+	appDir, layerDir string
+}
+
+func (s *layerStager) BuildDir() string { return s.appDir }
+func (s *layerStager) DepDir() string   { return s.layerDir }
+
+// AddBinDependencyLink links the binary into the bin directory of the layer, which
+// the lifecycle puts on the PATH.
+func (s *layerStager) AddBinDependencyLink(destPath, sourceName string) error {
+	binDir := filepath.Join(s.layerDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return err
+	}
+	relPath, err := filepath.Rel(binDir, destPath)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(relPath, filepath.Join(binDir, sourceName))
+}
+
+// Build installs nginx into a launch layer and makes the launcher the web process.
+// The process is direct, so the profile.d script that finalize writes for v2
+// droplets is not sourced, and APP_ROOT comes from the layer's launch environment
+// instead.
+func (b *Builder) Build(context packit.BuildContext) (packit.BuildResult, error) {
+	layer, err := context.Layers.Get("nginx")
+	if err != nil {
+		return packit.BuildResult{}, err
+	}
+	if layer, err = layer.Reset(); err != nil {
+		return packit.BuildResult{}, err
+	}
+
+	ss := &supply.Supplier{
+		Stager:    &layerStager{appDir: context.WorkingDir, layerDir: layer.Path},
+		Manifest:  b.Manifest,
+		Installer: b.Installer,
+		YAML:      libbuildpack.NewYAML(),
+		Log:       b.Log,
+		Getenv:    b.Getenv,
+		Now:       time.Now,
+	}
+	if err := supply.Run(ss); err != nil {
+		return packit.BuildResult{}, err
+	}
+
+	sf := &finalize.Finalizer{
+		BuildDir:         context.WorkingDir,
+		DepDir:           layer.Path,
+		Log:              b.Log,
+		YAML:             libbuildpack.NewYAML(),
+		Command:          b.Command,
+		LaunchBinary:     b.LaunchBinary,
+		SSOBinary:        b.SSOBinary,
+		Stack:            b.Getenv("CF_STACK"),
+		BuildpackVersion: context.BuildpackInfo.Version,
+		Config:           finalize.Staticfile{RootDir: b.Getenv(detect.RootEnv)},
+	}
+	if err := finalize.Run(sf); err != nil {
+		return packit.BuildResult{}, err
+	}
+
+	b.Log.BeginStep("Writing the nginx layer and the web process")
+	layer.Launch = true
+	layer.LaunchEnv.Override("APP_ROOT", context.WorkingDir)
+
+	return packit.BuildResult{
+		Layers: []packit.Layer{layer},
+		Launch: packit.LaunchMetadata{
+			Processes: []packit.Process{{
+				Type:    "web",
+				Command: filepath.Join(context.WorkingDir, ".staticfile", "launch"),
+				Direct:  true,
+				Default: true,
+			}},
+		},
+	}, nil
+}
@@ -0,0 +1,159 @@
+package build_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/build"
+	"github.com/paketo-buildpacks/packit/v2"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeManifest struct{}
+
+func (fakeManifest) AllDependencyVersions(string) []string { return []string{"1.27.5"} }
+
+func (fakeManifest) DefaultVersion(name string) (libbuildpack.Dependency, error) {
+	return libbuildpack.Dependency{Name: name, Version: "1.27.5"}, nil
+}
+
+func (fakeManifest) GetEntry(dep libbuildpack.Dependency) (*libbuildpack.ManifestEntry, error) {
+	return &libbuildpack.ManifestEntry{Dependency: dep, SHA256: "abc123", CFStacks: []string{"cflinuxfs4"}}, nil
+}
+
+func (fakeManifest) RootDir() string { return filepath.Join("..", "..", "..") }
+
+// fakeInstaller installs an nginx that accepts any configuration.
+type fakeInstaller struct{}
+
+func (fakeInstaller) InstallDependency(_ libbuildpack.Dependency, dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "sbin"), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "sbin", "nginx"), []byte("#!/bin/sh\n"), 0755)
+}
+
+type fakeCommand struct{}
+
+func (fakeCommand) Execute(string, io.Writer, io.Writer, string, ...string) error { return nil }
+
+var _ = Describe("Build", func() {
+	var (
+		err       error
+		appDir    string
+		layersDir string
+		binDir    string
+		builder   *build.Builder
+		context   packit.BuildContext
+	)
+
+	readFile := func(path string) string {
+		contents, err := os.ReadFile(path)
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	BeforeEach(func() {
+		appDir, err = os.MkdirTemp("", "staticfile-buildpack.app.")
+		Expect(err).To(BeNil())
+		layersDir, err = os.MkdirTemp("", "staticfile-buildpack.layers.")
+		Expect(err).To(BeNil())
+		binDir, err = os.MkdirTemp("", "staticfile-buildpack.bin.")
+		Expect(err).To(BeNil())
+		DeferCleanup(func() {
+			Expect(os.RemoveAll(appDir)).To(Succeed())
+			Expect(os.RemoveAll(layersDir)).To(Succeed())
+			Expect(os.RemoveAll(binDir)).To(Succeed())
+		})
+
+		Expect(os.WriteFile(filepath.Join(appDir, "Staticfile"), []byte("root: public\n"), 0644)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(appDir, "public"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(appDir, "public", "index.html"), []byte("hello"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(binDir, "launch"), []byte("launcher"), 0755)).To(Succeed())
+
+		builder = &build.Builder{
+			Manifest:     fakeManifest{},
+			Installer:    fakeInstaller{},
+			Log:          libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer))),
+			Getenv:       func(string) string { return "" },
+			Command:      fakeCommand{},
+			LaunchBinary: filepath.Join(binDir, "launch"),
+			SSOBinary:    filepath.Join(binDir, "sso"),
+		}
+		context = packit.BuildContext{
+			WorkingDir:    appDir,
+			Layers:        packit.Layers{Path: layersDir},
+			Stack:         "io.buildpacks.stacks.jammy",
+			BuildpackInfo: packit.BuildpackInfo{Version: "1.6.40"},
+		}
+	})
+
+	It("installs nginx into a launch layer on the PATH", func() {
+		result, err := builder.Build(context)
+		Expect(err).To(BeNil())
+
+		Expect(result.Layers).To(HaveLen(1))
+		layer := result.Layers[0]
+		Expect(layer.Name).To(Equal("nginx"))
+		Expect(layer.Launch).To(BeTrue())
+		Expect(layer.LaunchEnv).To(Equal(packit.Environment{"APP_ROOT.override": appDir}))
+
+		link, err := os.Readlink(filepath.Join(layer.Path, "bin", "nginx"))
+		Expect(err).To(BeNil())
+		Expect(link).To(Equal("../nginx/sbin/nginx"))
+	})
+
+	It("configures nginx in the app", func() {
+		_, err := builder.Build(context)
+		Expect(err).To(BeNil())
+
+		Expect(filepath.Join(appDir, "nginx", "conf", "nginx.conf")).To(BeAnExistingFile())
+		Expect(readFile(filepath.Join(appDir, ".staticfile", "launch"))).To(Equal("launcher"))
+	})
+
+	It("makes the launcher the web process", func() {
+		result, err := builder.Build(context)
+		Expect(err).To(BeNil())
+
+		Expect(result.Launch.Processes).To(Equal([]packit.Process{{
+			Type:    "web",
+			Command: filepath.Join(appDir, ".staticfile", "launch"),
+			Direct:  true,
+			Default: true,
+		}}))
+	})
+
+	It("replaces the nginx layer of an earlier build", func() {
+		Expect(os.MkdirAll(filepath.Join(layersDir, "nginx", "bin"), 0755)).To(Succeed())
+		Expect(os.Symlink("old", filepath.Join(layersDir, "nginx", "bin", "nginx"))).To(Succeed())
+
+		_, err := builder.Build(context)
+		Expect(err).To(BeNil())
+	})
+})
+
+var _ = Describe("CFStack", func() {
+	It("matches the CNB stacks to cflinuxfs stacks", func() {
+		Expect(build.CFStack("io.buildpacks.stacks.jammy")).To(Equal("cflinuxfs4"))
+		Expect(build.CFStack("io.buildpacks.stacks.noble")).To(Equal("cflinuxfs5"))
+		Expect(build.CFStack("org.cloudfoundry.stacks.cflinuxfs5")).To(Equal("cflinuxfs5"))
+	})
+})
+
+var _ = Describe("buildpack.toml", func() {
+	It("has the version in VERSION", func() {
+		version, err := os.ReadFile(filepath.Join("..", "..", "..", "VERSION"))
+		Expect(err).To(BeNil())
+		buildpackTOML, err := os.ReadFile(filepath.Join("..", "..", "..", "buildpack.toml"))
+		Expect(err).To(BeNil())
+
+		Expect(regexp.MustCompile(`(?m)^  version = "(.*)"$`).FindSubmatch(buildpackTOML)).To(ContainElement([]byte(bytes.TrimSpace(version))))
+	})
+})
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/build"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/paketo-buildpacks/packit/v2"
+)
+
+func main() {
+	logger := libbuildpack.NewLogger(os.Stdout)
+
+	// The manifest picks dependencies for CF_STACK, which a CNB platform does not set.
+	if os.Getenv("CF_STACK") == "" {
+		os.Setenv("CF_STACK", build.CFStack(os.Getenv("CNB_STACK_ID")))
+	}
+
+	buildpackDir, err := libbuildpack.GetBuildpackDir()
+	if err != nil {
+		logger.Error("Unable to determine buildpack directory: %s", err.Error())
+		os.Exit(9)
+	}
+
+	manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+	if err != nil {
+		logger.Error("Unable to load buildpack manifest: %s", err.Error())
+		os.Exit(10)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		logger.Error("Unable to determine buildpack binaries directory: %s", err.Error())
+		os.Exit(18)
+	}
+
+	b := build.Builder{
+		Manifest:     manifest,
+		Installer:    libbuildpack.NewInstaller(manifest),
+		Log:          logger,
+		Getenv:       os.Getenv,
+		Command:      &libbuildpack.Command{},
+		LaunchBinary: filepath.Join(filepath.Dir(executable), "launch"),
+		SSOBinary:    filepath.Join(filepath.Dir(executable), "sso"),
+	}
+
+	packit.Build(b.Build)
+}
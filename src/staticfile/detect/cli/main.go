@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/detect"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/paketo-buildpacks/packit/v2"
+)
+
+// main follows the v2 contract, bin/detect <build-dir> printing the buildpack name
+// and version, unless the CNB lifecycle runs it, in which case packit writes the
+// build plan and declining exits with 100.
+func main() {
+	if os.Getenv("CNB_BUILDPACK_DIR") != "" {
+		packit.Detect(detect.DetectFunc(os.Getenv))
+		return
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: detect <build-dir>")
+		os.Exit(1)
+	}
+
+	d := detect.Detector{BuildDir: os.Args[1], Getenv: os.Getenv}
+	detected, reason, err := d.Detect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to detect a static site: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if !detected {
+		fmt.Fprintf(os.Stderr, "staticfile: %s\n", reason)
+		fmt.Println("no")
+		os.Exit(1)
+	}
+	fmt.Printf("staticfile %s\n", version())
+}
+
+func version() string {
+	buildpackDir, err := libbuildpack.GetBuildpackDir()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(buildpackDir, "VERSION"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,129 @@
+package detect
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2"
+)
+
+// RootEnv names the directory, relative to the app, that holds index.html when
+// there is no Staticfile. finalize serves it unless the Staticfile sets a root.
+const RootEnv = "BP_STATICFILE_ROOT"
+
+// PlanEntry is what the Cloud Native Buildpack provides and requires in the
+// build plan.
+const PlanEntry = "staticfile"
+
+// IndexEnv opts in to detecting apps that have an index.html but no Staticfile.
+const IndexEnv = "BP_DETECT_INDEX_HTML"
+
+// languageMarkers are the files that other buildpacks detect on. An index.html
+// next to one of them is more likely the assets of that app than a static site.
+var languageMarkers = []string{
+	"Gemfile",
+	"Pipfile",
+	"build.gradle",
+	"composer.json",
+	"go.mod",
+	"mix.exs",
+	"package.json",
+	"pom.xml",
+	"pyproject.toml",
+	"requirements.txt",
+}
+
+type Detector struct {
+	BuildDir string
+	Getenv   func(string) string
+}
+
+// Detect reports whether the app is a static site, and why. The reason explains
+// a decline, so that a failed multi-buildpack detection can be traced back.
+func (d *Detector) Detect() (bool, string, error) {
+	root := d.Getenv(RootEnv)
+	if root == "" {
+		root = "."
+	}
+
+	staticfile, found, err := FindStaticfile(d.BuildDir, root)
+	if err != nil || found {
+		rel, _ := filepath.Rel(d.BuildDir, staticfile)
+		return found, fmt.Sprintf("found %s", filepath.ToSlash(rel)), err
+	}
+
+	if d.Getenv(IndexEnv) != "true" {
+		return false, fmt.Sprintf("no Staticfile in the app, set %s=true to detect an index.html without one", IndexEnv), nil
+	}
+
+	index := filepath.Join(d.BuildDir, root, "index.html")
+	if found, err := exists(index); err != nil || !found {
+		return false, fmt.Sprintf("no Staticfile or %s in the app", filepath.ToSlash(filepath.Join(root, "index.html"))), err
+	}
+
+	var markers []string
+	for _, marker := range languageMarkers {
+		found, err := exists(filepath.Join(d.BuildDir, marker))
+		if err != nil {
+			return false, "", err
+		}
+		if found {
+			markers = append(markers, marker)
+		}
+	}
+	if len(markers) > 0 {
+		return false, fmt.Sprintf("found an index.html, but also %s, which another buildpack detects, add a Staticfile to serve the app as a static site", strings.Join(markers, ", ")), nil
+	}
+	return true, fmt.Sprintf("found %s and no Staticfile", filepath.ToSlash(filepath.Join(root, "index.html"))), nil
+}
+
+// DetectFunc passes on the same apps as the v2 bin/detect, when the Cloud Native
+// Buildpacks lifecycle runs detect in the app directory.
+func DetectFunc(getenv func(string) string) packit.DetectFunc {
+	return func(context packit.DetectContext) (packit.DetectResult, error) {
+		d := Detector{BuildDir: context.WorkingDir, Getenv: getenv}
+		detected, reason, err := d.Detect()
+		if err != nil {
+			return packit.DetectResult{}, err
+		}
+		if !detected {
+			return packit.DetectResult{}, packit.Fail.WithMessage("staticfile: %s", reason)
+		}
+
+		return packit.DetectResult{
+			Plan: packit.BuildPlan{
+				Provides: []packit.BuildPlanProvision{{Name: PlanEntry}},
+				Requires: []packit.BuildPlanRequirement{{Name: PlanEntry}},
+			},
+		}, nil
+	}
+}
+
+// FindStaticfile returns the Staticfile at the top of the app, or else the one
+// in root, and whether either exists. Without one it returns the path at the top.
+func FindStaticfile(buildDir, root string) (string, bool, error) {
+	top := filepath.Join(buildDir, "Staticfile")
+	paths := []string{top}
+	if root != "" {
+		paths = append(paths, filepath.Join(buildDir, root, "Staticfile"))
+	}
+	for _, path := range paths {
+		if found, err := exists(path); err != nil || found {
+			return path, found, err
+		}
+	}
+	return top, false, nil
+}
+
+func exists(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
@@ -0,0 +1,169 @@
+package detect_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/detect"
+	"github.com/paketo-buildpacks/packit/v2"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Detect", func() {
+	var (
+		err      error
+		buildDir string
+		env      map[string]string
+		detected bool
+		reason   string
+	)
+
+	writeFile := func(path string) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, path)), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildDir, path), nil, 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "staticfile-buildpack.build.")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, buildDir)
+
+		env = map[string]string{}
+	})
+
+	JustBeforeEach(func() {
+		d := &detect.Detector{BuildDir: buildDir, Getenv: func(name string) string { return env[name] }}
+		detected, reason, err = d.Detect()
+		Expect(err).To(BeNil())
+	})
+
+	Context("the app has a Staticfile", func() {
+		BeforeEach(func() {
+			writeFile("Staticfile")
+			writeFile("package.json")
+		})
+
+		It("detects the app", func() {
+			Expect(detected).To(BeTrue())
+			Expect(reason).To(Equal("found Staticfile"))
+		})
+	})
+
+	Context("the app has only an index.html", func() {
+		BeforeEach(func() {
+			writeFile("index.html")
+		})
+
+		It("declines and says how to opt in", func() {
+			Expect(detected).To(BeFalse())
+			Expect(reason).To(Equal("no Staticfile in the app, set BP_DETECT_INDEX_HTML=true to detect an index.html without one"))
+		})
+
+		Context("and BP_DETECT_INDEX_HTML is true", func() {
+			BeforeEach(func() {
+				env["BP_DETECT_INDEX_HTML"] = "true"
+			})
+
+			It("detects the app", func() {
+				Expect(detected).To(BeTrue())
+				Expect(reason).To(Equal("found index.html and no Staticfile"))
+			})
+
+			Context("and another buildpack's marker", func() {
+				BeforeEach(func() {
+					writeFile("package.json")
+					writeFile("go.mod")
+				})
+
+				It("declines and names the markers", func() {
+					Expect(detected).To(BeFalse())
+					Expect(reason).To(Equal("found an index.html, but also go.mod, package.json, which another buildpack detects, add a Staticfile to serve the app as a static site"))
+				})
+			})
+		})
+	})
+
+	Context("BP_STATICFILE_ROOT names the directory with the index.html", func() {
+		BeforeEach(func() {
+			env["BP_DETECT_INDEX_HTML"] = "true"
+			env["BP_STATICFILE_ROOT"] = "dist"
+		})
+
+		It("declines without dist/index.html", func() {
+			writeFile("index.html")
+			Expect(detected).To(BeFalse())
+		})
+
+		Context("and it has one", func() {
+			BeforeEach(func() {
+				writeFile("dist/index.html")
+			})
+
+			It("detects the app", func() {
+				Expect(detected).To(BeTrue())
+				Expect(reason).To(Equal("found dist/index.html and no Staticfile"))
+			})
+		})
+	})
+
+	Context("BP_STATICFILE_ROOT names a directory with a Staticfile", func() {
+		BeforeEach(func() {
+			env["BP_STATICFILE_ROOT"] = "dist"
+			writeFile("dist/Staticfile")
+			writeFile("package.json")
+		})
+
+		It("detects the app without opting in to index.html", func() {
+			Expect(detected).To(BeTrue())
+			Expect(reason).To(Equal("found dist/Staticfile"))
+		})
+
+		Context("and the app has a Staticfile at the top", func() {
+			BeforeEach(func() {
+				writeFile("Staticfile")
+			})
+
+			It("prefers it", func() {
+				Expect(detected).To(BeTrue())
+				Expect(reason).To(Equal("found Staticfile"))
+			})
+		})
+	})
+
+	Context("the app is empty", func() {
+		BeforeEach(func() {
+			env["BP_DETECT_INDEX_HTML"] = "true"
+		})
+
+		It("declines", func() {
+			Expect(detected).To(BeFalse())
+			Expect(reason).To(Equal("no Staticfile or index.html in the app"))
+		})
+	})
+})
+
+var _ = Describe("DetectFunc", func() {
+	var workingDir string
+
+	BeforeEach(func() {
+		workingDir = GinkgoT().TempDir()
+	})
+
+	It("requires and provides staticfile when it detects the app", func() {
+		Expect(os.WriteFile(filepath.Join(workingDir, "Staticfile"), nil, 0644)).To(Succeed())
+
+		result, err := detect.DetectFunc(func(string) string { return "" })(packit.DetectContext{WorkingDir: workingDir})
+		Expect(err).To(BeNil())
+		Expect(result.Plan).To(Equal(packit.BuildPlan{
+			Provides: []packit.BuildPlanProvision{{Name: "staticfile"}},
+			Requires: []packit.BuildPlanRequirement{{Name: "staticfile"}},
+		}))
+	})
+
+	It("fails with the reason otherwise", func() {
+		_, err := detect.DetectFunc(func(string) string { return "" })(packit.DetectContext{WorkingDir: workingDir})
+		Expect(err).To(MatchError(packit.Fail.WithMessage("staticfile: no Staticfile in the app, set BP_DETECT_INDEX_HTML=true to detect an index.html without one")))
+	})
+})
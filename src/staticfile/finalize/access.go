@@ -0,0 +1,110 @@
+package finalize
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+type AuthRule struct {
+	Path      string
+	BasicAuth *bool
+	Allow     []string
+	Deny      []string
+	Index     int `yaml:"-"`
+}
+
+type AuthRuleTemp struct {
+	BasicAuth string   `yaml:"basic_auth"`
+	Allow     []string `yaml:"allow"`
+	Deny      []string `yaml:"deny"`
+}
+
+func validAddress(address string) bool {
+	if _, _, err := net.ParseCIDR(address); err == nil {
+		return true
+	}
+	return net.ParseIP(address) != nil
+}
+
+// Pattern is the key of the rule in the $auth_rule map.
+func (r AuthRule) Pattern() string {
+	return fmt.Sprintf(`"~%s"`, globToRegex(r.Path))
+}
+
+// Realm is the auth_basic value for requests that match the rule.
+func (r AuthRule) Realm() string {
+	if *r.BasicAuth {
+		return `"Restricted"`
+	}
+	return "off"
+}
+
+func (r AuthRule) HasIPRules() bool {
+	return len(r.Allow) > 0 || len(r.Deny) > 0
+}
+
+// IPVariable is set by a geo block to 1 when the client may access the paths
+// that match the rule.
+func (r AuthRule) IPVariable() string {
+	return fmt.Sprintf("$auth_ip_%d", r.Index)
+}
+
+func (s Staticfile) HasIPRules() bool {
+	for _, rule := range s.AuthRules {
+		if rule.HasIPRules() {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultTrustedProxies are the private and loopback ranges, where the gorouter
+// and the envoy in front of the app usually are.
+var defaultTrustedProxies = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.1", "fc00::/7", "::1"}
+
+// UsesClientAddress reports whether the app route checks the client address, for
+// the allow and deny of auth rules or the metrics allow list. nginx then takes it
+// from X-Forwarded-For, skipping the trusted proxies from the right, so that a
+// client cannot pick its address by sending the header itself.
+func (s Staticfile) UsesClientAddress() bool {
+	return s.HasIPRules() || s.Metrics != nil && s.Metrics.Port == 0 && len(s.Metrics.Allow) > 0
+}
+
+// RealIPFrom returns the addresses that nginx trusts to append the client address
+// to X-Forwarded-For.
+func (s Staticfile) RealIPFrom() []string {
+	if len(s.TrustedProxies) > 0 {
+		return s.TrustedProxies
+	}
+	return defaultTrustedProxies
+}
+
+// getAuthRules orders the rules like the path header locations, so that the
+// $auth_rule map picks the glob with the most literal characters. The map reads
+// the path before redirects, pushstate or proxies rewrite it, so rules apply to
+// the path that was requested. Paths that no rule matches keep the site wide
+// setting, which requires credentials whenever there is a Staticfile.auth or
+// basic_auth.
+func (sf *Finalizer) getAuthRules(raw map[string]AuthRuleTemp, isEnabled func(string) bool) []AuthRule {
+	var rules []AuthRule
+	for path, ruleTemp := range raw {
+		rule := AuthRule{Path: path, Allow: ruleTemp.Allow, Deny: ruleTemp.Deny}
+		if ruleTemp.BasicAuth != "" {
+			basicAuth := isEnabled(ruleTemp.BasicAuth)
+			rule.BasicAuth = &basicAuth
+		}
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if globSpecificity(rules[i].Path) != globSpecificity(rules[j].Path) {
+			return globSpecificity(rules[i].Path) > globSpecificity(rules[j].Path)
+		}
+		return rules[i].Path < rules[j].Path
+	})
+	for i := range rules {
+		rules[i].Index = i + 1
+	}
+	return rules
+}
@@ -0,0 +1,150 @@
+package finalize
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type BasicAuthSource struct {
+	Env     string
+	Service string
+}
+
+type BasicAuthSourceTemp struct {
+	Env     string `yaml:"env"`
+	Service string `yaml:"service"`
+}
+
+const defaultAuthUsersEnv = "STATICFILE_AUTH_USERS"
+
+type passwordScheme struct {
+	name    string
+	pattern *regexp.Regexp
+	// stacks lists the stacks whose crypt(3) can verify the hash, nil means nginx
+	// can verify it on every stack.
+	stacks []string
+	weak   bool
+}
+
+// passwordSchemes are the hashes nginx accepts in an htpasswd file. nginx checks
+// {PLAIN}, {SHA}, {SSHA} and $apr1$ itself and hands everything else to the
+// crypt(3) of the stack.
+var passwordSchemes = []passwordScheme{
+	{name: "{PLAIN}", pattern: regexp.MustCompile(`^\{PLAIN\}.+$`), weak: true},
+	{name: "{SHA}", pattern: regexp.MustCompile(`^\{SHA\}[A-Za-z0-9+/]{27}=$`)},
+	{name: "{SSHA}", pattern: regexp.MustCompile(`^\{SSHA\}[A-Za-z0-9+/]{28,}={0,2}$`)},
+	{name: "MD5 (apr1)", pattern: regexp.MustCompile(`^\$apr1\$[^$:]{1,8}\$[./0-9A-Za-z]{22}$`)},
+	{name: "MD5 crypt", pattern: regexp.MustCompile(`^\$1\$[^$:]{1,8}\$[./0-9A-Za-z]{22}$`)},
+	{name: "SHA-256 crypt", pattern: regexp.MustCompile(`^\$5\$(rounds=[0-9]+\$)?[^$:]{1,16}\$[./0-9A-Za-z]{43}$`)},
+	{name: "SHA-512 crypt", pattern: regexp.MustCompile(`^\$6\$(rounds=[0-9]+\$)?[^$:]{1,16}\$[./0-9A-Za-z]{86}$`)},
+	{name: "bcrypt", pattern: regexp.MustCompile(`^\$2[aby]\$[0-9]{2}\$[./0-9A-Za-z]{53}$`), stacks: []string{"cflinuxfs4", "cflinuxfs5"}},
+	{name: "yescrypt", pattern: regexp.MustCompile(`^\$g?y\$[./0-9A-Za-z]+\$[./0-9A-Za-z]+\$[./0-9A-Za-z]{43}$`), stacks: []string{"cflinuxfs4", "cflinuxfs5"}},
+	{name: "crypt(3) DES", pattern: regexp.MustCompile(`^[./0-9A-Za-z]{13}$`), weak: true},
+}
+
+func findPasswordScheme(hash string) (passwordScheme, bool) {
+	for _, scheme := range passwordSchemes {
+		if scheme.pattern.MatchString(hash) {
+			return scheme, true
+		}
+	}
+	return passwordScheme{}, false
+}
+
+// ValidateAuthFile reads Staticfile.auth the way nginx reads an htpasswd file,
+// and reports the entries that would never let a user in, so that they fail
+// staging rather than showing up as 401s and 500s.
+func (sf *Finalizer) ValidateAuthFile() error {
+	data, err := os.ReadFile(filepath.Join(sf.BuildDir, "Staticfile.auth"))
+	if err != nil {
+		return err
+	}
+
+	var problems []error
+	users := map[string]int{}
+	for i, line := range strings.Split(string(data), "\n") {
+		number := i + 1
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, rest, found := strings.Cut(line, ":")
+		if !found {
+			problems = append(problems, &StaticfileError{Line: number, Message: "expected user:password-hash"})
+			continue
+		}
+		// nginx ignores everything after a second colon.
+		hash, _, _ := strings.Cut(rest, ":")
+
+		if user == "" {
+			problems = append(problems, &StaticfileError{Line: number, Message: "missing user name"})
+			continue
+		}
+		if strings.ContainsAny(user, " \t") {
+			problems = append(problems, &StaticfileError{Line: number, Message: fmt.Sprintf("user name %q cannot contain whitespace", user)})
+			continue
+		}
+		if first, ok := users[user]; ok {
+			problems = append(problems, &StaticfileError{Line: number, Message: fmt.Sprintf("duplicate user %q (first set on line %d), nginx only checks the first entry", user, first)})
+			continue
+		}
+		users[user] = number
+
+		if hash == "" {
+			problems = append(problems, &StaticfileError{Line: number, Message: fmt.Sprintf("missing password hash for user %q", user)})
+			continue
+		}
+
+		scheme, ok := findPasswordScheme(hash)
+		if !ok {
+			problems = append(problems, &StaticfileError{Line: number, Message: fmt.Sprintf("the password for user %q is not a hash nginx can verify, generate one with htpasswd -5, or prefix a plaintext password with {PLAIN}", user)})
+			continue
+		}
+		if scheme.stacks != nil && !slices.Contains(scheme.stacks, sf.Stack) {
+			if sf.Stack == "" {
+				sf.Log.Warning("Staticfile.auth line %d: unable to determine the stack, nginx may not be able to verify the %s password for user %q.", number, scheme.name, user)
+				continue
+			}
+			problems = append(problems, &StaticfileError{Line: number, Message: fmt.Sprintf("nginx cannot verify the %s password for user %q on %s, use htpasswd -5 instead", scheme.name, user, sf.Stack)})
+			continue
+		}
+		if scheme.weak {
+			sf.Log.Warning("Staticfile.auth line %d: the password for user %q is stored as %s, which is easy to recover from the droplet. Use htpasswd -5 instead.", number, user, scheme.name)
+		}
+	}
+
+	if len(users) == 0 && len(problems) == 0 {
+		problems = append(problems, &StaticfileError{Message: "no users are defined, every request would be rejected"})
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid Staticfile.auth:\n%w", errors.Join(problems...))
+	}
+	return nil
+}
+
+func (b BasicAuthSource) String() string {
+	switch {
+	case b.Service != "" && b.Env != "":
+		return fmt.Sprintf("the %s service, or the %s environment variable", b.Service, b.Env)
+	case b.Service != "":
+		return fmt.Sprintf("the %s service", b.Service)
+	default:
+		return fmt.Sprintf("the %s environment variable", b.Env)
+	}
+}
+
+// getBasicAuthSource reads users from STATICFILE_AUTH_USERS unless a service or
+// another environment variable is named.
+func getBasicAuthSource(raw *BasicAuthSourceTemp) *BasicAuthSource {
+	source := &BasicAuthSource{Env: raw.Env, Service: raw.Service}
+	if source.Env == "" && source.Service == "" {
+		source.Env = defaultAuthUsersEnv
+	}
+	return source
+}
@@ -0,0 +1,121 @@
+package finalize_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/finalize"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidateAuthFile", func() {
+	var (
+		err       error
+		buildDir  string
+		buffer    *bytes.Buffer
+		finalizer *finalize.Finalizer
+		authFile  string
+	)
+
+	const (
+		apr1   = "$apr1$DuUQEQp8$ZccZCHQElNSjrg.erwSFC0"
+		sha512 = "$6$rJm2SnKbF5Mgbq1E$Rk3PU4aL3B8r7gQwJj7dOY0dqZZ6D3r2U9zU3TqyH7w2pH2x6a0oYtKzqfP3Lkz4Y6FhJx6rjv0WbRCxQdZbN."
+		bcrypt = "$2y$05$yH2uX2hIxD5.5G3nY2b2Xe0m8hQ9Kk3V2cK2j7k5k3mXqTq3v9G7a"
+		des    = "rl0uE4R5yKZ0s"
+	)
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "staticfile-buildpack.build.")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, buildDir)
+
+		buffer = new(bytes.Buffer)
+		finalizer = &finalize.Finalizer{
+			BuildDir: buildDir,
+			Log:      libbuildpack.NewLogger(ansicleaner.New(buffer)),
+			Stack:    "cflinuxfs4",
+		}
+	})
+
+	JustBeforeEach(func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte(authFile), 0644)).To(Succeed())
+		err = finalizer.ValidateAuthFile()
+	})
+
+	Context("the entries are valid", func() {
+		BeforeEach(func() {
+			authFile = "# team\r\nalice:" + apr1 + "\r\n\nbob:" + sha512 + ":comment\ncarol:" + bcrypt + "\ndave:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"
+		})
+
+		It("does not return an error or warn", func() {
+			Expect(err).To(BeNil())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+	})
+
+	Context("the file is empty", func() {
+		BeforeEach(func() {
+			authFile = "\n# no users yet\n"
+		})
+
+		It("reports that no users are defined", func() {
+			Expect(err).To(MatchError("invalid Staticfile.auth:\nno users are defined, every request would be rejected"))
+		})
+	})
+
+	Context("entries are malformed", func() {
+		BeforeEach(func() {
+			authFile = "alice\n:" + apr1 + "\nbob smith:" + apr1 + "\ncarol:\ndave:secret\nalice:" + apr1 + "\nalice:" + sha512 + "\n"
+		})
+
+		It("reports every problem with its line", func() {
+			Expect(err).To(MatchError("invalid Staticfile.auth:\n" +
+				"line 1: expected user:password-hash\n" +
+				"line 2: missing user name\n" +
+				"line 3: user name \"bob smith\" cannot contain whitespace\n" +
+				"line 4: missing password hash for user \"carol\"\n" +
+				"line 5: the password for user \"dave\" is not a hash nginx can verify, generate one with htpasswd -5, or prefix a plaintext password with {PLAIN}\n" +
+				"line 7: duplicate user \"alice\" (first set on line 6), nginx only checks the first entry"))
+		})
+	})
+
+	Context("passwords are stored in plaintext or as DES hashes", func() {
+		BeforeEach(func() {
+			authFile = "alice:{PLAIN}secret\nbob:" + des + "\n"
+		})
+
+		It("warns about each of them", func() {
+			Expect(err).To(BeNil())
+			Expect(buffer.String()).To(ContainSubstring(`Staticfile.auth line 1: the password for user "alice" is stored as {PLAIN}`))
+			Expect(buffer.String()).To(ContainSubstring(`Staticfile.auth line 2: the password for user "bob" is stored as crypt(3) DES`))
+		})
+	})
+
+	Context("the stack cannot verify bcrypt", func() {
+		BeforeEach(func() {
+			finalizer.Stack = "cflinuxfs3"
+			authFile = "alice:" + bcrypt + "\n"
+		})
+
+		It("reports the entry", func() {
+			Expect(err).To(MatchError("invalid Staticfile.auth:\nline 1: nginx cannot verify the bcrypt password for user \"alice\" on cflinuxfs3, use htpasswd -5 instead"))
+		})
+	})
+
+	Context("the stack is unknown", func() {
+		BeforeEach(func() {
+			finalizer.Stack = ""
+			authFile = "alice:" + bcrypt + "\n"
+		})
+
+		It("warns that the hash may not be supported", func() {
+			Expect(err).To(BeNil())
+			Expect(buffer.String()).To(ContainSubstring(`Staticfile.auth line 1: unable to determine the stack, nginx may not be able to verify the bcrypt password for user "alice".`))
+		})
+	})
+})
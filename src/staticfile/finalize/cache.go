@@ -0,0 +1,185 @@
+package finalize
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	immutableCachePolicy = "public, max-age=31536000, immutable"
+	indexCachePolicy     = "no-cache"
+)
+
+type CacheControlRule struct {
+	Path   string
+	Policy string
+}
+
+type CacheControlEntry struct {
+	Key    string
+	Policy string
+}
+
+var (
+	fingerprintPattern    = regexp.MustCompile(`[.-]([0-9a-f]{6,})\.[A-Za-z0-9]+$`)
+	fingerprintURIPattern = regexp.MustCompile(`^[A-Za-z0-9._~/@+-]+$`)
+	cacheDirectivePattern = regexp.MustCompile(`^([a-z-]+)(=(.+))?$`)
+)
+
+var cacheDirectives = map[string]bool{
+	"public":                 false,
+	"private":                false,
+	"no-cache":               false,
+	"no-store":               false,
+	"no-transform":           false,
+	"must-revalidate":        false,
+	"proxy-revalidate":       false,
+	"must-understand":        false,
+	"immutable":              false,
+	"max-age":                true,
+	"s-maxage":               true,
+	"stale-while-revalidate": true,
+	"stale-if-error":         true,
+}
+
+// validCachePolicy checks that a Cache-Control policy is a comma separated list
+// of known response directives, with a number of seconds where one is required.
+func validCachePolicy(policy string) error {
+	for _, directive := range strings.Split(policy, ",") {
+		directive = strings.TrimSpace(directive)
+		match := cacheDirectivePattern.FindStringSubmatch(strings.ToLower(directive))
+		if match == nil {
+			return fmt.Errorf("invalid directive %q", directive)
+		}
+
+		needsSeconds, ok := cacheDirectives[match[1]]
+		if !ok {
+			return fmt.Errorf("unknown directive %q", match[1])
+		}
+		if needsSeconds {
+			if _, err := strconv.ParseUint(match[3], 10, 32); err != nil {
+				return fmt.Errorf("directive %s requires a number of seconds", match[1])
+			}
+		} else if match[2] != "" {
+			return fmt.Errorf("directive %s does not take a value", match[1])
+		}
+	}
+	return nil
+}
+
+func isFingerprinted(name string) bool {
+	match := fingerprintPattern.FindStringSubmatch(name)
+	if match == nil {
+		return false
+	}
+	return strings.ContainsAny(match[1], "0123456789") && strings.ContainsAny(match[1], "abcdef")
+}
+
+// FindFingerprintedAssets lists the files in public whose names contain a
+// content hash, such as app.3f9a1c.js, as URIs relative to the public root.
+func (sf *Finalizer) FindFingerprintedAssets() ([]string, error) {
+	publicDir := filepath.Join(sf.BuildDir, "public")
+
+	var assets []string
+	err := filepath.WalkDir(publicDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !isFingerprinted(entry.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(publicDir, path)
+		if err != nil {
+			return err
+		}
+
+		uri := "/" + filepath.ToSlash(rel)
+		if fingerprintURIPattern.MatchString(uri) {
+			assets = append(assets, uri)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(assets)
+	return assets, nil
+}
+
+// CacheControlMap returns the entries of the map from $uri to the Cache-Control
+// policy. nginx checks exact matches before regular expressions and then uses the
+// first regular expression that matches, so fingerprinted files come first, then
+// the Staticfile rules in order, then index.html. The file rendered from
+// runtime_env changes with every restart, so it is never cached.
+func (s Staticfile) CacheControlMap() []CacheControlEntry {
+	var entries []CacheControlEntry
+	if s.RuntimeEnv != nil {
+		entries = append(entries, CacheControlEntry{Key: quoteNginxString(s.RuntimeEnv.Path), Policy: quoteNginxString(indexCachePolicy)})
+	}
+	for _, asset := range s.FingerprintedFiles {
+		entries = append(entries, CacheControlEntry{Key: quoteNginxString(asset), Policy: quoteNginxString(immutableCachePolicy)})
+	}
+	for _, rule := range s.CacheControl {
+		entries = append(entries, CacheControlEntry{Key: fmt.Sprintf(`"~%s"`, globToRegex(rule.Path)), Policy: quoteNginxString(rule.Policy)})
+	}
+	if s.FingerprintedAssets {
+		entries = append(entries, CacheControlEntry{Key: `"~(^|/)index\.html?$"`, Policy: quoteNginxString(indexCachePolicy)})
+	}
+	return entries
+}
+
+func (s Staticfile) HasCacheControl() bool {
+	return len(s.CacheControl) > 0 || s.FingerprintedAssets || s.RuntimeEnv != nil
+}
+
+// MapHashBucketSize returns a map_hash_bucket_size large enough for the longest
+// fingerprinted file name or redirect source, or 0 when the nginx default is big
+// enough.
+func (s Staticfile) MapHashBucketSize() int {
+	longest := 0
+	for _, asset := range s.FingerprintedFiles {
+		longest = max(longest, len(asset))
+	}
+	for _, redirect := range s.Redirects {
+		longest = max(longest, len(redirect.From))
+	}
+
+	size := 64
+	for size < longest+32 {
+		size *= 2
+	}
+	if size == 64 {
+		return 0
+	}
+	return size
+}
+
+// MapHashMaxSize returns a map_hash_max_size that fits every fingerprinted file
+// and redirect source, or 0 when the nginx default is big enough.
+func (s Staticfile) MapHashMaxSize() int {
+	size := 2048
+	for size < 2*max(len(s.FingerprintedFiles), len(s.Redirects)) {
+		size *= 2
+	}
+	if size == 2048 {
+		return 0
+	}
+	return size
+}
+
+func (sf *Finalizer) getCacheControl(rules []map[string]string) []CacheControlRule {
+	var cacheControl []CacheControlRule
+	for _, rule := range rules {
+		for path, policy := range rule {
+			cacheControl = append(cacheControl, CacheControlRule{Path: path, Policy: policy})
+		}
+	}
+	return cacheControl
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/detect"
 	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/finalize"
 	_ "github.com/cloudfoundry/staticfile-buildpack/src/staticfile/hooks"
 
@@ -37,11 +39,29 @@ func main() {
 		os.Exit(11)
 	}
 
+	executable, err := os.Executable()
+	if err != nil {
+		logger.Error("Unable to determine buildpack binaries directory: %s", err.Error())
+		os.Exit(18)
+	}
+
+	version, err := manifest.Version()
+	if err != nil {
+		logger.Warning("Unable to determine the buildpack version: %s", err.Error())
+	}
+
 	sf := finalize.Finalizer{
-		BuildDir: stager.BuildDir(),
-		DepDir:   stager.DepDir(),
-		Log:      logger,
-		YAML:     libbuildpack.NewYAML(),
+		BuildDir:         stager.BuildDir(),
+		DepDir:           stager.DepDir(),
+		Log:              logger,
+		YAML:             libbuildpack.NewYAML(),
+		Command:          &libbuildpack.Command{},
+		LaunchBinary:     filepath.Join(filepath.Dir(executable), "launch"),
+		SSOBinary:        filepath.Join(filepath.Dir(executable), "sso"),
+		Stack:            os.Getenv("CF_STACK"),
+		BuildpackVersion: version,
+		// Apps detected by their index.html have no Staticfile to set the root.
+		Config: finalize.Staticfile{RootDir: os.Getenv(detect.RootEnv)},
 	}
 
 	if err := finalize.Run(&sf); err != nil {
@@ -0,0 +1,215 @@
+package finalize
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type ContentSecurityPolicy struct {
+	Directives map[string][]string
+	ReportOnly bool
+	ReportURI  string
+}
+
+type ContentSecurityPolicyTemp struct {
+	Directives map[string]interface{} `yaml:"directives"`
+	ReportOnly string                 `yaml:"report_only"`
+	ReportURI  string                 `yaml:"report_uri"`
+}
+
+type cspDirectiveKind int
+
+const (
+	cspSourceList cspDirectiveKind = iota
+	cspNoValue
+	cspSandbox
+	cspTokens
+)
+
+var cspDirectives = map[string]cspDirectiveKind{
+	"default-src":               cspSourceList,
+	"script-src":                cspSourceList,
+	"script-src-elem":           cspSourceList,
+	"script-src-attr":           cspSourceList,
+	"style-src":                 cspSourceList,
+	"style-src-elem":            cspSourceList,
+	"style-src-attr":            cspSourceList,
+	"img-src":                   cspSourceList,
+	"font-src":                  cspSourceList,
+	"connect-src":               cspSourceList,
+	"media-src":                 cspSourceList,
+	"object-src":                cspSourceList,
+	"frame-src":                 cspSourceList,
+	"child-src":                 cspSourceList,
+	"worker-src":                cspSourceList,
+	"manifest-src":              cspSourceList,
+	"base-uri":                  cspSourceList,
+	"form-action":               cspSourceList,
+	"frame-ancestors":           cspSourceList,
+	"upgrade-insecure-requests": cspNoValue,
+	"block-all-mixed-content":   cspNoValue,
+	"sandbox":                   cspSandbox,
+	"require-trusted-types-for": cspTokens,
+	"trusted-types":             cspTokens,
+	"report-to":                 cspTokens,
+}
+
+var cspKeywords = map[string]bool{
+	"self":                     true,
+	"none":                     true,
+	"unsafe-inline":            true,
+	"unsafe-eval":              true,
+	"unsafe-hashes":            true,
+	"strict-dynamic":           true,
+	"report-sample":            true,
+	"wasm-unsafe-eval":         true,
+	"unsafe-allow-redirects":   true,
+	"inline-speculation-rules": true,
+	"script":                   true,
+	"allow-duplicates":         true,
+}
+
+var cspSandboxTokens = map[string]bool{
+	"allow-downloads":                          true,
+	"allow-forms":                              true,
+	"allow-modals":                             true,
+	"allow-orientation-lock":                   true,
+	"allow-pointer-lock":                       true,
+	"allow-popups":                             true,
+	"allow-popups-to-escape-sandbox":           true,
+	"allow-presentation":                       true,
+	"allow-same-origin":                        true,
+	"allow-scripts":                            true,
+	"allow-storage-access-by-user-activation":  true,
+	"allow-top-navigation":                     true,
+	"allow-top-navigation-by-user-activation":  true,
+	"allow-top-navigation-to-custom-protocols": true,
+}
+
+var (
+	cspNonceOrHashPattern = regexp.MustCompile(`^(nonce|sha256|sha384|sha512)-[A-Za-z0-9+/_-]+={0,2}$`)
+	cspSchemePattern      = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:$`)
+	cspHostPattern        = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*://)?(\*|(\*\.)?[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*)(:([0-9]+|\*))?(/[^\s;,'"]*)?$`)
+	cspTokenPattern       = regexp.MustCompile(`^[A-Za-z0-9#=_/@.%*-]+$`)
+	cspReportURIPattern   = regexp.MustCompile(`^[^\s;,'"]+$`)
+)
+
+// Header returns the Content-Security-Policy header, or its report only variant,
+// with the directives in a stable order and default-src first.
+func (c ContentSecurityPolicy) Header() Header {
+	var names []string
+	for name := range c.Directives {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "default-src" || names[j] == "default-src" {
+			return names[i] == "default-src"
+		}
+		return names[i] < names[j]
+	})
+
+	var directives []string
+	for _, name := range names {
+		directives = append(directives, strings.Join(append([]string{name}, c.Directives[name]...), " "))
+	}
+	if c.ReportURI != "" {
+		directives = append(directives, "report-uri "+c.ReportURI)
+	}
+
+	header := Header{Name: "Content-Security-Policy", Value: strings.Join(directives, "; ")}
+	if c.ReportOnly {
+		header.Name = "Content-Security-Policy-Report-Only"
+	}
+	return header
+}
+
+// normalizeCSPValues checks the values of a single directive and quotes the
+// keywords, nonces and hashes that must appear in single quotes.
+func normalizeCSPValues(directive string, values []string) ([]string, error) {
+	kind, ok := cspDirectives[directive]
+	if !ok {
+		return nil, fmt.Errorf("unknown directive %q", directive)
+	}
+
+	var normalized []string
+	for _, value := range values {
+		unquoted := strings.Trim(value, "'")
+
+		switch kind {
+		case cspNoValue:
+			return nil, fmt.Errorf("directive %s does not take any values", directive)
+		case cspSandbox:
+			if !cspSandboxTokens[value] {
+				return nil, fmt.Errorf("invalid sandbox flag %q", value)
+			}
+			normalized = append(normalized, value)
+		case cspTokens:
+			switch {
+			case cspKeywords[unquoted] && directive != "report-to":
+				normalized = append(normalized, "'"+unquoted+"'")
+			case cspTokenPattern.MatchString(value):
+				normalized = append(normalized, value)
+			default:
+				return nil, fmt.Errorf("invalid value %q for directive %s", value, directive)
+			}
+		case cspSourceList:
+			switch {
+			case cspKeywords[unquoted] && unquoted != "script" && unquoted != "allow-duplicates",
+				cspNonceOrHashPattern.MatchString(unquoted):
+				normalized = append(normalized, "'"+unquoted+"'")
+			case cspSchemePattern.MatchString(value), cspHostPattern.MatchString(value):
+				normalized = append(normalized, value)
+			default:
+				return nil, fmt.Errorf("invalid source expression %q for directive %s", value, directive)
+			}
+		}
+	}
+
+	if len(normalized) > 1 {
+		for _, value := range normalized {
+			if value == "'none'" {
+				return nil, fmt.Errorf("'none' cannot be combined with other sources in directive %s", directive)
+			}
+		}
+	}
+	if len(normalized) == 0 && (kind == cspSourceList || directive == "require-trusted-types-for" || directive == "report-to") {
+		return nil, fmt.Errorf("directive %s requires at least one value", directive)
+	}
+
+	return normalized, nil
+}
+
+func validCSPReportURI(uri string) bool {
+	return cspReportURIPattern.MatchString(uri)
+}
+
+func (sf *Finalizer) getContentSecurityPolicy(raw *ContentSecurityPolicyTemp) *ContentSecurityPolicy {
+	csp := &ContentSecurityPolicy{
+		Directives: make(map[string][]string),
+		ReportURI:  raw.ReportURI,
+	}
+
+	for directive, value := range raw.Directives {
+		var values []string
+		switch value := value.(type) {
+		case nil:
+		case []interface{}:
+			for _, item := range value {
+				values = append(values, fmt.Sprint(item))
+			}
+		default:
+			values = strings.Fields(fmt.Sprint(value))
+		}
+
+		normalized, err := normalizeCSPValues(directive, values)
+		if err != nil {
+			sf.Log.Warning("Ignoring content_security_policy directive %s: %s", directive, err.Error())
+			continue
+		}
+		csp.Directives[directive] = normalized
+	}
+
+	return csp
+}
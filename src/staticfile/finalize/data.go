@@ -18,53 +18,75 @@ const (
 
 export APP_ROOT=$HOME
 export LD_LIBRARY_PATH=$APP_ROOT/nginx/lib:$LD_LIBRARY_PATH
-
-sed -i "s#((APP_ROOT))#${APP_ROOT}#" "${APP_ROOT}/nginx/conf/nginx.conf"
-sed -i "s#((PORT))#${PORT}#" "${APP_ROOT}/nginx/conf/nginx.conf"
-
-if [[ -n "${ENABLE_HTTP2}" ]]; then
-	sed -i "s#((LISTEN_DIRECTIVE))#listen ${PORT} http2;#" "${APP_ROOT}/nginx/conf/nginx.conf"
-else
-	sed -i "s#((LISTEN_DIRECTIVE))#listen ${PORT};#" "${APP_ROOT}/nginx/conf/nginx.conf"
-fi
-
-if [[ -n "${FORCE_HTTPS}" ]]; then
-	sed -i 's#((FORCE_HTTPS_DIRECTIVE))#if ($best_proto != "https") { return 301 https://$best_host$best_prefix$request_uri; }#' "${APP_ROOT}/nginx/conf/nginx.conf"
-else
-	sed -i 's#((FORCE_HTTPS_DIRECTIVE))##' "${APP_ROOT}/nginx/conf/nginx.conf"
-fi
-
-if [[ ! -f $APP_ROOT/nginx/logs/access.log ]]; then
-    mkfifo $APP_ROOT/nginx/logs/access.log
-fi
-
-if [[ ! -f $APP_ROOT/nginx/logs/error.log ]]; then
-    mkfifo $APP_ROOT/nginx/logs/error.log
-fi
-`
-
-	startLoggingScript = `
-cat < $APP_ROOT/nginx/logs/access.log &
-(>&2 cat) < $APP_ROOT/nginx/logs/error.log &
 `
 
+	// startCommand keeps boot.sh working for apps that set it as their command.
 	startCommand = `#!/bin/sh
-set -ex
-$APP_ROOT/start_logging.sh
-nginx -p $APP_ROOT/nginx -c $APP_ROOT/nginx/conf/nginx.conf
+exec $HOME/.staticfile/launch
 `
 
 	nginxConfTemplate = `
 worker_processes 1;
 daemon off;
 
-error_log ((APP_ROOT))/nginx/logs/error.log;
+error_log ((APP_ROOT))/nginx/logs/error.log {{if .ErrorLogLevel}}{{.ErrorLogLevel}}{{else}}((ERROR_LOG_LEVEL)){{end}};
 events { worker_connections 1024; }
 
 http {
   charset utf-8;
+  {{with .AccessLog}}
+  {{if .ExcludePaths}}
+  map $log_path $log_path_included {
+    default 1;
+    {{range .PathPatterns}}
+    {{.}} 0;
+    {{end}}
+  }
+  {{end}}
+
+  {{if .ExcludeStatus}}
+  map $status $log_status_included {
+    default 1;
+    {{range .StatusPatterns}}
+    {{.}} 0;
+    {{end}}
+  }
+  {{end}}
+
+  {{if .ExcludeUserAgents}}
+  map $http_user_agent $log_agent_included {
+    default 1;
+    {{range .UserAgentPatterns}}
+    {{.}} 0;
+    {{end}}
+  }
+  {{end}}
+
+  {{with .Sample}}
+  split_clients $request_id $log_sample {
+    {{.}} 1;
+    * 0;
+  }
+
+  map $status $log_sampled {
+    default 1;
+    "~^[23]" $log_sample;
+  }
+  {{end}}
+
+  map "{{.Condition}}" $loggable {
+    default 1;
+    "~0" 0;
+  }
+  {{end}}
+
+  {{if eq .LogFormat "json"}}
+  log_format json escape=json '{"time":"$time_iso8601","request":"$request","method":"$request_method","uri":"$request_uri","status":$status,"bytes":$body_bytes_sent,"duration":$request_time,"upstream_time":"$upstream_response_time","host":"$host","remote_addr":"$remote_addr","forwarded_for":"$http_x_forwarded_for","referer":"$http_referer","user_agent":"$http_user_agent","vcap_request_id":"$http_x_vcap_request_id","trace_id":"$http_x_b3_traceid"}';
+  access_log ((APP_ROOT))/nginx/logs/access.log json{{if .AccessLog}} if=$loggable{{end}};
+  {{else}}
   log_format cloudfoundry '$http_x_forwarded_for - $http_referer - [$time_local] "$request" $status $body_bytes_sent';
-  access_log ((APP_ROOT))/nginx/logs/access.log cloudfoundry;
+  access_log ((APP_ROOT))/nginx/logs/access.log cloudfoundry{{if .AccessLog}} if=$loggable{{end}};
+  {{end}}
   default_type application/octet-stream;
   include mime.types;
   sendfile on;
@@ -79,6 +101,9 @@ http {
   gzip_static always;
   gzip_types text/plain text/css text/js text/xml text/javascript application/javascript application/x-javascript application/json application/xml application/xml+rss;
   gzip_vary on;
+  {{if .BrotliStatic}}
+  brotli_static on;
+  {{end}}
 
   tcp_nopush on;
   keepalive_timeout 30;
@@ -99,6 +124,108 @@ http {
     "~^([^,]+),?.*$" $1;
     ''               '';
   }
+
+  geo $dollar {
+    default "$";
+  }
+
+  {{with .MapHashBucketSize}}map_hash_bucket_size {{.}};{{end}}
+  {{with .MapHashMaxSize}}map_hash_max_size {{.}};{{end}}
+
+  {{if .HasCacheControl}}
+  map $uri $cache_control {
+    default "";
+    {{range .CacheControlMap}}
+    {{.Key}} {{.Policy}};
+    {{end}}
+  }
+  {{end}}
+
+  {{if .HasWebSocketProxy}}
+  map $http_upgrade $connection_upgrade {
+    default upgrade;
+    ''      close;
+  }
+  {{end}}
+
+  {{if .AuthRules}}
+  map $auth_path $auth_rule {
+    volatile;
+    default 0;
+    {{range .AuthRules}}
+    {{.Pattern}} {{.Index}};
+    {{end}}
+  }
+
+  {{if .BasicAuth}}
+  map $auth_rule $auth_basic_realm {
+    default "Restricted";
+    {{range .AuthRules}}{{if .BasicAuth}}
+    {{.Index}} {{.Realm}};
+    {{end}}{{end}}
+  }
+  {{end}}
+
+  {{if .HasIPRules}}
+  {{range .AuthRules}}{{if .HasIPRules}}
+  geo {{.IPVariable}} {
+    default {{if .Allow}}0{{else}}1{{end}};
+    {{range .Deny}}
+    {{.}} 0;
+    {{end}}
+    {{range .Allow}}
+    {{.}} 1;
+    {{end}}
+  }
+  {{end}}{{end}}
+
+  map $auth_rule $auth_ip_allowed {
+    default 1;
+    {{range .AuthRules}}{{if .HasIPRules}}
+    {{.Index}} {{.IPVariable}};
+    {{end}}{{end}}
+  }
+  {{end}}
+  {{end}}
+
+  {{with .Metrics}}
+  server {
+    listen unix:((APP_ROOT))/nginx/status.sock;
+    access_log off;
+
+    location = /stub_status {
+      stub_status;
+    }
+  }
+
+  {{if .Port}}
+  server {
+    listen {{.Port}};
+    access_log off;
+
+    {{template "metrics" $}}
+  }
+  {{end}}
+  {{end}}
+
+  {{with .RedirectEntries}}
+  map $uri $redirect {
+    default "";
+    {{range .}}
+    {{.Key}} {{.Value}};
+    {{end}}
+  }
+
+  map $redirect $redirect_status {
+    default "";
+    "~^(?<redirect_code>[0-9]+!?) " $redirect_code;
+  }
+
+  map $redirect $redirect_to {
+    default "";
+    "~^[0-9]+!? (?<redirect_target>.*)$" $redirect_target;
+  }
+  {{end}}
   
   server {
     {{if .EnableHttp2}}
@@ -110,6 +237,50 @@ http {
 
     root ((APP_ROOT))/public;
 
+    {{with .HealthCheck}}
+      if ($uri = {{.Path}}) {
+        break;
+      }
+    {{end}}
+
+    {{if and .Metrics (not .Metrics.Port)}}
+      if ($uri = {{.Metrics.Path}}) {
+        break;
+      }
+    {{end}}
+
+    {{if .AuthRules}}
+      set $auth_path $uri;
+    {{end}}
+
+    {{if and .AccessLog .AccessLog.ExcludePaths}}
+      set $log_path $uri;
+    {{end}}
+
+    {{if .UsesClientAddress}}
+      {{range .RealIPFrom}}
+      set_real_ip_from {{.}};
+      {{end}}
+      real_ip_header X-Forwarded-For;
+      real_ip_recursive on;
+    {{end}}
+
+    {{if .HasIPRules}}
+      if ($auth_ip_allowed = 0) {
+        return 403;
+      }
+    {{end}}
+
+    {{if and .AuthRules .BasicAuth}}
+      auth_basic $auth_basic_realm;
+      auth_basic_user_file ((APP_ROOT))/nginx/conf/.htpasswd;
+    {{end}}
+
+    {{if .SSO}}
+      auth_request /_sso/auth;
+      error_page 401 = @sso_login;
+    {{end}}
+
     {{if .ForceHTTPS}}
 
       if ($best_proto != "https") {
@@ -119,8 +290,184 @@ http {
 		((FORCE_HTTPS_DIRECTIVE))
     {{end}}
 
+    {{range .RedirectCodes}}
+      if ($redirect_status = {{.}}) {
+        {{if eq . "200!"}}rewrite ^ $redirect_to last;{{else}}return {{.}} $redirect_to;{{end}}
+      }
+    {{end}}
+
+
+    {{if .LocationPrefix}}
+    location ^~ {{.LocationPrefix}}/ {
+      rewrite ^{{.LocationPrefix}}(/.*)$ $1 break;
+      {{template "location" .Root}}
+    }
 
     location / {
+      return 404;
+    }
+    {{else}}
+    location / {
+      {{template "location" .Root}}
+    }
+    {{end}}
+
+    {{range .RuntimeEnvLocations}}
+    location = {{.Path}} {
+      root ((APP_ROOT))/nginx/runtime;
+      {{template "location" .Location}}
+    }
+    {{end}}
+
+    {{if .HasRewrites}}
+    location @redirect {
+      if ($redirect_status != 200) {
+        return 404;
+      }
+      rewrite ^ $redirect_to last;
+    }
+    {{end}}
+
+    {{if not .HostDotFiles}}
+      location ~ /\. {
+        deny all;
+        return 404;
+      }
+    {{end}}
+
+    {{range .Locations}}
+    location {{.Match}} {
+      {{template "location" .}}
+    }
+    {{end}}
+
+    {{range .Proxies}}
+    location ^~ {{.Location}} {
+      {{if and $.BasicAuth (not $.AuthRules)}}
+      auth_basic "Restricted";
+      auth_basic_user_file ((APP_ROOT))/nginx/conf/.htpasswd;
+      {{end}}
+      {{if $.HSTS}}
+      add_header Strict-Transport-Security "max-age=31536000{{if $.HSTSIncludeSubDomains}}; includeSubDomains{{end}}{{if $.HSTSPreload}}; preload{{end}}";
+      {{end}}
+      {{with $.ContentSecurityPolicy}}{{with .Header}}
+      add_header {{.Name}} {{.QuotedValue}};
+      {{end}}{{end}}
+      proxy_pass {{.Upstream}};
+      proxy_http_version 1.1;
+      proxy_ssl_server_name on;
+      {{if .SSLVerify}}
+      proxy_ssl_verify on;
+      proxy_ssl_trusted_certificate /etc/ssl/certs/ca-certificates.crt;
+      {{end}}
+      {{with .ConnectTimeout}}
+      proxy_connect_timeout {{.}};
+      {{end}}
+      {{with .Timeout}}
+      proxy_read_timeout {{.}};
+      proxy_send_timeout {{.}};
+      {{end}}
+      proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+      proxy_set_header X-Forwarded-Host $best_host;
+      proxy_set_header X-Forwarded-Proto $http_x_forwarded_proto;
+      {{if .WebSocket}}
+      proxy_set_header Upgrade $http_upgrade;
+      proxy_set_header Connection $connection_upgrade;
+      {{end}}
+      {{range .Headers}}
+      proxy_set_header {{.Name}} {{.QuotedValue}};
+      {{end}}
+    }
+    {{end}}
+
+    {{with .HealthCheck}}
+    location = {{.Path}} {
+      auth_basic off;
+      {{if $.SSO}}
+      auth_request off;
+      {{end}}
+      access_log off;
+      default_type application/json;
+      add_header Cache-Control "no-store" always;
+      {{if .Readiness}}
+      if (!-f ((APP_ROOT))/.staticfile/ready) {
+        return 503 {{.Body "unavailable"}};
+      }
+      {{end}}
+      return 200 {{.Body "ok"}};
+    }
+    {{end}}
+
+    {{if and .Metrics (not .Metrics.Port)}}
+    {{template "metrics" .}}
+    {{end}}
+
+    {{with .SBOMRoute}}
+    location = {{.Path}} {
+      {{if $.BasicAuth}}
+      auth_basic "SBOM";
+      auth_basic_user_file ((APP_ROOT))/nginx/conf/.htpasswd;
+      {{end}}
+      types { }
+      default_type application/vnd.cyclonedx+json;
+      add_header Cache-Control "no-store" always;
+      alias ((APP_ROOT))/.staticfile/sbom.cdx.json;
+    }
+    {{end}}
+
+    {{if .SSO}}
+    location = /_sso/auth {
+      internal;
+      proxy_pass http://unix:((APP_ROOT))/nginx/sso.sock;
+      proxy_pass_request_body off;
+      proxy_set_header Content-Length "";
+      proxy_set_header X-Forwarded-Host $best_host;
+    }
+
+    location @sso_login {
+      rewrite ^ /_sso/login break;
+      proxy_pass http://unix:((APP_ROOT))/nginx/sso.sock;
+      proxy_pass_request_body off;
+      proxy_set_header Content-Length "";
+      proxy_set_header X-Original-URI $request_uri;
+      proxy_set_header X-Forwarded-Host $best_host;
+    }
+
+    location ^~ /_sso/ {
+      auth_request off;
+      proxy_pass http://unix:((APP_ROOT))/nginx/sso.sock;
+      proxy_set_header X-Forwarded-Host $best_host;
+    }
+    {{end}}
+  }
+}
+`
+
+	// nginxMetricsTemplate serves the exporter either on the app route or on its
+	// own port, behind basic authentication, the allow list, or both.
+	nginxMetricsTemplate = `{{define "metrics"}}
+    location = {{.Metrics.Path}} {
+      {{if .BasicAuth}}
+      auth_basic "Metrics";
+      auth_basic_user_file ((APP_ROOT))/nginx/conf/.htpasswd;
+      {{else}}
+      auth_basic off;
+      {{end}}
+      {{range .Metrics.Allow}}
+      allow {{.}};
+      {{end}}
+      {{if or .Metrics.Allow (not .BasicAuth)}}
+      deny all;
+      {{end}}
+      {{if .SSO}}
+      auth_request off;
+      {{end}}
+      access_log off;
+      proxy_pass http://unix:((APP_ROOT))/nginx/metrics.sock;
+    }
+{{end}}`
+
+	nginxLocationTemplate = `{{define "location"}}
       {{if .PushState}}
         if (!-e $request_filename) {
           rewrite ^(.*)$ / break;
@@ -129,12 +476,16 @@ http {
 
         index index.html index.htm Default.htm;
 
+      {{if .HasRewrites}}
+        try_files $uri $uri/ @redirect;
+      {{end}}
+
       {{if .DirectoryIndex}}
         autoindex on;
         absolute_redirect off;
       {{end}}
 
-      {{if .BasicAuth}}
+      {{if and .BasicAuth (not .AuthRules)}}
         auth_basic "Restricted";  #For Basic Auth
         auth_basic_user_file ((APP_ROOT))/nginx/conf/.htpasswd;
       {{end}}
@@ -147,24 +498,31 @@ http {
         add_header Strict-Transport-Security "max-age=31536000{{if .HSTSIncludeSubDomains}}; includeSubDomains{{end}}{{if .HSTSPreload}}; preload{{end}}";
       {{end}}
 
+      {{with .ContentSecurityPolicy}}{{with .Header}}
+        add_header {{.Name}} {{.QuotedValue}};
+      {{end}}{{end}}
+
       {{if ne .LocationInclude ""}}
         include {{.LocationInclude}};
       {{end}}
 
+      {{if .HasCacheControl}}
+        add_header Cache-Control $cache_control;
+      {{end}}
+
+      {{range .Headers}}
+        add_header {{.Name}} {{.QuotedValue}} always;
+      {{end}}
+
+      {{if .SSO}}
+        error_page 401 = @sso_login;
+      {{end}}
+
       {{ range $code, $value := .StatusCodes }}
         error_page {{ $code }} {{ $value }};
       {{ end }}
-    }
+{{end}}`
 
-    {{if not .HostDotFiles}}
-      location ~ /\. {
-        deny all;
-        return 404;
-      }
-    {{end}}
-  }
-}
-`
 	MimeTypes = `
 types {
   text/html html htm shtml;
@@ -2,6 +2,7 @@ package finalize
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	"bytes"
 
 	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/detect"
 )
 
 type Staticfile struct {
@@ -25,33 +27,87 @@ type Staticfile struct {
 	ForceHTTPS            bool   `yaml:"force_https"`
 	EnableHttp2           bool   `yaml:"enable_http2"`
 	BasicAuth             bool
-	StatusCodes           map[string]string `yaml:"status_codes"`
+	StatusCodes           map[string]string            `yaml:"status_codes"`
+	Headers               map[string]string            `yaml:"headers"`
+	PathHeaders           map[string]map[string]string `yaml:"-"`
+	ContentSecurityPolicy *ContentSecurityPolicy       `yaml:"content_security_policy"`
+	CacheControl          []CacheControlRule           `yaml:"cache_control"`
+	FingerprintedAssets   bool                         `yaml:"fingerprinted_assets"`
+	FingerprintedFiles    []string                     `yaml:"-"`
+	Precompress           bool                         `yaml:"precompress"`
+	BrotliStatic          bool                         `yaml:"-"`
+	Redirects             []Redirect                   `yaml:"redirects"`
+	Proxies               []Proxy                      `yaml:"proxies"`
+	RuntimeEnv            *RuntimeEnv                  `yaml:"runtime_env"`
+	BasicAuthSource       *BasicAuthSource             `yaml:"basic_auth"`
+	AuthRules             []AuthRule                   `yaml:"auth"`
+	SSO                   *SSO                         `yaml:"sso"`
+	LogFormat             string                       `yaml:"log_format"`
+	AccessLog             *AccessLog                   `yaml:"access_log"`
+	ErrorLogLevel         string                       `yaml:"error_log_level"`
+	ErrorLogInstanceIndex bool                         `yaml:"error_log_instance_index"`
+	HealthCheck           *HealthCheck                 `yaml:"health_check"`
+	Metrics               *Metrics                     `yaml:"metrics"`
+	SBOMRoute             *SBOMRoute                   `yaml:"sbom_route"`
+	TrustedProxies        []string                     `yaml:"trusted_proxies"`
+	// LocationPrefix serves public under a path prefix instead of at /. It is
+	// not a Staticfile key, supply sets it for the template in its conf dir.
+	LocationPrefix string `yaml:"-"`
 }
 
 type YAML interface {
 	Load(string, interface{}) error
 }
 
+type Command interface {
+	Execute(string, io.Writer, io.Writer, string, ...string) error
+}
+
 type Finalizer struct {
-	BuildDir string
-	DepDir   string
-	Log      *libbuildpack.Logger
-	Config   Staticfile
-	YAML     YAML
+	BuildDir         string
+	DepDir           string
+	Log              *libbuildpack.Logger
+	Config           Staticfile
+	YAML             YAML
+	Command          Command
+	LaunchBinary     string
+	SSOBinary        string
+	Stack            string
+	BuildpackVersion string
 }
+
 type StaticfileTemp struct {
-	RootDir               string            `yaml:"root,omitempty"`
-	HostDotFiles          string            `yaml:"host_dot_files,omitempty"`
-	LocationInclude       string            `yaml:"location_include"`
-	DirectoryIndex        string            `yaml:"directory"`
-	SSI                   string            `yaml:"ssi"`
-	PushState             string            `yaml:"pushstate"`
-	HSTS                  string            `yaml:"http_strict_transport_security"`
-	HSTSIncludeSubDomains string            `yaml:"http_strict_transport_security_include_subdomains"`
-	HSTSPreload           string            `yaml:"http_strict_transport_security_preload"`
-	ForceHTTPS            string            `yaml:"force_https"`
-	EnableHttp2           string            `yaml:"enable_http2"`
-	StatusCodes           map[string]string `yaml:"status_codes"`
+	RootDir               string                     `yaml:"root,omitempty"`
+	HostDotFiles          string                     `yaml:"host_dot_files,omitempty"`
+	LocationInclude       string                     `yaml:"location_include"`
+	DirectoryIndex        string                     `yaml:"directory"`
+	SSI                   string                     `yaml:"ssi"`
+	PushState             string                     `yaml:"pushstate"`
+	HSTS                  string                     `yaml:"http_strict_transport_security"`
+	HSTSIncludeSubDomains string                     `yaml:"http_strict_transport_security_include_subdomains"`
+	HSTSPreload           string                     `yaml:"http_strict_transport_security_preload"`
+	ForceHTTPS            string                     `yaml:"force_https"`
+	EnableHttp2           string                     `yaml:"enable_http2"`
+	StatusCodes           map[string]string          `yaml:"status_codes"`
+	Headers               map[string]interface{}     `yaml:"headers"`
+	ContentSecurityPolicy *ContentSecurityPolicyTemp `yaml:"content_security_policy"`
+	CacheControl          []map[string]string        `yaml:"cache_control"`
+	FingerprintedAssets   string                     `yaml:"fingerprinted_assets"`
+	Precompress           string                     `yaml:"precompress"`
+	Redirects             []interface{}              `yaml:"redirects"`
+	Proxies               map[string]ProxyTemp       `yaml:"proxies"`
+	RuntimeEnv            *RuntimeEnvTemp            `yaml:"runtime_env"`
+	BasicAuth             *BasicAuthSourceTemp       `yaml:"basic_auth"`
+	Auth                  map[string]AuthRuleTemp    `yaml:"auth"`
+	SSO                   *SSOTemp                   `yaml:"sso"`
+	LogFormat             string                     `yaml:"log_format"`
+	AccessLog             *AccessLogTemp             `yaml:"access_log"`
+	ErrorLogLevel         string                     `yaml:"error_log_level"`
+	ErrorLogInstanceIndex string                     `yaml:"error_log_instance_index"`
+	HealthCheck           interface{}                `yaml:"health_check"`
+	Metrics               interface{}                `yaml:"metrics"`
+	SBOMRoute             interface{}                `yaml:"sbom_route"`
+	TrustedProxies        []string                   `yaml:"trusted_proxies"`
 }
 
 var skipCopyFile = map[string]bool{
@@ -94,6 +150,20 @@ func Run(sf *Finalizer) error {
 		return err
 	}
 
+	err = sf.TestNginxConf()
+	if err != nil {
+		sf.Log.Error("Invalid nginx configuration: %s", err.Error())
+		return err
+	}
+
+	// ConfigureNginx moves nginx.conf, mime.types and _redirects out of
+	// public, so the SBOM is written once public holds what is served.
+	err = sf.WriteSBOM()
+	if err != nil {
+		sf.Log.Error("Unable to write the SBOM: %s", err.Error())
+		return err
+	}
+
 	err = sf.WriteStartupFiles()
 	if err != nil {
 		sf.Log.Error("Unable to write startup file: %s", err.Error())
@@ -114,8 +184,7 @@ func (sf *Finalizer) WriteStartupFiles() error {
 		return err
 	}
 
-	err = os.WriteFile(filepath.Join(sf.BuildDir, "start_logging.sh"), []byte(startLoggingScript), 0755)
-	if err != nil {
+	if err := sf.WriteLauncher(); err != nil {
 		return err
 	}
 
@@ -127,7 +196,15 @@ func (sf *Finalizer) LoadStaticfile() error {
 	var hash StaticfileTemp
 	conf := &sf.Config
 
-	err := sf.YAML.Load(filepath.Join(sf.BuildDir, "Staticfile"), &hash)
+	if err := sf.ValidateStaticfile(); err != nil {
+		return err
+	}
+
+	staticfile, _, err := detect.FindStaticfile(sf.BuildDir, sf.Config.RootDir)
+	if err != nil {
+		return err
+	}
+	err = sf.YAML.Load(staticfile, &hash)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
@@ -185,10 +262,96 @@ func (sf *Finalizer) LoadStaticfile() error {
 		sf.Log.BeginStep("Enabling HTTPS redirect")
 		conf.ForceHTTPS = true
 	}
+	conf.LogFormat = logFormatPlain
+	if hash.LogFormat == logFormatJSON {
+		sf.Log.BeginStep("Enabling JSON access logs")
+		conf.LogFormat = logFormatJSON
+	}
+	if raw := hash.AccessLog; raw != nil {
+		accessLog := AccessLog{
+			ExcludePaths:      raw.ExcludePaths,
+			ExcludeStatus:     raw.ExcludeStatus,
+			ExcludeUserAgents: raw.ExcludeUserAgents,
+			Sample:            raw.Sample,
+		}
+		if accessLog.Condition() != "" {
+			sf.Log.BeginStep("Filtering the access log, %s", accessLog)
+			conf.AccessLog = &accessLog
+		}
+	}
+	if hash.ErrorLogLevel != "" {
+		sf.Log.BeginStep("Setting the error log level to %s", hash.ErrorLogLevel)
+		conf.ErrorLogLevel = hash.ErrorLogLevel
+	}
+	if isEnabled(hash.ErrorLogInstanceIndex) {
+		sf.Log.BeginStep("Prefixing error log lines with the instance index")
+		conf.ErrorLogInstanceIndex = true
+	}
+	conf.HealthCheck, err = sf.getHealthCheck(hash.HealthCheck, isEnabled)
+	if err != nil {
+		return err
+	}
+	if conf.HealthCheck != nil {
+		if conf.HealthCheck.Readiness {
+			sf.Log.BeginStep("Enabling the health check at %s, answering 503 until the app has started and once it stops", conf.HealthCheck.Path)
+		} else {
+			sf.Log.BeginStep("Enabling the health check at %s", conf.HealthCheck.Path)
+		}
+	}
 	if len(hash.StatusCodes) > 0 {
 		sf.Log.BeginStep("Enabling custom pages for status_codes")
 		conf.StatusCodes = sf.getStatusCodes(hash.StatusCodes)
 	}
+	if len(hash.Headers) > 0 {
+		sf.Log.BeginStep("Enabling custom headers")
+		conf.Headers, conf.PathHeaders = sf.getHeaders(hash.Headers)
+	}
+	if hash.ContentSecurityPolicy != nil {
+		conf.ContentSecurityPolicy = sf.getContentSecurityPolicy(hash.ContentSecurityPolicy)
+		conf.ContentSecurityPolicy.ReportOnly = isEnabled(hash.ContentSecurityPolicy.ReportOnly)
+		if conf.ContentSecurityPolicy.ReportOnly {
+			sf.Log.BeginStep("Enabling Content-Security-Policy in report only mode")
+		} else {
+			sf.Log.BeginStep("Enabling Content-Security-Policy")
+		}
+		for name := range conf.Headers {
+			if strings.HasPrefix(strings.ToLower(name), "content-security-policy") {
+				sf.Log.Warning("Both content_security_policy and a %s header are set in the Staticfile, browsers will enforce both policies.", name)
+			}
+		}
+	}
+
+	if len(hash.CacheControl) > 0 {
+		sf.Log.BeginStep("Enabling cache-control rules")
+		conf.CacheControl = sf.getCacheControl(hash.CacheControl)
+	}
+	if isEnabled(hash.FingerprintedAssets) {
+		sf.Log.BeginStep("Enabling long term caching of fingerprinted assets")
+		conf.FingerprintedAssets = true
+	}
+	if isEnabled(hash.Precompress) {
+		sf.Log.BeginStep("Enabling precompression of assets")
+		conf.Precompress = true
+	}
+	if len(hash.Redirects) > 0 {
+		sf.Log.BeginStep("Enabling redirects")
+		conf.Redirects = sf.getRedirects(hash.Redirects)
+	}
+	if len(hash.Proxies) > 0 {
+		sf.Log.BeginStep("Enabling proxies")
+		conf.Proxies = sf.getProxies(hash.Proxies, isEnabled)
+	}
+	if hash.RuntimeEnv != nil {
+		conf.RuntimeEnv = sf.getRuntimeEnv(hash.RuntimeEnv)
+		sf.Log.BeginStep("Enabling runtime environment variables in %s", conf.RuntimeEnv.Path)
+	}
+	if conf.HasCacheControl() {
+		for name := range conf.Headers {
+			if strings.EqualFold(name, "Cache-Control") {
+				sf.Log.Warning("Both cache_control or fingerprinted_assets and a Cache-Control header are set in the Staticfile, responses may carry two Cache-Control headers.")
+			}
+		}
+	}
 
 	if !conf.HSTS && (conf.HSTSIncludeSubDomains || conf.HSTSPreload) {
 		sf.Log.Warning("http_strict_transport_security is not enabled while http_strict_transport_security_include_subdomains or http_strict_transport_security_preload have been enabled.")
@@ -197,10 +360,88 @@ func (sf *Finalizer) LoadStaticfile() error {
 
 	authFile := filepath.Join(sf.BuildDir, "Staticfile.auth")
 	_, err = os.Stat(authFile)
-	if err == nil {
+	if hash.BasicAuth != nil {
+		conf.BasicAuth = true
+		conf.BasicAuthSource = getBasicAuthSource(hash.BasicAuth)
+		sf.Log.BeginStep("Enabling basic authentication using %s", conf.BasicAuthSource)
+		if err == nil {
+			sf.Log.Warning("Staticfile.auth is ignored, as basic_auth is set in the Staticfile.")
+		}
+	} else if err == nil {
 		conf.BasicAuth = true
 		sf.Log.BeginStep("Enabling basic authentication using Staticfile.auth")
 		sf.Log.Protip("Learn about basic authentication", "https://docs.cloudfoundry.org/buildpacks/staticfile/index.html#authentication")
+
+		if err := sf.ValidateAuthFile(); err != nil {
+			return err
+		}
+	}
+
+	if len(hash.Auth) > 0 {
+		sf.Log.BeginStep("Enabling access rules")
+		conf.AuthRules = sf.getAuthRules(hash.Auth, isEnabled)
+		for _, rule := range conf.AuthRules {
+			if rule.BasicAuth != nil && *rule.BasicAuth && !conf.BasicAuth {
+				return fmt.Errorf("auth requires credentials for %s, but there is no Staticfile.auth and basic_auth is not set", rule.Path)
+			}
+		}
+	}
+
+	if hash.SSO != nil {
+		if conf.BasicAuth {
+			return fmt.Errorf("sso cannot be combined with basic authentication, remove Staticfile.auth or basic_auth")
+		}
+		conf.SSO = &SSO{
+			Service:     hash.SSO.Service,
+			Groups:      hash.SSO.Groups,
+			GroupsClaim: hash.SSO.GroupsClaim,
+			Scopes:      hash.SSO.Scopes,
+		}
+		sf.Log.BeginStep("Enabling single sign-on using %s", conf.SSO)
+	}
+
+	conf.Metrics, err = sf.getMetrics(hash.Metrics, isEnabled)
+	if err != nil {
+		return err
+	}
+	if conf.Metrics != nil {
+		if conf.Metrics.Port == 0 && conf.HealthCheck != nil && conf.HealthCheck.Path == conf.Metrics.Path {
+			return fmt.Errorf("metrics and health_check cannot both use %s", conf.Metrics.Path)
+		}
+		switch {
+		case conf.BasicAuth && len(conf.Metrics.Allow) > 0:
+			sf.Log.BeginStep("Enabling Prometheus metrics %s, behind basic authentication and restricted to %s", conf.Metrics, strings.Join(conf.Metrics.Allow, ", "))
+		case conf.BasicAuth:
+			sf.Log.BeginStep("Enabling Prometheus metrics %s, behind basic authentication", conf.Metrics)
+		default:
+			sf.Log.BeginStep("Enabling Prometheus metrics %s, restricted to %s", conf.Metrics, strings.Join(conf.Metrics.Allow, ", "))
+		}
+	}
+
+	conf.SBOMRoute, err = sf.getSBOMRoute(hash.SBOMRoute, isEnabled)
+	if err != nil {
+		return err
+	}
+	if conf.SBOMRoute != nil {
+		if !conf.BasicAuth && conf.SSO == nil {
+			return fmt.Errorf("sbom_route needs basic authentication or sso, add Staticfile.auth, basic_auth or sso")
+		}
+		if conf.HealthCheck != nil && conf.HealthCheck.Path == conf.SBOMRoute.Path {
+			return fmt.Errorf("sbom_route and health_check cannot both use %s", conf.SBOMRoute.Path)
+		}
+		if conf.Metrics != nil && conf.Metrics.Port == 0 && conf.Metrics.Path == conf.SBOMRoute.Path {
+			return fmt.Errorf("sbom_route and metrics cannot both use %s", conf.SBOMRoute.Path)
+		}
+		sf.Log.BeginStep("Serving the SBOM at %s", conf.SBOMRoute.Path)
+	}
+
+	conf.TrustedProxies = hash.TrustedProxies
+	if conf.UsesClientAddress() {
+		if len(conf.TrustedProxies) > 0 {
+			sf.Log.BeginStep("Taking the client address from X-Forwarded-For, as set by %s", strings.Join(conf.TrustedProxies, ", "))
+		} else {
+			sf.Log.Warning("Taking the client address from X-Forwarded-For, as set by any proxy at a private address. A client at a private address can pick its own, set trusted_proxies to the addresses of the routers and load balancers in front of the app.")
+		}
 	}
 
 	return nil
@@ -220,6 +461,24 @@ func (sf *Finalizer) getStatusCodes(codes map[string]string) map[string]string {
 	return versions
 }
 
+func (sf *Finalizer) getHeaders(raw map[string]interface{}) (map[string]string, map[string]map[string]string) {
+	headers := make(map[string]string)
+	pathHeaders := make(map[string]map[string]string)
+	for key, value := range raw {
+		nested, ok := value.(map[interface{}]interface{})
+		if !ok {
+			headers[key] = fmt.Sprint(value)
+			continue
+		}
+
+		pathHeaders[key] = make(map[string]string)
+		for name, value := range nested {
+			pathHeaders[key][fmt.Sprint(name)] = fmt.Sprint(value)
+		}
+	}
+	return headers, pathHeaders
+}
+
 func (sf *Finalizer) GetAppRootDir() (string, error) {
 	var rootDirRelative string
 
@@ -253,10 +512,20 @@ func (sf *Finalizer) CopyFilesToPublic(appRootDir string) error {
 
 	publicDir := filepath.Join(sf.BuildDir, "public")
 
-	if publicDir == appRootDir {
-		return nil
+	if publicDir != appRootDir {
+		if err := sf.moveFilesToPublic(appRootDir, publicDir); err != nil {
+			return err
+		}
 	}
 
+	if sf.Config.Precompress {
+		return sf.PrecompressAssets(publicDir)
+	}
+
+	return nil
+}
+
+func (sf *Finalizer) moveFilesToPublic(appRootDir, publicDir string) error {
 	tmpDir, err := os.MkdirTemp("", "staticfile-buildpack.approot.")
 	if err != nil {
 		return err
@@ -309,6 +578,25 @@ func (sf *Finalizer) ConfigureNginx() error {
 
 	sf.Log.BeginStep("Configuring nginx")
 
+	if sf.Config.FingerprintedAssets {
+		sf.Config.FingerprintedFiles, err = sf.FindFingerprintedAssets()
+		if err != nil {
+			return err
+		}
+		sf.Log.Info("Marking %d fingerprinted assets as immutable", len(sf.Config.FingerprintedFiles))
+	}
+
+	if err := sf.LoadRedirectsFile(); err != nil {
+		return err
+	}
+
+	if sf.Config.RuntimeEnv != nil {
+		sf.Config.RuntimeEnv.Files, err = sf.FindRuntimeEnvFiles()
+		if err != nil {
+			return err
+		}
+	}
+
 	nginxConf, err := sf.generateNginxConf()
 	if err != nil {
 		sf.Log.Error("Unable to generate nginx.conf: %s", err.Error())
@@ -348,7 +636,7 @@ func (sf *Finalizer) ConfigureNginx() error {
 		}
 	}
 
-	if sf.Config.BasicAuth {
+	if sf.Config.BasicAuth && sf.Config.BasicAuthSource == nil {
 		authFile := filepath.Join(sf.BuildDir, "Staticfile.auth")
 		err = libbuildpack.CopyFile(authFile, filepath.Join(confDir, ".htpasswd"))
 		if err != nil {
@@ -359,12 +647,22 @@ func (sf *Finalizer) ConfigureNginx() error {
 	return nil
 }
 
+// NginxConfTemplate is the nginx.conf that finalize writes for config, with the
+// ((PLACEHOLDERS)) that the launcher fills in still in place.
+func NginxConfTemplate(config Staticfile) (string, error) {
+	sf := &Finalizer{Config: config}
+	return sf.generateNginxConf()
+}
+
 func (sf *Finalizer) generateNginxConf() (string, error) {
 	buffer := new(bytes.Buffer)
 
 	t := template.Must(template.New("nginx.conf").Parse(nginxConfTemplate))
+	template.Must(t.Parse(nginxLocationTemplate))
+	template.Must(t.Parse(nginxMetricsTemplate))
 
-	err := t.Execute(buffer, sf.Config)
+	root, locations := sf.locations()
+	err := t.Execute(buffer, nginxConfData{Staticfile: sf.Config, Root: root, Locations: locations})
 	if err != nil {
 		return "", err
 	}
@@ -1,13 +1,20 @@
 package finalize_test
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"syscall"
 
 	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/finalize"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/launch"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/sso"
 
 	"bytes"
 
@@ -27,42 +34,59 @@ var _ = Describe("Compile", func() {
 		err        error
 		buildDir   string
 		depDir     string
+		launchDir  string
 		finalizer  *finalize.Finalizer
 		logger     *libbuildpack.Logger
 		mockCtrl   *gomock.Controller
 		mockYaml   *MockYAML
+		mockCmd    *MockCommand
 		buffer     *bytes.Buffer
 		data       []byte
 	)
 
 	BeforeEach(func() {
+		staticfile = finalize.Staticfile{}
+
 		buildDir, err = os.MkdirTemp("", "staticfile-buildpack.build.")
 		Expect(err).To(BeNil())
 
 		depDir, err = os.MkdirTemp("", "staticfile-buildpack.depDir.")
 		Expect(err).To(BeNil())
 
+		launchDir, err = os.MkdirTemp("", "staticfile-buildpack.launch.")
+		Expect(err).To(BeNil())
+		Expect(os.WriteFile(filepath.Join(launchDir, "launch"), []byte("launcher"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(launchDir, "sso"), []byte("sidecar"), 0755)).To(Succeed())
+
 		buffer = new(bytes.Buffer)
 		logger = libbuildpack.NewLogger(ansicleaner.New(buffer))
 
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockYaml = NewMockYAML(mockCtrl)
+		mockCmd = NewMockCommand(mockCtrl)
 		DeferCleanup(func() {
 			err = os.RemoveAll(buildDir)
 			Expect(err).To(BeNil())
 
 			err = os.RemoveAll(depDir)
 			Expect(err).To(BeNil())
+
+			err = os.RemoveAll(launchDir)
+			Expect(err).To(BeNil())
 		})
 	})
 
 	JustBeforeEach(func() {
 		finalizer = &finalize.Finalizer{
-			BuildDir: buildDir,
-			DepDir:   depDir,
-			Config:   staticfile,
-			YAML:     mockYaml,
-			Log:      logger,
+			BuildDir:         buildDir,
+			DepDir:           depDir,
+			Config:           staticfile,
+			YAML:             mockYaml,
+			Command:          mockCmd,
+			Log:              logger,
+			LaunchBinary:     filepath.Join(launchDir, "launch"),
+			SSOBinary:        filepath.Join(launchDir, "sso"),
+			BuildpackVersion: "1.6.0",
 		}
 	})
 
@@ -76,22 +100,174 @@ var _ = Describe("Compile", func() {
 			Expect(string(contents)).To(ContainSubstring("export LD_LIBRARY_PATH=$APP_ROOT/nginx/lib:$LD_LIBRARY_PATH"))
 		})
 
-		It("writes start_logging.sh in appdir", func() {
+		It("copies the launcher into the droplet", func() {
 			err = finalizer.WriteStartupFiles()
 			Expect(err).To(BeNil())
 
-			contents, err := os.ReadFile(filepath.Join(buildDir, "start_logging.sh"))
+			contents, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "launch"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal("launcher"))
+
+			fi, err := os.Stat(filepath.Join(buildDir, ".staticfile", "launch"))
 			Expect(err).To(BeNil())
-			Expect(string(contents)).To(Equal("\ncat < $APP_ROOT/nginx/logs/access.log &\n(>&2 cat) < $APP_ROOT/nginx/logs/error.log &\n"))
+			Expect(fi.Mode().Perm() & 0111).NotTo(Equal(os.FileMode(0000)))
 		})
 
-		It("start_logging.sh is an executable file", func() {
+		It("writes an empty launch.json when runtime_env is not set", func() {
 			err = finalizer.WriteStartupFiles()
 			Expect(err).To(BeNil())
 
-			fi, err := os.Stat(filepath.Join(buildDir, "start_logging.sh"))
+			contents, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "launch.json"))
 			Expect(err).To(BeNil())
-			Expect(fi.Mode().Perm() & 0111).NotTo(Equal(os.FileMode(0000)))
+			Expect(string(contents)).To(Equal("{}"))
+		})
+
+		Context("runtime_env is set", func() {
+			BeforeEach(func() {
+				staticfile.RuntimeEnv = &finalize.RuntimeEnv{
+					Variables:  []string{"API_URL", "TITLE"},
+					Path:       "/config.json",
+					Substitute: []string{"/index.html", "/assets/*", "/missing/*"},
+				}
+				Expect(os.MkdirAll(filepath.Join(buildDir, "public", "assets"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "public", "index.html"), []byte(`<title>${TITLE}</title>`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "public", "assets", "app.js"), []byte(`fetch("${API_URL}")`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "public", "assets", "vendor.js"), []byte(`no placeholders`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "public", "assets", "logo.svg"), []byte(`${TITLE}`), 0644)).To(Succeed())
+			})
+
+			JustBeforeEach(func() {
+				Expect(finalizer.ConfigureNginx()).To(Succeed())
+				err = finalizer.WriteStartupFiles()
+				Expect(err).To(BeNil())
+			})
+
+			It("writes the allowed variables and the files that contain placeholders to launch.json", func() {
+				contents, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "launch.json"))
+				Expect(err).To(BeNil())
+
+				var config launch.Config
+				Expect(json.Unmarshal(contents, &config)).To(Succeed())
+				Expect(config.RuntimeEnv).To(Equal(&launch.RuntimeEnv{
+					Variables: []string{"API_URL", "TITLE"},
+					Path:      "/config.json",
+					Files: []launch.RuntimeEnvFile{
+						{Path: "assets/app.js", Escape: "json"},
+						{Path: "index.html", Escape: "html"},
+					},
+				}))
+			})
+
+			It("warns about files it cannot escape values for and globs that match nothing", func() {
+				Expect(buffer.String()).To(ContainSubstring("Not substituting environment variables in /assets/logo.svg"))
+				Expect(buffer.String()).To(ContainSubstring("The runtime_env substitute glob /missing/* does not match any files."))
+			})
+		})
+
+		Context("basic_auth is set", func() {
+			BeforeEach(func() {
+				staticfile.BasicAuthSource = &finalize.BasicAuthSource{Env: "SITE_USERS", Service: "site-users"}
+			})
+
+			It("writes the source of the users to launch.json", func() {
+				err = finalizer.WriteStartupFiles()
+				Expect(err).To(BeNil())
+
+				contents, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "launch.json"))
+				Expect(err).To(BeNil())
+
+				var config launch.Config
+				Expect(json.Unmarshal(contents, &config)).To(Succeed())
+				Expect(config.BasicAuth).To(Equal(&launch.BasicAuth{Env: "SITE_USERS", Service: "site-users"}))
+			})
+		})
+
+		Context("sso is set", func() {
+			BeforeEach(func() {
+				staticfile.SSO = &finalize.SSO{Service: "corp-sso", Groups: []string{"staff"}}
+			})
+
+			JustBeforeEach(func() {
+				err = finalizer.WriteStartupFiles()
+				Expect(err).To(BeNil())
+			})
+
+			It("writes the settings to launch.json", func() {
+				contents, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "launch.json"))
+				Expect(err).To(BeNil())
+
+				var config launch.Config
+				Expect(json.Unmarshal(contents, &config)).To(Succeed())
+				Expect(config.SSO).To(Equal(&sso.Settings{Service: "corp-sso", Groups: []string{"staff"}}))
+			})
+
+			It("copies the sidecar into the droplet", func() {
+				contents, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "sso"))
+				Expect(err).To(BeNil())
+				Expect(string(contents)).To(Equal("sidecar"))
+			})
+		})
+
+		Context("error_log_instance_index is set", func() {
+			BeforeEach(func() {
+				staticfile.ErrorLogInstanceIndex = true
+			})
+
+			It("tells the launcher to prefix the error log", func() {
+				err = finalizer.WriteStartupFiles()
+				Expect(err).To(BeNil())
+
+				contents, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "launch.json"))
+				Expect(err).To(BeNil())
+
+				var config launch.Config
+				Expect(json.Unmarshal(contents, &config)).To(Succeed())
+				Expect(config.ErrorLogInstanceIndex).To(BeTrue())
+			})
+		})
+
+		Context("metrics is set", func() {
+			BeforeEach(func() {
+				staticfile.Metrics = &finalize.Metrics{Path: "/__metrics"}
+			})
+
+			It("tells the launcher to start the exporter", func() {
+				err = finalizer.WriteStartupFiles()
+				Expect(err).To(BeNil())
+
+				contents, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "launch.json"))
+				Expect(err).To(BeNil())
+
+				var config launch.Config
+				Expect(json.Unmarshal(contents, &config)).To(Succeed())
+				Expect(config.Metrics).To(BeTrue())
+			})
+		})
+
+		Context("health_check has readiness", func() {
+			BeforeEach(func() {
+				staticfile.HealthCheck = &finalize.HealthCheck{Path: "/__health", Readiness: true}
+			})
+
+			It("tells the launcher to mark the app as ready", func() {
+				err = finalizer.WriteStartupFiles()
+				Expect(err).To(BeNil())
+
+				contents, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "launch.json"))
+				Expect(err).To(BeNil())
+
+				var config launch.Config
+				Expect(json.Unmarshal(contents, &config)).To(Succeed())
+				Expect(config.Readiness).To(BeTrue())
+			})
+		})
+
+		Context("sso is not set", func() {
+			It("does not copy the sidecar", func() {
+				err = finalizer.WriteStartupFiles()
+				Expect(err).To(BeNil())
+				Expect(filepath.Join(buildDir, ".staticfile", "sso")).NotTo(BeAnExistingFile())
+			})
 		})
 
 		It("writes boot.sh in appdir", func() {
@@ -100,7 +276,7 @@ var _ = Describe("Compile", func() {
 
 			contents, err := os.ReadFile(filepath.Join(buildDir, "boot.sh"))
 			Expect(err).To(BeNil())
-			Expect(string(contents)).To(Equal("#!/bin/sh\nset -ex\n$APP_ROOT/start_logging.sh\nnginx -p $APP_ROOT/nginx -c $APP_ROOT/nginx/conf/nginx.conf\n"))
+			Expect(string(contents)).To(Equal("#!/bin/sh\nexec $HOME/.staticfile/launch\n"))
 		})
 
 		It("boot.sh is an executable file", func() {
@@ -304,6 +480,142 @@ var _ = Describe("Compile", func() {
 				})
 			})
 
+			Context("and sets log_format to json", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).LogFormat = "json"
+					})
+				})
+				It("sets log_format", func() {
+					Expect(finalizer.Config.LogFormat).To(Equal("json"))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling JSON access logs\n"))
+				})
+			})
+
+			Context("and sets error_log_level", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).ErrorLogLevel = "warn"
+					})
+				})
+				It("sets error_log_level", func() {
+					Expect(finalizer.Config.ErrorLogLevel).To(Equal("warn"))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Setting the error log level to warn\n"))
+				})
+			})
+
+			Context("and sets error_log_instance_index", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).ErrorLogInstanceIndex = "true"
+					})
+				})
+				It("sets error_log_instance_index", func() {
+					Expect(finalizer.Config.ErrorLogInstanceIndex).To(Equal(true))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Prefixing error log lines with the instance index\n"))
+				})
+			})
+
+			Context("and enables health_check", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).HealthCheck = true
+					})
+				})
+				It("sets health_check at the default path", func() {
+					Expect(finalizer.Config.HealthCheck).To(Equal(&finalize.HealthCheck{Path: "/__health", BuildpackVersion: "1.6.0"}))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling the health check at /__health\n"))
+				})
+			})
+
+			Context("and sets health_check with a path and readiness", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).HealthCheck = map[interface{}]interface{}{"path": "/healthz", "readiness": true}
+					})
+				})
+				It("sets health_check", func() {
+					Expect(finalizer.Config.HealthCheck).To(Equal(&finalize.HealthCheck{Path: "/healthz", Readiness: true, BuildpackVersion: "1.6.0"}))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling the health check at /healthz, answering 503 until the app has started and once it stops\n"))
+				})
+			})
+
+			Context("and disables health_check", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).HealthCheck = "false"
+					})
+				})
+				It("does not set health_check", func() {
+					Expect(finalizer.Config.HealthCheck).To(BeNil())
+				})
+			})
+
+			Context("and enables metrics", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).Metrics = map[interface{}]interface{}{"allow": []interface{}{"10.255.0.0/16"}}
+					})
+				})
+				It("sets metrics at the default path", func() {
+					Expect(finalizer.Config.Metrics).To(Equal(&finalize.Metrics{Path: "/__metrics", Allow: []string{"10.255.0.0/16"}}))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling Prometheus metrics at /__metrics, restricted to 10.255.0.0/16\n" +
+						"       **WARNING** Taking the client address from X-Forwarded-For, as set by any proxy at a private address. A client at a private address can pick its own, set trusted_proxies to the addresses of the routers and load balancers in front of the app.\n"))
+				})
+			})
+
+			Context("and sets metrics with a path and a port", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).Metrics = map[interface{}]interface{}{"path": "/metrics", "port": 9113, "allow": []interface{}{"10.255.0.0/16", "10.0.0.1"}}
+					})
+				})
+				It("sets metrics", func() {
+					Expect(finalizer.Config.Metrics).To(Equal(&finalize.Metrics{Path: "/metrics", Port: 9113, Allow: []string{"10.255.0.0/16", "10.0.0.1"}}))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling Prometheus metrics on port 9113 at /metrics, restricted to 10.255.0.0/16, 10.0.0.1\n"))
+				})
+			})
+
+			Context("and sets access_log", func() {
+				var accessLog *finalize.AccessLogTemp
+				BeforeEach(func() {
+					accessLog = &finalize.AccessLogTemp{ExcludePaths: []string{"/healthz"}, ExcludeStatus: []string{"3xx"}, Sample: "10%"}
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).AccessLog = accessLog
+					})
+				})
+				It("sets access_log", func() {
+					Expect(finalizer.Config.AccessLog).To(Equal(&finalize.AccessLog{ExcludePaths: []string{"/healthz"}, ExcludeStatus: []string{"3xx"}, Sample: "10%"}))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Filtering the access log, excluding paths /healthz; status 3xx; logging 10% of successful requests\n"))
+				})
+
+				Context("without any filters", func() {
+					BeforeEach(func() {
+						accessLog = &finalize.AccessLogTemp{}
+					})
+					It("logs every request", func() {
+						Expect(finalizer.Config.AccessLog).To(BeNil())
+						Expect(buffer.String()).To(Equal(""))
+					})
+				})
+			})
+
 			Context("and sets status_codes", func() {
 				var statusCodes map[string]string
 				BeforeEach(func() {
@@ -342,89 +654,514 @@ var _ = Describe("Compile", func() {
 
 				})
 			})
-		})
-
-		Context("Staticfile.auth is present", func() {
-			BeforeEach(func() {
-				err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("some credentials"), 0644)
-				Expect(err).To(BeNil())
-			})
-			JustBeforeEach(func() {
-				err = finalizer.LoadStaticfile()
-				Expect(err).To(BeNil())
-			})
 
-			Context("the staticfile exists", func() {
+			Context("and sets headers", func() {
 				BeforeEach(func() {
-					mockYaml.EXPECT().Load(gomock.Any(), gomock.Any())
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).Headers = map[string]interface{}{
+							"X-Frame-Options": "DENY",
+							"X-Max-Age":       3600,
+							"/assets/*.js": map[interface{}]interface{}{
+								"Cache-Control": "public",
+							},
+						}
+					})
 				})
-
-				It("sets BasicAuth", func() {
-					Expect(finalizer.Config.BasicAuth).To(Equal(true))
+				It("sets headers for every location", func() {
+					Expect(finalizer.Config.Headers).To(Equal(map[string]string{"X-Frame-Options": "DENY", "X-Max-Age": "3600"}))
+				})
+				It("sets headers for path globs", func() {
+					Expect(finalizer.Config.PathHeaders).To(Equal(map[string]map[string]string{"/assets/*.js": {"Cache-Control": "public"}}))
 				})
 				It("Logs", func() {
-					Expect(buffer.String()).To(ContainSubstring("-----> Enabling basic authentication using Staticfile.auth\n"))
+					Expect(buffer.String()).To(Equal("-----> Enabling custom headers\n"))
 				})
 			})
 
-			Context("the staticfile does not exist", func() {
+			Context("and sets content_security_policy", func() {
+				var reportOnly string
 				BeforeEach(func() {
-					mockYaml.EXPECT().Load(gomock.Any(), gomock.Any()).Return(syscall.ENOENT)
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).ContentSecurityPolicy = &finalize.ContentSecurityPolicyTemp{
+							Directives: map[string]interface{}{
+								"default-src":               "self",
+								"img-src":                   []interface{}{"'self'", "data:", "https://*.example.com"},
+								"upgrade-insecure-requests": nil,
+							},
+							ReportOnly: reportOnly,
+							ReportURI:  "/csp-report",
+						}
+					})
 				})
-
-				It("sets BasicAuth", func() {
-					Expect(finalizer.Config.BasicAuth).To(Equal(true))
+				Context("in enforcing mode", func() {
+					BeforeEach(func() {
+						reportOnly = ""
+					})
+					It("sets content_security_policy with quoted keywords", func() {
+						Expect(*finalizer.Config.ContentSecurityPolicy).To(Equal(finalize.ContentSecurityPolicy{
+							Directives: map[string][]string{
+								"default-src":               {"'self'"},
+								"img-src":                   {"'self'", "data:", "https://*.example.com"},
+								"upgrade-insecure-requests": nil,
+							},
+							ReportURI: "/csp-report",
+						}))
+					})
+					It("Logs", func() {
+						Expect(buffer.String()).To(Equal("-----> Enabling Content-Security-Policy\n"))
+					})
 				})
-				It("Logs", func() {
-					Expect(buffer.String()).To(ContainSubstring("-----> Enabling basic authentication using Staticfile.auth\n"))
+				Context("in report only mode", func() {
+					BeforeEach(func() {
+						reportOnly = "true"
+					})
+					It("sets report only", func() {
+						Expect(finalizer.Config.ContentSecurityPolicy.ReportOnly).To(BeTrue())
+					})
+					It("Logs", func() {
+						Expect(buffer.String()).To(Equal("-----> Enabling Content-Security-Policy in report only mode\n"))
+					})
 				})
 			})
-		})
 
-		Context("the staticfile exists and is not valid", func() {
-			BeforeEach(func() {
-				mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Return(errors.New("a yaml parsing error"))
+			Context("and sets cache_control", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).CacheControl = []map[string]string{
+							{"/assets/**": "public, max-age=86400"},
+							{"/*.json": "no-store"},
+						}
+					})
+				})
+				It("sets cache_control in order", func() {
+					Expect(finalizer.Config.CacheControl).To(Equal([]finalize.CacheControlRule{
+						{Path: "/assets/**", Policy: "public, max-age=86400"},
+						{Path: "/*.json", Policy: "no-store"},
+					}))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling cache-control rules\n"))
+				})
 			})
 
-			It("returns an error", func() {
-				err = finalizer.LoadStaticfile()
-				Expect(err).NotTo(BeNil())
+			Context("and sets fingerprinted_assets", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).FingerprintedAssets = "true"
+					})
+				})
+				It("sets fingerprinted_assets", func() {
+					Expect(finalizer.Config.FingerprintedAssets).To(Equal(true))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling long term caching of fingerprinted assets\n"))
+				})
 			})
-		})
-	})
-
-	Describe("GetAppRootDir", func() {
-		var (
-			returnDir string
-		)
-
-		JustBeforeEach(func() {
-			returnDir, err = finalizer.GetAppRootDir()
-		})
 
-		Context("the staticfile has a root directory specified", func() {
-			Context("the directory does not exist", func() {
+			Context("and sets redirects", func() {
 				BeforeEach(func() {
-					staticfile.RootDir = "not_exist"
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).Redirects = []interface{}{
+							"/old /new",
+							map[interface{}]interface{}{"from": "/app/*", "to": "/index.html", "status": 200},
+						}
+					})
 				})
-
-				It("logs the staticfile's root directory", func() {
-					Expect(buffer.String()).To(ContainSubstring("-----> Root folder"))
-					Expect(buffer.String()).To(ContainSubstring("not_exist"))
-
+				It("sets redirects in order", func() {
+					Expect(finalizer.Config.Redirects).To(Equal([]finalize.Redirect{
+						{From: "/old", To: "/new", Status: 301},
+						{From: "/app/*", To: "/index.html", Status: 200},
+					}))
 				})
-
-				It("returns an error", func() {
-					Expect(returnDir).To(Equal(""))
-					Expect(err).NotTo(BeNil())
-					Expect(err.Error()).To(ContainSubstring("the application Staticfile specifies a root directory"))
-					Expect(err.Error()).To(ContainSubstring("that does not exist"))
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling redirects\n"))
 				})
 			})
 
-			Context("the directory exists but is actually a file", func() {
+			Context("and sets proxies", func() {
 				BeforeEach(func() {
-					os.WriteFile(filepath.Join(buildDir, "actually_a_file"), []byte("xxx"), 0644)
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).Proxies = map[string]finalize.ProxyTemp{
+							"/ws":  {URL: "${CHAT_URL}", WebSocket: "true"},
+							"/api": {URL: "https://api.example.com/v1", Timeout: "60s", SSLVerify: "enabled", Headers: map[string]string{"X-Api-Key": "secret"}},
+						}
+					})
+				})
+				It("sets proxies sorted by prefix", func() {
+					Expect(finalizer.Config.Proxies).To(Equal([]finalize.Proxy{
+						{Prefix: "/api", URL: "https://api.example.com/v1/", Timeout: "60s", SSLVerify: true, Headers: []finalize.Header{{Name: "X-Api-Key", Value: "secret"}}},
+						{Prefix: "/ws", URLEnv: "CHAT_URL", WebSocket: true},
+					}))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling proxies\n"))
+				})
+			})
+
+			Context("and sets runtime_env", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).RuntimeEnv = &finalize.RuntimeEnvTemp{Variables: []string{"API_URL", "SENTRY_DSN"}}
+					})
+				})
+				It("sets runtime_env with the default path", func() {
+					Expect(finalizer.Config.RuntimeEnv).To(Equal(&finalize.RuntimeEnv{Variables: []string{"API_URL", "SENTRY_DSN"}, Path: "/env.js"}))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling runtime environment variables in /env.js\n"))
+				})
+			})
+
+			Context("and sets basic_auth without a source", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).BasicAuth = &finalize.BasicAuthSourceTemp{}
+					})
+				})
+				It("reads users from STATICFILE_AUTH_USERS", func() {
+					Expect(finalizer.Config.BasicAuth).To(Equal(true))
+					Expect(finalizer.Config.BasicAuthSource).To(Equal(&finalize.BasicAuthSource{Env: "STATICFILE_AUTH_USERS"}))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling basic authentication using the STATICFILE_AUTH_USERS environment variable\n"))
+				})
+			})
+
+			Context("and sets basic_auth with a service", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).BasicAuth = &finalize.BasicAuthSourceTemp{Service: "site-users"}
+					})
+				})
+				It("reads users from the service only", func() {
+					Expect(finalizer.Config.BasicAuthSource).To(Equal(&finalize.BasicAuthSource{Service: "site-users"}))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling basic authentication using the site-users service\n"))
+				})
+			})
+
+			Context("and sets precompress", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+						(*hash).Precompress = "enabled"
+					})
+				})
+				It("sets precompress", func() {
+					Expect(finalizer.Config.Precompress).To(Equal(true))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(Equal("-----> Enabling precompression of assets\n"))
+				})
+			})
+		})
+
+		Context("Staticfile.auth is present", func() {
+			BeforeEach(func() {
+				err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("bob:$apr1$DuUQEQp8$ZccZCHQElNSjrg.erwSFC0\n"), 0644)
+				Expect(err).To(BeNil())
+			})
+			JustBeforeEach(func() {
+				err = finalizer.LoadStaticfile()
+				Expect(err).To(BeNil())
+			})
+
+			Context("the staticfile exists", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(gomock.Any(), gomock.Any())
+				})
+
+				It("sets BasicAuth", func() {
+					Expect(finalizer.Config.BasicAuth).To(Equal(true))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(ContainSubstring("-----> Enabling basic authentication using Staticfile.auth\n"))
+				})
+			})
+
+			Context("the staticfile does not exist", func() {
+				BeforeEach(func() {
+					mockYaml.EXPECT().Load(gomock.Any(), gomock.Any()).Return(syscall.ENOENT)
+				})
+
+				It("sets BasicAuth", func() {
+					Expect(finalizer.Config.BasicAuth).To(Equal(true))
+				})
+				It("Logs", func() {
+					Expect(buffer.String()).To(ContainSubstring("-----> Enabling basic authentication using Staticfile.auth\n"))
+				})
+			})
+		})
+
+		Context("Staticfile.auth is present and basic_auth is set", func() {
+			BeforeEach(func() {
+				err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("not checked"), 0644)
+				Expect(err).To(BeNil())
+				mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+					(*hash).BasicAuth = &finalize.BasicAuthSourceTemp{Env: "SITE_USERS", Service: "site-users"}
+				})
+			})
+			JustBeforeEach(func() {
+				err = finalizer.LoadStaticfile()
+				Expect(err).To(BeNil())
+			})
+
+			It("uses basic_auth and warns that Staticfile.auth is ignored", func() {
+				Expect(finalizer.Config.BasicAuthSource).To(Equal(&finalize.BasicAuthSource{Env: "SITE_USERS", Service: "site-users"}))
+				Expect(buffer.String()).To(ContainSubstring("-----> Enabling basic authentication using the site-users service, or the SITE_USERS environment variable\n"))
+				Expect(buffer.String()).To(ContainSubstring("Staticfile.auth is ignored, as basic_auth is set in the Staticfile."))
+			})
+		})
+
+		Context("auth rules are set", func() {
+			var (
+				auth           map[string]finalize.AuthRuleTemp
+				trustedProxies []string
+			)
+
+			BeforeEach(func() {
+				trustedProxies = nil
+				auth = map[string]finalize.AuthRuleTemp{
+					"/**":       {BasicAuth: "false"},
+					"/admin/**": {BasicAuth: "true", Allow: []string{"10.0.0.0/8"}},
+					"/healthz":  {BasicAuth: "disabled"},
+				}
+				mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+					(*hash).Auth = auth
+					(*hash).TrustedProxies = trustedProxies
+				})
+			})
+
+			Context("and there are credentials", func() {
+				BeforeEach(func() {
+					err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("bob:$apr1$DuUQEQp8$ZccZCHQElNSjrg.erwSFC0\n"), 0644)
+					Expect(err).To(BeNil())
+				})
+
+				It("orders the rules from the most specific glob", func() {
+					Expect(finalizer.LoadStaticfile()).To(Succeed())
+
+					public, private := false, true
+					Expect(finalizer.Config.AuthRules).To(Equal([]finalize.AuthRule{
+						{Path: "/healthz", BasicAuth: &public, Index: 1},
+						{Path: "/admin/**", BasicAuth: &private, Allow: []string{"10.0.0.0/8"}, Index: 2},
+						{Path: "/**", BasicAuth: &public, Index: 3},
+					}))
+					Expect(buffer.String()).To(ContainSubstring("-----> Enabling access rules\n"))
+				})
+
+				It("warns that the client address is trusted from any private proxy", func() {
+					Expect(finalizer.LoadStaticfile()).To(Succeed())
+					Expect(buffer.String()).To(ContainSubstring("**WARNING** Taking the client address from X-Forwarded-For, as set by any proxy at a private address."))
+				})
+
+				Context("and trusted_proxies is set", func() {
+					BeforeEach(func() {
+						trustedProxies = []string{"10.0.16.0/24"}
+					})
+
+					It("trusts only those proxies", func() {
+						Expect(finalizer.LoadStaticfile()).To(Succeed())
+						Expect(finalizer.Config.TrustedProxies).To(Equal([]string{"10.0.16.0/24"}))
+						Expect(buffer.String()).To(ContainSubstring("-----> Taking the client address from X-Forwarded-For, as set by 10.0.16.0/24\n"))
+						Expect(buffer.String()).NotTo(ContainSubstring("WARNING"))
+					})
+				})
+			})
+
+			Context("and there are no credentials", func() {
+				It("returns an error", func() {
+					Expect(finalizer.LoadStaticfile()).To(MatchError("auth requires credentials for /admin/**, but there is no Staticfile.auth and basic_auth is not set"))
+				})
+
+				Context("but no rule requires them", func() {
+					BeforeEach(func() {
+						auth = map[string]finalize.AuthRuleTemp{"/internal/**": {Deny: []string{"192.168.0.0/16"}}}
+					})
+
+					It("does not return an error", func() {
+						Expect(finalizer.LoadStaticfile()).To(Succeed())
+						Expect(finalizer.Config.BasicAuth).To(Equal(false))
+					})
+				})
+			})
+		})
+
+		Context("the only Staticfile is in the root from BP_STATICFILE_ROOT", func() {
+			BeforeEach(func() {
+				staticfile.RootDir = "dist"
+				Expect(os.MkdirAll(filepath.Join(buildDir, "dist"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "dist", "Staticfile"), []byte("pushstate: enabled\n"), 0644)).To(Succeed())
+				mockYaml.EXPECT().Load(filepath.Join(buildDir, "dist", "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+					(*hash).PushState = "enabled"
+				})
+			})
+
+			It("loads it", func() {
+				Expect(finalizer.LoadStaticfile()).To(Succeed())
+				Expect(finalizer.Config.PushState).To(BeTrue())
+				Expect(finalizer.Config.RootDir).To(Equal("dist"))
+			})
+		})
+
+		Context("sbom_route is set", func() {
+			var sbomRoute interface{}
+
+			BeforeEach(func() {
+				sbomRoute = true
+				mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+					(*hash).SBOMRoute = sbomRoute
+					(*hash).HealthCheck = true
+				})
+			})
+
+			It("returns an error without authentication", func() {
+				Expect(finalizer.LoadStaticfile()).To(MatchError("sbom_route needs basic authentication or sso, add Staticfile.auth, basic_auth or sso"))
+			})
+
+			Context("and there is a Staticfile.auth", func() {
+				BeforeEach(func() {
+					err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("bob:$apr1$DuUQEQp8$ZccZCHQElNSjrg.erwSFC0\n"), 0644)
+					Expect(err).To(BeNil())
+				})
+
+				It("serves the SBOM at the default path", func() {
+					Expect(finalizer.LoadStaticfile()).To(Succeed())
+					Expect(finalizer.Config.SBOMRoute).To(Equal(&finalize.SBOMRoute{Path: "/__sbom"}))
+					Expect(buffer.String()).To(ContainSubstring("-----> Serving the SBOM at /__sbom\n"))
+				})
+
+				Context("with the path of the health check", func() {
+					BeforeEach(func() {
+						sbomRoute = map[interface{}]interface{}{"path": "/__health"}
+					})
+
+					It("returns an error", func() {
+						Expect(finalizer.LoadStaticfile()).To(MatchError("sbom_route and health_check cannot both use /__health"))
+					})
+				})
+			})
+		})
+
+		Context("metrics and health_check share a path", func() {
+			BeforeEach(func() {
+				mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+					(*hash).HealthCheck = map[interface{}]interface{}{"path": "/status"}
+					(*hash).Metrics = map[interface{}]interface{}{"path": "/status"}
+				})
+			})
+
+			It("returns an error", func() {
+				Expect(finalizer.LoadStaticfile()).To(MatchError("metrics and health_check cannot both use /status"))
+			})
+		})
+
+		Context("metrics has a port that is not a number", func() {
+			BeforeEach(func() {
+				mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+					(*hash).Metrics = map[interface{}]interface{}{"port": "metrics"}
+				})
+			})
+
+			It("returns an error rather than serving metrics on the app route", func() {
+				Expect(finalizer.LoadStaticfile()).To(MatchError(`invalid value "metrics" for metrics.port: expected a port from 1024 to 65535`))
+				Expect(finalizer.Config.Metrics).To(BeNil())
+			})
+		})
+
+		Context("sbom_route is neither a boolean nor a map", func() {
+			BeforeEach(func() {
+				mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+					(*hash).SBOMRoute = "/sbom"
+				})
+			})
+
+			It("returns an error rather than ignoring it", func() {
+				Expect(finalizer.LoadStaticfile()).To(MatchError(`invalid value "/sbom" for sbom_route: expected one of true, false, enabled, disabled`))
+			})
+		})
+
+		Context("sso is set", func() {
+			BeforeEach(func() {
+				mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, hash *finalize.StaticfileTemp) {
+					(*hash).SSO = &finalize.SSOTemp{Groups: []string{"staff", "admins"}, GroupsClaim: "roles"}
+				})
+			})
+
+			It("enables single sign-on", func() {
+				Expect(finalizer.LoadStaticfile()).To(Succeed())
+				Expect(finalizer.Config.SSO).To(Equal(&finalize.SSO{Groups: []string{"staff", "admins"}, GroupsClaim: "roles"}))
+				Expect(buffer.String()).To(ContainSubstring("-----> Enabling single sign-on using the SSO_ISSUER, SSO_CLIENT_ID and SSO_CLIENT_SECRET environment variables, for members of staff, admins\n"))
+			})
+
+			Context("and there is a Staticfile.auth", func() {
+				BeforeEach(func() {
+					err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("bob:$apr1$DuUQEQp8$ZccZCHQElNSjrg.erwSFC0\n"), 0644)
+					Expect(err).To(BeNil())
+				})
+
+				It("returns an error", func() {
+					Expect(finalizer.LoadStaticfile()).To(MatchError("sso cannot be combined with basic authentication, remove Staticfile.auth or basic_auth"))
+				})
+			})
+		})
+
+		Context("the staticfile exists and is not valid", func() {
+			BeforeEach(func() {
+				mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Return(errors.New("a yaml parsing error"))
+			})
+
+			It("returns an error", func() {
+				err = finalizer.LoadStaticfile()
+				Expect(err).NotTo(BeNil())
+			})
+		})
+
+		Context("the staticfile has an unknown key", func() {
+			BeforeEach(func() {
+				err = os.WriteFile(filepath.Join(buildDir, "Staticfile"), []byte("force_http: true\n"), 0644)
+				Expect(err).To(BeNil())
+			})
+
+			It("returns an error without loading the staticfile", func() {
+				err = finalizer.LoadStaticfile()
+				Expect(err).To(MatchError(`line 1: unknown key "force_http" (did you mean "force_https"?)`))
+			})
+		})
+	})
+
+	Describe("GetAppRootDir", func() {
+		var (
+			returnDir string
+		)
+
+		JustBeforeEach(func() {
+			returnDir, err = finalizer.GetAppRootDir()
+		})
+
+		Context("the staticfile has a root directory specified", func() {
+			Context("the directory does not exist", func() {
+				BeforeEach(func() {
+					staticfile.RootDir = "not_exist"
+				})
+
+				It("logs the staticfile's root directory", func() {
+					Expect(buffer.String()).To(ContainSubstring("-----> Root folder"))
+					Expect(buffer.String()).To(ContainSubstring("not_exist"))
+
+				})
+
+				It("returns an error", func() {
+					Expect(returnDir).To(Equal(""))
+					Expect(err).NotTo(BeNil())
+					Expect(err.Error()).To(ContainSubstring("the application Staticfile specifies a root directory"))
+					Expect(err.Error()).To(ContainSubstring("that does not exist"))
+				})
+			})
+
+			Context("the directory exists but is actually a file", func() {
+				BeforeEach(func() {
+					os.WriteFile(filepath.Join(buildDir, "actually_a_file"), []byte("xxx"), 0644)
 					staticfile.RootDir = "actually_a_file"
 				})
 
@@ -813,22 +1550,540 @@ var _ = Describe("Compile", func() {
 				})
 			})
 
-			Context("there is a Staticfile.auth", func() {
+			Context("headers are set in staticfile", func() {
 				BeforeEach(func() {
-					staticfile.BasicAuth = true
-					err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("authentication info"), 0644)
-					Expect(err).To(BeNil())
+					staticfile.Headers = map[string]string{"X-Frame-Options": "DENY", "X-Test": `a "b" $c`}
 				})
-
-				It("it enables basic authentication", func() {
+				It("adds the headers to location /", func() {
 					data := readNginxConfAndStrip()
-					Expect(string(data)).To(ContainSubstring(basicAuthConf))
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						location / {
+						index index.html index.htm Default.htm;
+						add_header X-Frame-Options "DENY" always;
+						add_header X-Test "a \"b\" ${dollar}c" always;
+						}
+					`)))
 				})
+			})
 
-				It("copies the Staticfile.auth to .htpasswd", func() {
-					data, err = os.ReadFile(filepath.Join(buildDir, "nginx", "conf", ".htpasswd"))
-					Expect(err).To(BeNil())
-					Expect(string(data)).To(Equal("authentication info"))
+			Context("headers for path globs are set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.Headers = map[string]string{"X-Frame-Options": "DENY", "X-Test": "global"}
+					staticfile.PathHeaders = map[string]map[string]string{
+						"/assets/**":   {"Cache-Control": "public"},
+						"/assets/*.js": {"x-test": "scripts"},
+					}
+					staticfile.SSI = true
+				})
+				It("adds a location for each glob, most specific first", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						location ~ "^/assets/[^/]*\.js$" {
+						index index.html index.htm Default.htm;
+						ssi on;
+						add_header X-Frame-Options "DENY" always;
+						add_header x-test "scripts" always;
+						}
+						location ~ "^/assets/.*$" {
+						index index.html index.htm Default.htm;
+						ssi on;
+						add_header Cache-Control "public" always;
+						add_header X-Frame-Options "DENY" always;
+						add_header X-Test "global" always;
+						}
+					`)))
+				})
+			})
+
+			Context("content_security_policy is set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.ContentSecurityPolicy = &finalize.ContentSecurityPolicy{
+						Directives: map[string][]string{
+							"script-src":  {"'self'", "https://cdn.example.com"},
+							"default-src": {"'none'"},
+						},
+						ReportURI: "/csp-report",
+					}
+				})
+				It("adds the Content-Security-Policy header", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(`add_header Content-Security-Policy "default-src 'none'; script-src 'self' https://cdn.example.com; report-uri /csp-report";`))
+				})
+
+				Context("in report only mode", func() {
+					BeforeEach(func() {
+						staticfile.ContentSecurityPolicy.ReportOnly = true
+					})
+					It("adds the Content-Security-Policy-Report-Only header", func() {
+						data := readNginxConfAndStrip()
+						Expect(string(data)).To(ContainSubstring(`add_header Content-Security-Policy-Report-Only "default-src 'none'; script-src 'self' https://cdn.example.com; report-uri /csp-report";`))
+					})
+				})
+			})
+
+			Context("content_security_policy is NOT set in staticfile", func() {
+				It("does not add the Content-Security-Policy header", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).NotTo(ContainSubstring("Content-Security-Policy"))
+				})
+			})
+
+			Context("cache_control is set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.CacheControl = []finalize.CacheControlRule{
+						{Path: "/assets/**", Policy: "public, max-age=86400"},
+						{Path: "/*.json", Policy: "no-store"},
+					}
+				})
+				It("maps paths to policies in order", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						map $uri $cache_control {
+						default "";
+						"~^/assets/.*$" "public, max-age=86400";
+						"~^/[^/]*\.json$" "no-store";
+						}
+					`)))
+				})
+				It("adds the Cache-Control header", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("add_header Cache-Control $cache_control;"))
+				})
+			})
+
+			Context("fingerprinted_assets is set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.FingerprintedAssets = true
+					Expect(os.MkdirAll(filepath.Join(buildDir, "public", "assets"), 0755)).To(Succeed())
+					for _, file := range []string{"index.html", "assets/app.3f9a1c.js", "assets/main-4f3a2b1c.css", "report-20240101.pdf"} {
+						Expect(os.WriteFile(filepath.Join(buildDir, "public", file), []byte("contents"), 0644)).To(Succeed())
+					}
+				})
+				It("marks fingerprinted assets as immutable and index.html as no-cache", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						map $uri $cache_control {
+						default "";
+						"/assets/app.3f9a1c.js" "public, max-age=31536000, immutable";
+						"/assets/main-4f3a2b1c.css" "public, max-age=31536000, immutable";
+						"~(^|/)index\.html?$" "no-cache";
+						}
+					`)))
+				})
+				It("logs the number of fingerprinted assets", func() {
+					Expect(buffer.String()).To(ContainSubstring("Marking 2 fingerprinted assets as immutable"))
+				})
+			})
+
+			Context("runtime_env is set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.RuntimeEnv = &finalize.RuntimeEnv{Variables: []string{"API_URL"}, Path: "/env.js", Substitute: []string{"/**"}}
+					staticfile.PathHeaders = map[string]map[string]string{"/assets/*": {"X-Asset": "1"}}
+					Expect(os.MkdirAll(filepath.Join(buildDir, "public", "assets"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "public", "index.html"), []byte(`<html>`), 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "public", "assets", "app.js"), []byte(`fetch("${API_URL}")`), 0644)).To(Succeed())
+				})
+				It("does not let browsers cache the rendered file", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						map $uri $cache_control {
+						default "";
+						"/env.js" "no-cache";
+						}
+					`)))
+				})
+				It("serves the rendered files from nginx/runtime with the settings of their location", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("location = /env.js {\nroot ((APP_ROOT))/nginx/runtime;\nindex index.html index.htm Default.htm;\n"))
+					Expect(string(data)).To(ContainSubstring("location = /assets/app.js {\nroot ((APP_ROOT))/nginx/runtime;\nindex index.html index.htm Default.htm;\nadd_header Cache-Control $cache_control;\nadd_header X-Asset \"1\" always;\n}\n"))
+					Expect(string(data)).NotTo(ContainSubstring("location = /index.html"))
+				})
+			})
+
+			Context("cache_control and fingerprinted_assets are NOT set in staticfile", func() {
+				It("does not add the Cache-Control header", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).NotTo(ContainSubstring("$cache_control"))
+				})
+			})
+
+			Context("brotli files were written", func() {
+				BeforeEach(func() {
+					staticfile.BrotliStatic = true
+				})
+				It("enables brotli_static", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("gzip_vary on;\nbrotli_static on;\n"))
+				})
+			})
+
+			Context("brotli files were NOT written", func() {
+				It("does not enable brotli_static", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).NotTo(ContainSubstring("brotli_static"))
+				})
+			})
+
+			Context("redirects are set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.Redirects = []finalize.Redirect{
+						{From: "/old", To: "/new", Status: 301},
+						{From: "/news/:year/*", To: "/blog/:year/:splat", Status: 302},
+						{From: "/*", To: "/index.html", Status: 200},
+					}
+				})
+				It("maps sources to statuses and destinations in order", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						map $uri $redirect {
+						default "";
+						"~^/old$" "301 /new$is_args$args";
+						"~^/news/(?<redirect_year>[^/]+)/(?<redirect_splat>.*)$" "302 /blog/${redirect_year}/${redirect_splat}$is_args$args";
+						"~^/(?<redirect_splat>.*)$" "200 /index.html";
+						}
+						map $redirect $redirect_status {
+						default "";
+						"~^(?<redirect_code>[0-9]+!?) " $redirect_code;
+						}
+						map $redirect $redirect_to {
+						default "";
+						"~^[0-9]+!? (?<redirect_target>.*)$" $redirect_target;
+						}
+					`)))
+				})
+				It("redirects before looking for a file", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						if ($redirect_status = 301) {
+						return 301 $redirect_to;
+						}
+						if ($redirect_status = 302) {
+						return 302 $redirect_to;
+						}
+					`)))
+					Expect(string(data)).NotTo(ContainSubstring("if ($redirect_status = 200)"))
+				})
+				It("rewrites only paths that are not files", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("location / {\nindex index.html index.htm Default.htm;\ntry_files $uri $uri/ @redirect;\n"))
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						location @redirect {
+						if ($redirect_status != 200) {
+						return 404;
+						}
+						rewrite ^ $redirect_to last;
+						}
+					`)))
+				})
+			})
+
+			Context("a pattern comes before a more specific source with another status", func() {
+				BeforeEach(func() {
+					staticfile.Redirects = []finalize.Redirect{
+						{From: "/a/*", To: "/b", Status: 301},
+						{From: "/a/x", To: "/c", Status: 302},
+					}
+				})
+				It("keeps the order, as _redirects applies the first rule that matches", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("\"~^/a/(?<redirect_splat>.*)$\" \"301 /b$is_args$args\";\n\"~^/a/x$\" \"302 /c$is_args$args\";\n"))
+				})
+			})
+
+			Context("a rewrite is forced", func() {
+				BeforeEach(func() {
+					staticfile.Redirects = []finalize.Redirect{{From: "/app.js", To: "/app.v2.js", Status: 200, Force: true}}
+				})
+				It("rewrites with the redirects, even if the file exists", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("\"~^/app\\.js$\" \"200! /app.v2.js\";\n"))
+					Expect(string(data)).To(ContainSubstring("if ($redirect_status = 200!) {\nrewrite ^ $redirect_to last;\n}\n"))
+					Expect(string(data)).NotTo(ContainSubstring("@redirect"))
+				})
+			})
+
+			Context("there is a _redirects file", func() {
+				BeforeEach(func() {
+					staticfile.Redirects = []finalize.Redirect{{From: "/old", To: "/new", Status: 301}}
+					Expect(os.MkdirAll(filepath.Join(buildDir, "public"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "public", "_redirects"), []byte("# moved\n/old /elsewhere\n/about /about-us 308\n"), 0644)).To(Succeed())
+				})
+				It("adds its redirects after those in the Staticfile", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("\"~^/old$\" \"301 /new$is_args$args\";\n\"~^/about$\" \"308 /about-us$is_args$args\";\n"))
+					Expect(string(data)).NotTo(ContainSubstring("/elsewhere"))
+				})
+				It("warns about sources already redirected by the Staticfile", func() {
+					Expect(buffer.String()).To(ContainSubstring("Ignoring the redirect for /old on line 2 of _redirects"))
+				})
+				It("counts only the redirects it added", func() {
+					Expect(buffer.String()).To(ContainSubstring("Loaded 1 redirects"))
+				})
+				It("removes the _redirects file from public", func() {
+					Expect(filepath.Join(buildDir, "public", "_redirects")).NotTo(BeAnExistingFile())
+				})
+			})
+
+			Context("a _redirects file rewrites every path to index.html", func() {
+				BeforeEach(func() {
+					staticfile.Proxies = []finalize.Proxy{{Prefix: "/api", URL: "https://api.example.com/"}}
+					Expect(os.MkdirAll(filepath.Join(buildDir, "public"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "public", "app.js"), []byte("app"), 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "public", "_redirects"), []byte("/* /index.html 200\n"), 0644)).To(Succeed())
+				})
+				It("serves files that exist, and rewrites the rest", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("\"~^/(?<redirect_splat>.*)$\" \"200 /index.html\";\n"))
+					Expect(string(data)).To(ContainSubstring("location / {\nindex index.html index.htm Default.htm;\ntry_files $uri $uri/ @redirect;\n"))
+					Expect(string(data)).NotTo(ContainSubstring("if ($redirect_status = "))
+				})
+				It("leaves the proxies alone", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(MatchRegexp(`location \^~ /api/ \{\n[^}]*proxy_pass https://api.example.com/;`))
+					Expect(string(data)).NotTo(MatchRegexp(`location \^~ /api/ \{\n[^}]*@redirect`))
+				})
+			})
+
+			Context("proxies are set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.Proxies = []finalize.Proxy{
+						{Prefix: "/api", URL: "https://api.example.com/v1/", ConnectTimeout: "5s", Timeout: "60s", SSLVerify: true, Headers: []finalize.Header{{Name: "X-Api-Key", Value: "abc$123"}}},
+						{Prefix: "/ws/", URLEnv: "CHAT_URL", WebSocket: true},
+					}
+				})
+				It("proxies each prefix to its upstream", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						location ^~ /api/ {
+						proxy_pass https://api.example.com/v1/;
+						proxy_http_version 1.1;
+						proxy_ssl_server_name on;
+
+						proxy_ssl_verify on;
+						proxy_ssl_trusted_certificate /etc/ssl/certs/ca-certificates.crt;
+
+
+						proxy_connect_timeout 5s;
+
+
+						proxy_read_timeout 60s;
+						proxy_send_timeout 60s;
+
+						proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+						proxy_set_header X-Forwarded-Host $best_host;
+						proxy_set_header X-Forwarded-Proto $http_x_forwarded_proto;
+
+
+						proxy_set_header X-Api-Key "abc${dollar}123";
+
+						}
+					`)))
+				})
+				It("upgrades websocket connections", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("map $http_upgrade $connection_upgrade {"))
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						location ^~ /ws/ {
+						proxy_pass ((PROXY_URL:CHAT_URL));
+					`)))
+					Expect(string(data)).To(ContainSubstring("proxy_set_header Upgrade $http_upgrade;\nproxy_set_header Connection $connection_upgrade;"))
+				})
+			})
+
+			Context("proxies and basic auth are set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.Proxies = []finalize.Proxy{{Prefix: "/api", URL: "https://api.example.com/"}}
+					staticfile.BasicAuth = true
+					staticfile.HSTS = true
+					staticfile.ContentSecurityPolicy = &finalize.ContentSecurityPolicy{Directives: map[string][]string{"default-src": {"'self'"}}}
+					err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("authentication info"), 0644)
+					Expect(err).To(BeNil())
+				})
+				It("protects the proxy location and sends the security headers", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						location ^~ /api/ {
+						auth_basic "Restricted";
+						auth_basic_user_file ((APP_ROOT))/nginx/conf/.htpasswd;
+						add_header Strict-Transport-Security "max-age=31536000";
+						add_header Content-Security-Policy "default-src 'self'";
+						proxy_pass https://api.example.com/;
+					`)))
+				})
+			})
+
+			Context("proxies are NOT set in staticfile", func() {
+				It("does not add proxy locations", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).NotTo(ContainSubstring("proxy_pass"))
+					Expect(string(data)).NotTo(ContainSubstring("$connection_upgrade"))
+				})
+			})
+
+			Context("redirects are NOT set in staticfile", func() {
+				It("does not add redirects", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).NotTo(ContainSubstring("$redirect_"))
+				})
+			})
+
+			Context("auth rules are set in staticfile", func() {
+				BeforeEach(func() {
+					public, private := false, true
+					staticfile.BasicAuth = true
+					err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("authentication info"), 0644)
+					Expect(err).To(BeNil())
+					staticfile.AuthRules = []finalize.AuthRule{
+						{Path: "/healthz", BasicAuth: &public, Index: 1},
+						{Path: "/admin/**", BasicAuth: &private, Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.1.2.3"}, Index: 2},
+						{Path: "/**", BasicAuth: &public, Index: 3},
+					}
+				})
+
+				It("maps the requested path to the most specific rule", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						map $auth_path $auth_rule {
+						volatile;
+						default 0;
+						"~^/healthz$" 1;
+						"~^/admin/.*$" 2;
+						"~^/.*$" 3;
+						}
+					`)))
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						root ((APP_ROOT))/public;
+						set $auth_path $uri;
+					`)))
+				})
+
+				It("requires credentials per rule", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						map $auth_rule $auth_basic_realm {
+						default "Restricted";
+						1 off;
+						2 "Restricted";
+						3 off;
+						}
+					`)))
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						auth_basic $auth_basic_realm;
+						auth_basic_user_file ((APP_ROOT))/nginx/conf/.htpasswd;
+					`)))
+					Expect(string(data)).NotTo(ContainSubstring(basicAuthConf))
+				})
+
+				It("checks the client address that the trusted proxies report against the allow and deny lists", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						set_real_ip_from 10.0.0.0/8;
+						set_real_ip_from 172.16.0.0/12;
+						set_real_ip_from 192.168.0.0/16;
+						set_real_ip_from 127.0.0.1;
+						set_real_ip_from fc00::/7;
+						set_real_ip_from ::1;
+						real_ip_header X-Forwarded-For;
+						real_ip_recursive on;
+						if ($auth_ip_allowed = 0) {
+					`)))
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						geo $auth_ip_2 {
+						default 0;
+						10.1.2.3 0;
+						10.0.0.0/8 1;
+						}
+						map $auth_rule $auth_ip_allowed {
+						default 1;
+						2 $auth_ip_2;
+						}
+					`)))
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						if ($auth_ip_allowed = 0) {
+						return 403;
+						}
+					`)))
+				})
+
+				Context("and there are no IP rules", func() {
+					BeforeEach(func() {
+						staticfile.AuthRules[1].Allow = nil
+						staticfile.AuthRules[1].Deny = nil
+					})
+
+					It("does not check the client address", func() {
+						data := readNginxConfAndStrip()
+						Expect(string(data)).NotTo(ContainSubstring("real_ip"))
+						Expect(string(data)).NotTo(ContainSubstring("$auth_ip_allowed"))
+					})
+				})
+
+				Context("and trusted_proxies is set", func() {
+					BeforeEach(func() {
+						staticfile.TrustedProxies = []string{"10.0.16.0/24"}
+					})
+
+					It("trusts only those proxies", func() {
+						data := readNginxConfAndStrip()
+						Expect(string(data)).To(ContainSubstring("set_real_ip_from 10.0.16.0/24;\nreal_ip_header X-Forwarded-For;\n"))
+						Expect(string(data)).NotTo(ContainSubstring("set_real_ip_from 10.0.0.0/8;"))
+					})
+				})
+			})
+
+			Context("auth rules only restrict addresses", func() {
+				BeforeEach(func() {
+					staticfile.AuthRules = []finalize.AuthRule{
+						{Path: "/internal/**", Deny: []string{"192.168.0.0/16"}, Index: 1},
+					}
+				})
+
+				It("does not enable basic authentication", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).NotTo(ContainSubstring("auth_basic"))
+					Expect(string(data)).To(ContainSubstring(stripStartWsp(`
+						geo $auth_ip_1 {
+						default 1;
+						192.168.0.0/16 0;
+						}
+					`)))
+				})
+			})
+
+			Context("there is a Staticfile.auth", func() {
+				BeforeEach(func() {
+					staticfile.BasicAuth = true
+					err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("authentication info"), 0644)
+					Expect(err).To(BeNil())
+				})
+
+				It("it enables basic authentication", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(basicAuthConf))
+				})
+
+				It("copies the Staticfile.auth to .htpasswd", func() {
+					data, err = os.ReadFile(filepath.Join(buildDir, "nginx", "conf", ".htpasswd"))
+					Expect(err).To(BeNil())
+					Expect(string(data)).To(Equal("authentication info"))
+				})
+			})
+
+			Context("basic_auth is set", func() {
+				BeforeEach(func() {
+					staticfile.BasicAuth = true
+					staticfile.BasicAuthSource = &finalize.BasicAuthSource{Env: "STATICFILE_AUTH_USERS"}
+					err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("authentication info"), 0644)
+					Expect(err).To(BeNil())
+				})
+
+				It("enables basic authentication", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(basicAuthConf))
+				})
+
+				It("leaves writing .htpasswd to the launcher", func() {
+					Expect(filepath.Join(buildDir, "nginx", "conf", ".htpasswd")).NotTo(BeAnExistingFile())
 				})
 			})
 
@@ -845,6 +2100,225 @@ var _ = Describe("Compile", func() {
 					Expect(filepath.Join(buildDir, "nginx", "conf", ".htpasswd")).NotTo(BeAnExistingFile())
 				})
 			})
+
+			Context("log_format is json in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.LogFormat = "json"
+				})
+
+				It("logs requests as escaped JSON", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(`log_format json escape=json '{"time":"$time_iso8601","request":"$request",`))
+					Expect(string(data)).To(ContainSubstring(`"status":$status,"bytes":$body_bytes_sent,"duration":$request_time,"upstream_time":"$upstream_response_time",`))
+					Expect(string(data)).To(ContainSubstring(`"user_agent":"$http_user_agent","vcap_request_id":"$http_x_vcap_request_id","trace_id":"$http_x_b3_traceid"}';`))
+					Expect(string(data)).To(ContainSubstring("access_log ((APP_ROOT))/nginx/logs/access.log json;\n"))
+					Expect(string(data)).NotTo(ContainSubstring("cloudfoundry"))
+				})
+			})
+
+			Context("log_format is not set in staticfile", func() {
+				It("logs requests in the cloudfoundry format", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("access_log ((APP_ROOT))/nginx/logs/access.log cloudfoundry;\n"))
+					Expect(string(data)).NotTo(ContainSubstring("escape=json"))
+				})
+			})
+
+			Context("error_log_level is set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.ErrorLogLevel = "notice"
+				})
+
+				It("logs at that level", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("error_log ((APP_ROOT))/nginx/logs/error.log notice;\n"))
+				})
+			})
+
+			Context("health_check is set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.HealthCheck = &finalize.HealthCheck{Path: "/__health", BuildpackVersion: "1.6.0"}
+					staticfile.ForceHTTPS = true
+				})
+
+				It("skips the server level redirects for the health check", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("root ((APP_ROOT))/public;\nif ($uri = /__health) {\nbreak;\n}\n"))
+				})
+
+				It("answers with the versions without authentication", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("location = /__health {\nauth_basic off;\naccess_log off;\ndefault_type application/json;\nadd_header Cache-Control \"no-store\" always;\nreturn 200 '{\"status\":\"ok\",\"buildpack_version\":\"1.6.0\",\"nginx_version\":\"$nginx_version\"}';\n}\n"))
+					Expect(string(data)).NotTo(ContainSubstring("return 503"))
+				})
+
+				Context("with readiness", func() {
+					BeforeEach(func() {
+						staticfile.HealthCheck.Readiness = true
+					})
+
+					It("answers 503 while the launcher has not marked the app as ready", func() {
+						data := readNginxConfAndStrip()
+						Expect(string(data)).To(ContainSubstring("if (!-f ((APP_ROOT))/.staticfile/ready) {\nreturn 503 '{\"status\":\"unavailable\",\"buildpack_version\":\"1.6.0\",\"nginx_version\":\"$nginx_version\"}';\n}\nreturn 200 '{\"status\":\"ok\""))
+					})
+				})
+
+				Context("with sso", func() {
+					BeforeEach(func() {
+						staticfile.SSO = &finalize.SSO{}
+					})
+
+					It("turns off the auth request", func() {
+						data := readNginxConfAndStrip()
+						Expect(string(data)).To(ContainSubstring("location = /__health {\nauth_basic off;\nauth_request off;\n"))
+					})
+				})
+			})
+
+			Context("sbom_route is set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.SBOMRoute = &finalize.SBOMRoute{Path: "/__sbom"}
+					staticfile.BasicAuth = true
+					err = os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("authentication info"), 0644)
+					Expect(err).To(BeNil())
+				})
+
+				It("serves the SBOM from outside public, behind basic authentication", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("location = /__sbom {\nauth_basic \"SBOM\";\nauth_basic_user_file ((APP_ROOT))/nginx/conf/.htpasswd;\ntypes { }\ndefault_type application/vnd.cyclonedx+json;\nadd_header Cache-Control \"no-store\" always;\nalias ((APP_ROOT))/.staticfile/sbom.cdx.json;\n}\n"))
+				})
+			})
+
+			Context("metrics is set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.Metrics = &finalize.Metrics{Path: "/__metrics", Allow: []string{"10.255.0.0/16"}}
+				})
+
+				It("serves stub_status to the exporter", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("server {\nlisten unix:((APP_ROOT))/nginx/status.sock;\naccess_log off;\nlocation = /stub_status {\nstub_status;\n}\n}\n"))
+				})
+
+				It("proxies the metrics path to the exporter for the allowed addresses only", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("if ($uri = /__metrics) {\nbreak;\n}\n"))
+					Expect(string(data)).To(ContainSubstring("location = /__metrics {\nauth_basic off;\nallow 10.255.0.0/16;\ndeny all;\naccess_log off;\nproxy_pass http://unix:((APP_ROOT))/nginx/metrics.sock;\n}\n"))
+				})
+
+				Context("with a port and basic auth", func() {
+					BeforeEach(func() {
+						staticfile.Metrics.Port = 9113
+						staticfile.BasicAuth = true
+						staticfile.BasicAuthSource = &finalize.BasicAuthSource{Env: "HTPASSWD"}
+					})
+
+					It("serves the metrics on their own port behind basic auth and the allow list", func() {
+						data := readNginxConfAndStrip()
+						Expect(string(data)).To(ContainSubstring("server {\nlisten 9113;\naccess_log off;\nlocation = /__metrics {\nauth_basic \"Metrics\";\nauth_basic_user_file ((APP_ROOT))/nginx/conf/.htpasswd;\nallow 10.255.0.0/16;\ndeny all;\n"))
+						Expect(string(data)).NotTo(ContainSubstring("if ($uri = /__metrics)"))
+					})
+				})
+			})
+
+			Context("error_log_level is not set in staticfile", func() {
+				It("leaves the level to the launcher", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("error_log ((APP_ROOT))/nginx/logs/error.log ((ERROR_LOG_LEVEL));\n"))
+				})
+			})
+
+			Context("access_log filters are set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.AccessLog = &finalize.AccessLog{
+						ExcludePaths:      []string{"/healthz", "/assets/**"},
+						ExcludeStatus:     []string{"3xx", "404"},
+						ExcludeUserAgents: []string{"kube-probe", "Go-http-client/1.1"},
+						Sample:            "12.5%",
+					}
+				})
+
+				It("excludes paths as they were requested", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("map $log_path $log_path_included {\ndefault 1;\n\"~^/healthz$\" 0;\n\"~^/assets/.*$\" 0;\n}\n"))
+					Expect(string(data)).To(ContainSubstring("root ((APP_ROOT))/public;\nset $log_path $uri;\n"))
+				})
+
+				It("excludes status codes and classes", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("map $status $log_status_included {\ndefault 1;\n\"~^3\" 0;\n404 0;\n}\n"))
+				})
+
+				It("excludes user agents that contain a string, ignoring case", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring(`map $http_user_agent $log_agent_included {` + "\ndefault 1;\n" + `"~*kube-probe" 0;` + "\n" + `"~*Go-http-client/1\\.1" 0;` + "\n}\n"))
+				})
+
+				It("samples successful requests", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("split_clients $request_id $log_sample {\n12.5% 1;\n* 0;\n}\n"))
+					Expect(string(data)).To(ContainSubstring("map $status $log_sampled {\ndefault 1;\n\"~^[23]\" $log_sample;\n}\n"))
+				})
+
+				It("logs only requests that no filter excludes", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("map \"$log_path_included$log_status_included$log_agent_included$log_sampled\" $loggable {\ndefault 1;\n\"~0\" 0;\n}\n"))
+					Expect(string(data)).To(ContainSubstring("access_log ((APP_ROOT))/nginx/logs/access.log cloudfoundry if=$loggable;\n"))
+				})
+
+				Context("and only samples", func() {
+					BeforeEach(func() {
+						staticfile.AccessLog = &finalize.AccessLog{Sample: "1%"}
+					})
+
+					It("only adds the sampling maps", func() {
+						data := readNginxConfAndStrip()
+						Expect(string(data)).To(ContainSubstring("map \"$log_sampled\" $loggable {"))
+						Expect(string(data)).NotTo(ContainSubstring("$log_path"))
+						Expect(string(data)).NotTo(ContainSubstring("$log_status_included"))
+					})
+				})
+			})
+
+			Context("access_log filters are not set in staticfile", func() {
+				It("logs every request", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).NotTo(ContainSubstring("$loggable"))
+					Expect(string(data)).NotTo(ContainSubstring("$log_path"))
+				})
+			})
+
+			Context("sso is set in staticfile", func() {
+				BeforeEach(func() {
+					staticfile.SSO = &finalize.SSO{}
+					staticfile.StatusCodes = map[string]string{"404": "/404.html"}
+				})
+
+				It("asks the sidecar about every request", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("root ((APP_ROOT))/public;\nauth_request /_sso/auth;\nerror_page 401 = @sso_login;\n"))
+				})
+
+				It("sends unauthenticated users to the login even with custom error pages", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("error_page 401 = @sso_login;\nerror_page 404 /404.html;\n"))
+				})
+
+				It("passes the login, callback and logout to the sidecar without authentication", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).To(ContainSubstring("location = /_sso/auth {\ninternal;\nproxy_pass http://unix:((APP_ROOT))/nginx/sso.sock;\nproxy_pass_request_body off;\n"))
+					Expect(string(data)).To(ContainSubstring("location @sso_login {\nrewrite ^ /_sso/login break;\nproxy_pass http://unix:((APP_ROOT))/nginx/sso.sock;\n"))
+					Expect(string(data)).To(ContainSubstring("proxy_set_header X-Original-URI $request_uri;\n"))
+					Expect(string(data)).To(ContainSubstring("location ^~ /_sso/ {\nauth_request off;\nproxy_pass http://unix:((APP_ROOT))/nginx/sso.sock;\n"))
+				})
+			})
+
+			Context("sso is not set in staticfile", func() {
+				It("does not use auth_request", func() {
+					data := readNginxConfAndStrip()
+					Expect(string(data)).NotTo(ContainSubstring("auth_request"))
+					Expect(string(data)).NotTo(ContainSubstring("/_sso/"))
+				})
+			})
 		})
 
 		Context("custom mime.types exists", func() {
@@ -872,6 +2346,221 @@ var _ = Describe("Compile", func() {
 		})
 	})
 
+	Describe("LoadRedirectsFile", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "public"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "public", "_redirects"), []byte("/new /old 302\n"), 0644)).To(Succeed())
+		})
+
+		Context("the _redirects file leads back to a redirect in the Staticfile", func() {
+			BeforeEach(func() {
+				staticfile.Redirects = []finalize.Redirect{{From: "/old", To: "/new", Status: 301}}
+			})
+
+			It("reports the loop", func() {
+				Expect(finalizer.LoadRedirectsFile()).To(MatchError("the redirects in the Staticfile and _redirects form a loop:\nredirect loop: /old -> /new -> /old"))
+			})
+		})
+
+		Context("the redirects do not loop", func() {
+			BeforeEach(func() {
+				staticfile.Redirects = []finalize.Redirect{{From: "/old", To: "/elsewhere", Status: 301}}
+			})
+
+			It("adds the redirects from _redirects", func() {
+				Expect(finalizer.LoadRedirectsFile()).To(Succeed())
+				Expect(finalizer.Config.Redirects).To(HaveLen(2))
+				Expect(filepath.Join(buildDir, "public", "_redirects")).NotTo(BeAnExistingFile())
+			})
+		})
+	})
+
+	Describe("TestNginxConf", func() {
+		var (
+			nginx    string
+			confDir  string
+			testConf string
+		)
+
+		BeforeEach(func() {
+			nginx = filepath.Join(depDir, "nginx", "sbin", "nginx")
+			confDir = filepath.Join(buildDir, "nginx", "conf")
+			testConf = filepath.Join(confDir, "nginx.staging.conf")
+
+			Expect(os.MkdirAll(confDir, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(confDir, "nginx.conf"), []byte("root ((APP_ROOT))/public;\n((LISTEN_DIRECTIVE))\nproxy_pass ((PROXY_URL:API_URL));\n"), 0644)).To(Succeed())
+
+			staticfile.Proxies = []finalize.Proxy{{Prefix: "/api", URLEnv: "API_URL"}}
+		})
+
+		Context("nginx has been supplied", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Dir(nginx), 0755)).To(Succeed())
+				Expect(os.WriteFile(nginx, []byte("nginx"), 0755)).To(Succeed())
+			})
+
+			Context("the configuration is valid", func() {
+				var rendered string
+
+				BeforeEach(func() {
+					mockCmd.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), nginx, "-t", "-q", "-p", filepath.Join(buildDir, "nginx"), "-c", testConf).Do(
+						func(_ string, _, _ io.Writer, _ string, _ ...string) {
+							contents, err := os.ReadFile(testConf)
+							Expect(err).To(BeNil())
+							rendered = string(contents)
+						})
+				})
+
+				It("tests the configuration with the placeholders filled in", func() {
+					Expect(finalizer.TestNginxConf()).To(Succeed())
+					Expect(rendered).To(Equal("root " + buildDir + "/public;\nlisten 8080;\nproxy_pass http://127.0.0.1/;\n"))
+				})
+
+				It("removes the test copy of nginx.conf", func() {
+					Expect(finalizer.TestNginxConf()).To(Succeed())
+					Expect(testConf).NotTo(BeAnExistingFile())
+				})
+			})
+
+			Context("a proxy has a literal URL", func() {
+				var rendered string
+
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(confDir, "nginx.conf"), []byte("proxy_pass http://api.apps.internal:8080/v1/;\nproxy_pass https://chat.example.com/;\n"), 0644)).To(Succeed())
+					staticfile.Proxies = []finalize.Proxy{
+						{Prefix: "/api", URL: "http://api.apps.internal:8080/v1/"},
+						{Prefix: "/chat", URL: "https://chat.example.com/"},
+					}
+
+					mockCmd.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), nginx, "-t", "-q", "-p", filepath.Join(buildDir, "nginx"), "-c", testConf).Do(
+						func(_ string, _, _ io.Writer, _ string, _ ...string) {
+							contents, err := os.ReadFile(testConf)
+							Expect(err).To(BeNil())
+							rendered = string(contents)
+						})
+				})
+
+				It("tests the configuration with a local host, which resolves while staging", func() {
+					Expect(finalizer.TestNginxConf()).To(Succeed())
+					Expect(rendered).To(Equal("proxy_pass http://127.0.0.1:8080/v1/;\nproxy_pass https://127.0.0.1/;\n"))
+				})
+
+				It("leaves nginx.conf with the URLs from the Staticfile", func() {
+					Expect(finalizer.TestNginxConf()).To(Succeed())
+					Expect(os.ReadFile(filepath.Join(confDir, "nginx.conf"))).To(ContainSubstring("proxy_pass http://api.apps.internal:8080/v1/;"))
+				})
+			})
+
+			Context("the configuration is invalid", func() {
+				BeforeEach(func() {
+					mockCmd.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), nginx, "-t", "-q", "-p", filepath.Join(buildDir, "nginx"), "-c", testConf).DoAndReturn(
+						func(_ string, stdout, _ io.Writer, _ string, _ ...string) error {
+							fmt.Fprintf(stdout, "nginx: [emerg] unknown directive \"bogus\" in %s/public/nginx/custom.conf:2\n", buildDir)
+							fmt.Fprintf(stdout, "nginx: configuration file %s test failed\n", testConf)
+							return errors.New("exit status 1")
+						})
+				})
+
+				It("returns the nginx output with paths relative to the app", func() {
+					Expect(finalizer.TestNginxConf()).To(MatchError("nginx -t failed:\n" +
+						"nginx: [emerg] unknown directive \"bogus\" in public/nginx/custom.conf:2\n" +
+						"nginx: configuration file nginx/conf/nginx.conf test failed"))
+					Expect(testConf).NotTo(BeAnExistingFile())
+				})
+			})
+		})
+
+		Context("nginx has not been supplied", func() {
+			It("warns and skips the test", func() {
+				Expect(finalizer.TestNginxConf()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Unable to find nginx at " + nginx))
+			})
+		})
+	})
+
+	Describe("Run", func() {
+		BeforeEach(func() {
+			mockYaml.EXPECT().Load(gomock.Any(), gomock.Any()).AnyTimes()
+			Expect(os.WriteFile(filepath.Join(buildDir, "index.html"), []byte("hello"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "_redirects"), []byte("/old /new\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "mime.types"), []byte("types {}"), 0644)).To(Succeed())
+		})
+
+		It("lists in the SBOM only the files left in public", func() {
+			Expect(finalize.Run(finalizer)).To(Succeed())
+
+			data, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "sbom.cdx.json"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(ContainSubstring(`"name": "public/index.html"`))
+			Expect(string(data)).NotTo(ContainSubstring("_redirects"))
+			Expect(string(data)).NotTo(ContainSubstring("mime.types"))
+		})
+	})
+
+	Describe("WriteSBOM", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "public", "css"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "public", "index.html"), []byte("hello"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "public", "css", "app.css"), []byte(""), 0644)).To(Succeed())
+		})
+
+		readSBOM := func() map[string]interface{} {
+			var sbom map[string]interface{}
+			data, err := os.ReadFile(filepath.Join(buildDir, ".staticfile", "sbom.cdx.json"))
+			Expect(err).To(BeNil())
+			Expect(json.Unmarshal(data, &sbom)).To(Succeed())
+			return sbom
+		}
+
+		Context("supply wrote a dependency report", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(depDir, "staticfile"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(depDir, "staticfile", "dependencies.json"), []byte(`{"dependencies": [{
+					"name": "nginx",
+					"version": "1.27.5",
+					"uri": "https://example.com/nginx_1.27.5.tgz",
+					"sha256": "binarysha",
+					"source": "http://nginx.org/download/nginx-1.27.5.tar.gz",
+					"source_sha256": "sourcesha"
+				}]}`), 0644)).To(Succeed())
+			})
+
+			It("describes the buildpack, nginx and the files in public", func() {
+				Expect(finalizer.WriteSBOM()).To(Succeed())
+				Expect(buffer.String()).To(Equal("-----> Writing a CycloneDX SBOM of 2 files to .staticfile/sbom.cdx.json\n"))
+
+				sbom := readSBOM()
+				Expect(sbom).To(HaveKeyWithValue("bomFormat", "CycloneDX"))
+				Expect(sbom).To(HaveKeyWithValue("metadata", map[string]interface{}{"tools": map[string]interface{}{"components": []interface{}{
+					map[string]interface{}{"type": "application", "name": "staticfile-buildpack", "version": "1.6.0"},
+				}}}))
+				Expect(sbom["components"]).To(Equal([]interface{}{
+					map[string]interface{}{
+						"type":    "application",
+						"name":    "nginx",
+						"version": "1.27.5",
+						"purl":    "pkg:generic/nginx@1.27.5",
+						"hashes":  []interface{}{map[string]interface{}{"alg": "SHA-256", "content": "binarysha"}},
+						"externalReferences": []interface{}{
+							map[string]interface{}{"type": "distribution", "url": "https://example.com/nginx_1.27.5.tgz", "hashes": []interface{}{map[string]interface{}{"alg": "SHA-256", "content": "binarysha"}}},
+							map[string]interface{}{"type": "source-distribution", "url": "http://nginx.org/download/nginx-1.27.5.tar.gz", "hashes": []interface{}{map[string]interface{}{"alg": "SHA-256", "content": "sourcesha"}}},
+						},
+					},
+					map[string]interface{}{"type": "file", "name": "public/css/app.css", "hashes": []interface{}{map[string]interface{}{"alg": "SHA-256", "content": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}},
+					map[string]interface{}{"type": "file", "name": "public/index.html", "hashes": []interface{}{map[string]interface{}{"alg": "SHA-256", "content": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}}},
+				}))
+			})
+		})
+
+		Context("there is no dependency report", func() {
+			It("lists only the files", func() {
+				Expect(finalizer.WriteSBOM()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("**WARNING** There is no staticfile/dependencies.json, so the SBOM does not list nginx\n"))
+				Expect(readSBOM()["components"]).To(HaveLen(2))
+			})
+		})
+	})
+
 	Describe("CopyFilesToPublic", func() {
 		var (
 			appRootDir          string
@@ -1021,5 +2710,118 @@ var _ = Describe("Compile", func() {
 				})
 			})
 		})
+
+		Context("precompress is set", func() {
+			var (
+				publicDir string
+				script    string
+			)
+
+			BeforeEach(func() {
+				staticfile.Precompress = true
+				appRootDir, err = os.MkdirTemp("", "staticfile-buildpack.app_root.")
+				Expect(err).To(BeNil())
+				publicDir = filepath.Join(buildDir, "public")
+
+				script = strings.Repeat("console.log('hello world');\n", 100)
+				Expect(os.WriteFile(filepath.Join(appRootDir, "app.js"), []byte(script), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(appRootDir, "small.css"), []byte("body { margin: 0; }"), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(appRootDir, "logo.png"), bytes.Repeat([]byte{0}, 4096), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(appRootDir, "vendor.js"), []byte(script), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(appRootDir, "vendor.js.gz"), []byte("already compressed"), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(appRootDir, "main.js"), []byte(script), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(appRootDir, "main.js.br"), []byte("already compressed"), 0644)).To(Succeed())
+			})
+
+			Context("nginx does not support brotli_static", func() {
+				BeforeEach(func() {
+					mockCmd.EXPECT().Execute("", gomock.Any(), gomock.Any(), filepath.Join(depDir, "nginx", "sbin", "nginx"), "-V").Return(nil)
+				})
+
+				It("writes gzip files for large compressible files", func() {
+					contents, err := os.ReadFile(filepath.Join(publicDir, "app.js.gz"))
+					Expect(err).To(BeNil())
+					Expect(len(contents)).To(BeNumerically("<", len(script)))
+
+					reader, err := gzip.NewReader(bytes.NewReader(contents))
+					Expect(err).To(BeNil())
+					uncompressed, err := io.ReadAll(reader)
+					Expect(err).To(BeNil())
+					Expect(string(uncompressed)).To(Equal(script))
+				})
+
+				It("gives the gzip files the modification time of the original", func() {
+					original, err := os.Stat(filepath.Join(publicDir, "app.js"))
+					Expect(err).To(BeNil())
+					compressed, err := os.Stat(filepath.Join(publicDir, "app.js.gz"))
+					Expect(err).To(BeNil())
+					Expect(compressed.ModTime()).To(Equal(original.ModTime()))
+				})
+
+				It("skips small and incompressible files", func() {
+					Expect(filepath.Join(publicDir, "small.css.gz")).NotTo(BeAnExistingFile())
+					Expect(filepath.Join(publicDir, "logo.png.gz")).NotTo(BeAnExistingFile())
+				})
+
+				It("keeps the compressed siblings that the app ships", func() {
+					Expect(os.ReadFile(filepath.Join(publicDir, "vendor.js.gz"))).To(Equal([]byte("already compressed")))
+				})
+
+				It("writes the gzip file that is missing next to a brotli file", func() {
+					Expect(filepath.Join(publicDir, "main.js.gz")).To(BeAnExistingFile())
+					Expect(os.ReadFile(filepath.Join(publicDir, "main.js.br"))).To(Equal([]byte("already compressed")))
+				})
+
+				It("does not write brotli files", func() {
+					Expect(filepath.Join(publicDir, "app.js.br")).NotTo(BeAnExistingFile())
+					Expect(finalizer.Config.BrotliStatic).To(BeFalse())
+				})
+
+				It("logs the bytes saved", func() {
+					Expect(buffer.String()).To(MatchRegexp(`Wrote 2 gzip files, saving \d+ bytes`))
+				})
+			})
+
+			Context("nginx supports brotli_static", func() {
+				BeforeEach(func() {
+					mockCmd.EXPECT().Execute("", gomock.Any(), gomock.Any(), filepath.Join(depDir, "nginx", "sbin", "nginx"), "-V").Do(func(_ string, _, stderr io.Writer, _ string, _ ...string) {
+						fmt.Fprint(stderr, "configure arguments: --add-dynamic-module=../ngx_brotli")
+					})
+				})
+
+				Context("and brotli is installed", func() {
+					BeforeEach(func() {
+						mockCmd.EXPECT().Execute("", gomock.Any(), gomock.Any(), "brotli", "--version").Return(nil)
+						mockCmd.EXPECT().Execute("", gomock.Any(), gomock.Any(), "brotli", "--best", gomock.Any(), gomock.Any()).Do(func(_ string, _, _ io.Writer, _ string, args ...string) {
+							Expect(os.WriteFile(strings.TrimPrefix(args[1], "--output="), []byte("brotli"), 0644)).To(Succeed())
+						}).Times(2)
+					})
+
+					It("writes brotli files and enables brotli_static", func() {
+						Expect(filepath.Join(publicDir, "app.js.br")).To(BeAnExistingFile())
+						Expect(finalizer.Config.BrotliStatic).To(BeTrue())
+					})
+
+					It("writes the brotli file that is missing next to a gzip file", func() {
+						Expect(filepath.Join(publicDir, "vendor.js.br")).To(BeAnExistingFile())
+						Expect(os.ReadFile(filepath.Join(publicDir, "vendor.js.gz"))).To(Equal([]byte("already compressed")))
+						Expect(os.ReadFile(filepath.Join(publicDir, "main.js.br"))).To(Equal([]byte("already compressed")))
+					})
+				})
+
+				Context("and brotli is not installed", func() {
+					BeforeEach(func() {
+						mockCmd.EXPECT().Execute("", gomock.Any(), gomock.Any(), "brotli", "--version").Return(errors.New("executable file not found"))
+					})
+
+					It("only writes gzip files", func() {
+						Expect(filepath.Join(publicDir, "app.js.gz")).To(BeAnExistingFile())
+						Expect(filepath.Join(publicDir, "app.js.br")).NotTo(BeAnExistingFile())
+						Expect(finalizer.Config.BrotliStatic).To(BeFalse())
+						Expect(buffer.String()).To(ContainSubstring("no brotli encoder is available"))
+					})
+				})
+			})
+		})
 	})
 })
@@ -0,0 +1,31 @@
+package finalize
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type HealthCheck struct {
+	Path             string
+	Readiness        bool
+	BuildpackVersion string
+}
+
+const defaultHealthCheckPath = "/__health"
+
+// getHealthCheck reads health_check. With readiness, it answers 503 until the
+// launcher has started nginx and its sidecars, and again once the app stops.
+func (sf *Finalizer) getHealthCheck(raw interface{}, isEnabled func(string) bool) (*HealthCheck, error) {
+	fields, err := getRoute("health_check", raw, defaultHealthCheckPath, healthCheckSchema, isEnabled)
+	if fields == nil {
+		return nil, err
+	}
+	return &HealthCheck{Path: fields["path"], Readiness: isEnabled(fields["readiness"]), BuildpackVersion: sf.BuildpackVersion}, nil
+}
+
+// Body is the JSON the health check answers with, as an nginx string. nginx fills
+// in its own version.
+func (h HealthCheck) Body(status string) string {
+	version, _ := json.Marshal(h.BuildpackVersion)
+	return fmt.Sprintf(`'{"status":"%s","buildpack_version":%s,"nginx_version":"$nginx_version"}'`, status, version)
+}
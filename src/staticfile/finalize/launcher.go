@@ -0,0 +1,56 @@
+package finalize
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/launch"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/sso"
+)
+
+// WriteLauncher copies the launch binary into the droplet, along with the parts
+// of the Staticfile it needs at startup. The launcher fills in the environment
+// dependent parts of nginx.conf and then runs nginx until it or a sidecar exits.
+// With sso, the sidecar that nginx asks about every request is copied too.
+func (sf *Finalizer) WriteLauncher() error {
+	if err := libbuildpack.CopyFile(sf.LaunchBinary, filepath.Join(sf.BuildDir, ".staticfile", "launch")); err != nil {
+		return err
+	}
+
+	config := launch.Config{
+		ErrorLogInstanceIndex: sf.Config.ErrorLogInstanceIndex,
+		Metrics:               sf.Config.Metrics != nil,
+		Readiness:             sf.Config.HealthCheck != nil && sf.Config.HealthCheck.Readiness,
+	}
+	if runtimeEnv := sf.Config.RuntimeEnv; runtimeEnv != nil {
+		config.RuntimeEnv = &launch.RuntimeEnv{
+			Variables: runtimeEnv.Variables,
+			Path:      runtimeEnv.Path,
+			Files:     runtimeEnv.Files,
+		}
+	}
+
+	if source := sf.Config.BasicAuthSource; source != nil {
+		config.BasicAuth = &launch.BasicAuth{Env: source.Env, Service: source.Service}
+	}
+
+	if settings := sf.Config.SSO; settings != nil {
+		if err := libbuildpack.CopyFile(sf.SSOBinary, filepath.Join(sf.BuildDir, ".staticfile", "sso")); err != nil {
+			return err
+		}
+		config.SSO = &sso.Settings{
+			Service:     settings.Service,
+			Groups:      settings.Groups,
+			GroupsClaim: settings.GroupsClaim,
+			Scopes:      settings.Scopes,
+		}
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sf.BuildDir, launch.ConfigFile), data, 0644)
+}
@@ -0,0 +1,140 @@
+package finalize
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Header struct {
+	Name  string
+	Value string
+}
+
+// QuotedValue returns the header value as a double quoted nginx string. Variables
+// are not expanded, so a literal $ is emitted through the $dollar variable.
+func (h Header) QuotedValue() string {
+	return quoteNginxString(h.Value)
+}
+
+type Location struct {
+	Staticfile
+	Path    string
+	Headers []Header
+}
+
+// Match returns the modifier and pattern that follow the location directive for
+// this location's path glob.
+func (l Location) Match() string {
+	if l.Path == "" {
+		return "/"
+	}
+	return fmt.Sprintf(`~ "%s"`, globToRegex(l.Path))
+}
+
+type nginxConfData struct {
+	Staticfile
+	Root      Location
+	Locations []Location
+}
+
+var (
+	headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+	pathGlobPattern   = regexp.MustCompile(`^/[A-Za-z0-9._~%@+,=:/*?-]*$`)
+)
+
+func validHeaderName(name string) bool {
+	return headerNamePattern.MatchString(name)
+}
+
+func validHeaderValue(value string) bool {
+	return !strings.ContainsFunc(value, func(r rune) bool {
+		return r < 0x20 && r != '\t' || r == 0x7f
+	})
+}
+
+func validPathGlob(glob string) bool {
+	return pathGlobPattern.MatchString(glob)
+}
+
+func quoteNginxString(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `${dollar}`).Replace(value)
+	return `"` + value + `"`
+}
+
+// globToRegex converts a path glob into an anchored regular expression. A single
+// * matches within one path segment, ** matches across segments and ? matches a
+// single character other than /.
+func globToRegex(glob string) string {
+	var regex strings.Builder
+	regex.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			regex.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			regex.WriteString("[^/]*")
+		case glob[i] == '?':
+			regex.WriteString("[^/]")
+		default:
+			regex.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	regex.WriteString("$")
+	return regex.String()
+}
+
+func globSpecificity(glob string) int {
+	return len(strings.NewReplacer("*", "", "?", "").Replace(glob))
+}
+
+func sortHeaders(headers map[string]string) []Header {
+	var sorted []Header
+	for name, value := range headers {
+		sorted = append(sorted, Header{Name: name, Value: value})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.ToLower(sorted[i].Name) < strings.ToLower(sorted[j].Name)
+	})
+	return sorted
+}
+
+// locations builds the location / block and one regex location block per path
+// glob. nginx uses the first regex location that matches, so globs with more
+// literal characters are listed first.
+func (sf *Finalizer) locations() (Location, []Location) {
+	conf := sf.Config
+	root := Location{Staticfile: conf, Headers: sortHeaders(conf.Headers)}
+
+	var globs []string
+	for glob := range conf.PathHeaders {
+		globs = append(globs, glob)
+	}
+	sort.Slice(globs, func(i, j int) bool {
+		if globSpecificity(globs[i]) != globSpecificity(globs[j]) {
+			return globSpecificity(globs[i]) > globSpecificity(globs[j])
+		}
+		return globs[i] < globs[j]
+	})
+
+	var locations []Location
+	for _, glob := range globs {
+		headers := map[string]string{}
+		for name, value := range conf.Headers {
+			headers[name] = value
+		}
+		for name, value := range conf.PathHeaders[glob] {
+			for existing := range headers {
+				if strings.EqualFold(existing, name) {
+					delete(headers, existing)
+				}
+			}
+			headers[name] = value
+		}
+		locations = append(locations, Location{Staticfile: conf, Path: glob, Headers: sortHeaders(headers)})
+	}
+
+	return root, locations
+}
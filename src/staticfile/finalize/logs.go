@@ -0,0 +1,131 @@
+package finalize
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	logFormatPlain = "plain"
+	logFormatJSON  = "json"
+)
+
+// logFormats are the values of log_format. plain is the cloudfoundry format
+// nginx has always logged in.
+var logFormats = []string{logFormatPlain, logFormatJSON}
+
+// errorLogLevels are the levels of error_log_level, from the most verbose. Without
+// one, the launcher logs at error, or at debug when BP_DEBUG is set.
+var errorLogLevels = []string{"debug", "info", "notice", "warn", "error", "crit", "alert", "emerg"}
+
+// AccessLog leaves requests out of the access log. The error log is not
+// filtered.
+type AccessLog struct {
+	ExcludePaths      []string
+	ExcludeStatus     []string
+	ExcludeUserAgents []string
+	Sample            string
+}
+
+type AccessLogTemp struct {
+	ExcludePaths      []string `yaml:"exclude_paths"`
+	ExcludeStatus     []string `yaml:"exclude_status"`
+	ExcludeUserAgents []string `yaml:"exclude_user_agents"`
+	Sample            string   `yaml:"sample"`
+}
+
+var (
+	statusPattern = regexp.MustCompile(`^[1-5]([0-9]{2}|xx)$`)
+	samplePattern = regexp.MustCompile(`^[0-9]{1,2}(\.[0-9]{1,2})?%$`)
+)
+
+func validStatus(status string) bool {
+	return statusPattern.MatchString(status)
+}
+
+// validSample accepts the percentages split_clients can split by, other than 0%,
+// which exclude_status expresses better.
+func validSample(sample string) bool {
+	if !samplePattern.MatchString(sample) {
+		return false
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(sample, "%"), 64)
+	return err == nil && percent > 0
+}
+
+// PathPatterns are the keys of the $log_path_included map, which reads the path
+// before pushstate or proxies rewrite it.
+func (a AccessLog) PathPatterns() []string {
+	var patterns []string
+	for _, glob := range a.ExcludePaths {
+		patterns = append(patterns, fmt.Sprintf(`"~%s"`, globToRegex(glob)))
+	}
+	return patterns
+}
+
+// StatusPatterns are the keys of the $log_status_included map. A class such as
+// 2xx matches every status that starts with its digit.
+func (a AccessLog) StatusPatterns() []string {
+	var patterns []string
+	for _, status := range a.ExcludeStatus {
+		if strings.HasSuffix(status, "xx") {
+			patterns = append(patterns, fmt.Sprintf(`"~^%c"`, status[0]))
+		} else {
+			patterns = append(patterns, status)
+		}
+	}
+	return patterns
+}
+
+// UserAgentPatterns are the keys of the $log_agent_included map. A user agent is
+// excluded when it contains one of the strings, ignoring case.
+func (a AccessLog) UserAgentPatterns() []string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	var patterns []string
+	for _, agent := range a.ExcludeUserAgents {
+		patterns = append(patterns, `"~*`+escape.Replace(regexp.QuoteMeta(agent))+`"`)
+	}
+	return patterns
+}
+
+// Condition joins the variables that are 0 for requests that are not logged, so
+// that a single map can decide for access_log if=.
+func (a AccessLog) Condition() string {
+	var condition strings.Builder
+	if len(a.ExcludePaths) > 0 {
+		condition.WriteString("$log_path_included")
+	}
+	if len(a.ExcludeStatus) > 0 {
+		condition.WriteString("$log_status_included")
+	}
+	if len(a.ExcludeUserAgents) > 0 {
+		condition.WriteString("$log_agent_included")
+	}
+	if a.Sample != "" {
+		condition.WriteString("$log_sampled")
+	}
+	return condition.String()
+}
+
+func (a AccessLog) String() string {
+	var filters []string
+	if len(a.ExcludePaths) > 0 {
+		filters = append(filters, "paths "+strings.Join(a.ExcludePaths, ", "))
+	}
+	if len(a.ExcludeStatus) > 0 {
+		filters = append(filters, "status "+strings.Join(a.ExcludeStatus, ", "))
+	}
+	if len(a.ExcludeUserAgents) > 0 {
+		filters = append(filters, "user agents "+strings.Join(a.ExcludeUserAgents, ", "))
+	}
+	description := "excluding " + strings.Join(filters, "; ")
+	if a.Sample != "" {
+		if len(filters) == 0 {
+			return fmt.Sprintf("logging %s of successful requests", a.Sample)
+		}
+		description += fmt.Sprintf("; logging %s of successful requests", a.Sample)
+	}
+	return description
+}
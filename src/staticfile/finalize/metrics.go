@@ -0,0 +1,40 @@
+package finalize
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Metrics struct {
+	Path  string
+	Port  int
+	Allow []string
+}
+
+const defaultMetricsPath = "/__metrics"
+
+// getMetrics reads metrics. Without a port the exporter is served on the app
+// route.
+func (sf *Finalizer) getMetrics(raw interface{}, isEnabled func(string) bool) (*Metrics, error) {
+	fields, err := getRoute("metrics", raw, defaultMetricsPath, metricsSchema, isEnabled)
+	if fields == nil {
+		return nil, err
+	}
+	metrics := &Metrics{Path: fields["path"]}
+	if port, ok := fields["port"]; ok {
+		// getRoute has checked that the port is a number.
+		metrics.Port, _ = strconv.Atoi(port)
+	}
+	if allow := fields["allow"]; allow != "" {
+		metrics.Allow = strings.Fields(allow)
+	}
+	return metrics, nil
+}
+
+func (m Metrics) String() string {
+	if m.Port != 0 {
+		return fmt.Sprintf("on port %d at %s", m.Port, m.Path)
+	}
+	return fmt.Sprintf("at %s", m.Path)
+}
@@ -0,0 +1,103 @@
+package finalize
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/launch"
+)
+
+// NginxBinary is the nginx that supply installs into the dep dir.
+func (sf *Finalizer) NginxBinary() string {
+	return filepath.Join(sf.DepDir, "nginx", "sbin", "nginx")
+}
+
+// TestNginxConf runs nginx -t against the generated configuration, so that a broken
+// location_include or nginx.conf fails staging instead of the app start. The
+// placeholders are filled in the way the launcher fills them in, with a local
+// address standing in for proxy URLs that come from the environment. nginx -t
+// resolves upstream hosts, and internal routes such as api.apps.internal do not
+// resolve while staging, so the hosts of literal proxy URLs are replaced with the
+// local address as well.
+func (sf *Finalizer) TestNginxConf() error {
+	nginx := sf.NginxBinary()
+	exists, err := libbuildpack.FileExists(nginx)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		sf.Log.Warning("Unable to find nginx at %s, the nginx configuration will only be tested when the app starts.", nginx)
+		return nil
+	}
+
+	sf.Log.BeginStep("Testing nginx configuration")
+
+	env := map[string]string{}
+	for _, proxy := range sf.Config.Proxies {
+		if proxy.URLEnv != "" {
+			env[proxy.URLEnv] = "http://127.0.0.1"
+		}
+	}
+	launcher := &launch.Launcher{
+		AppRoot: sf.BuildDir,
+		Env: func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		},
+	}
+
+	confDir := filepath.Join(sf.BuildDir, "nginx", "conf")
+	template, err := os.ReadFile(filepath.Join(confDir, "nginx.conf"))
+	if err != nil {
+		return err
+	}
+	conf, err := launcher.Render(string(template))
+	if err != nil {
+		return err
+	}
+	var upstreams []string
+	for _, proxy := range sf.Config.Proxies {
+		if proxy.URLEnv == "" {
+			upstreams = append(upstreams, "proxy_pass "+proxy.URL+";", "proxy_pass "+localUpstream(proxy.URL)+";")
+		}
+	}
+	conf = strings.NewReplacer(upstreams...).Replace(conf)
+
+	testConf := filepath.Join(confDir, "nginx.staging.conf")
+	if err := os.WriteFile(testConf, []byte(conf), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(testConf)
+
+	output := new(bytes.Buffer)
+	if err := sf.Command.Execute(sf.BuildDir, output, output, nginx, "-t", "-q", "-p", filepath.Join(sf.BuildDir, "nginx"), "-c", testConf); err != nil {
+		// Report paths relative to the app, and errors in the test copy against
+		// the nginx.conf they came from.
+		message := strings.NewReplacer(
+			testConf, "nginx/conf/nginx.conf",
+			sf.BuildDir+"/", "",
+		).Replace(strings.TrimSpace(output.String()))
+		return fmt.Errorf("nginx -t failed:\n%s", message)
+	}
+	return nil
+}
+
+// localUpstream returns the proxy URL with 127.0.0.1 for its host, keeping the
+// scheme, port and path that nginx -t checks.
+func localUpstream(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return proxyURL
+	}
+	host := "127.0.0.1"
+	if port := u.Port(); port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	return u.String()
+}
@@ -0,0 +1,206 @@
+package finalize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// precompressMinSize matches gzip_min_length in the generated nginx.conf, below
+// which nginx does not compress responses either.
+const precompressMinSize = 1100
+
+var compressibleExtensions = map[string]bool{
+	".html":        true,
+	".htm":         true,
+	".shtml":       true,
+	".css":         true,
+	".js":          true,
+	".mjs":         true,
+	".cjs":         true,
+	".json":        true,
+	".map":         true,
+	".xml":         true,
+	".rss":         true,
+	".atom":        true,
+	".svg":         true,
+	".txt":         true,
+	".md":          true,
+	".csv":         true,
+	".webmanifest": true,
+	".wasm":        true,
+	".ico":         true,
+	".ttf":         true,
+	".otf":         true,
+	".eot":         true,
+}
+
+type precompressStats struct {
+	files int64
+	saved int64
+}
+
+// PrecompressAssets writes .gz siblings, and .br siblings when nginx can serve
+// them, for the compressible files in publicDir so that gzip_static and
+// brotli_static never compress on the fly. A sibling that the app ships is kept,
+// and only the missing one is written.
+func (sf *Finalizer) PrecompressAssets(publicDir string) error {
+	sf.Log.BeginStep("Precompressing assets")
+
+	brotli := sf.brotliSupported()
+
+	var gzipFiles, brotliFiles []string
+	err := filepath.WalkDir(publicDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || !compressibleExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.Size() < precompressMinSize {
+			return err
+		}
+
+		if missing, err := missingSibling(path + ".gz"); err != nil {
+			return err
+		} else if missing {
+			gzipFiles = append(gzipFiles, path)
+		}
+		if brotli {
+			if missing, err := missingSibling(path + ".br"); err != nil {
+				return err
+			} else if missing {
+				brotliFiles = append(brotliFiles, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var gzipStats, brotliStats precompressStats
+	group := new(errgroup.Group)
+	group.SetLimit(min(runtime.NumCPU(), 4))
+
+	for _, file := range gzipFiles {
+		group.Go(func() error {
+			return sf.gzipFile(file, &gzipStats)
+		})
+	}
+	for _, file := range brotliFiles {
+		group.Go(func() error {
+			return sf.brotliFile(file, &brotliStats)
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	sf.Log.Info("Wrote %d gzip files, saving %d bytes", gzipStats.files, gzipStats.saved)
+	if brotli {
+		sf.Log.Info("Wrote %d brotli files, saving %d bytes", brotliStats.files, brotliStats.saved)
+		sf.Config.BrotliStatic = true
+	}
+
+	return nil
+}
+
+func missingSibling(path string) (bool, error) {
+	if _, err := os.Lstat(path); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// brotliSupported asks the nginx that supply installed, rather than one on the
+// PATH, whether it was built with the brotli module.
+func (sf *Finalizer) brotliSupported() bool {
+	output := new(bytes.Buffer)
+	if err := sf.Command.Execute("", output, output, sf.NginxBinary(), "-V"); err != nil {
+		sf.Log.Debug("Unable to determine nginx modules: %s", err.Error())
+		return false
+	}
+	if !strings.Contains(output.String(), "brotli") {
+		return false
+	}
+
+	if err := sf.Command.Execute("", io.Discard, io.Discard, "brotli", "--version"); err != nil {
+		sf.Log.Warning("nginx supports brotli_static, but no brotli encoder is available, only gzip files will be written.")
+		return false
+	}
+	return true
+}
+
+func (sf *Finalizer) gzipFile(path string, stats *precompressStats) error {
+	dest := path + ".gz"
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	compressed := new(bytes.Buffer)
+	writer, err := gzip.NewWriterLevel(compressed, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(contents); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if compressed.Len() >= len(contents) {
+		return nil
+	}
+
+	if err := os.WriteFile(dest, compressed.Bytes(), 0644); err != nil {
+		return err
+	}
+	return recordCompressed(path, dest, stats)
+}
+
+func (sf *Finalizer) brotliFile(path string, stats *precompressStats) error {
+	dest := path + ".br"
+	if err := sf.Command.Execute("", io.Discard, io.Discard, "brotli", "--best", "--output="+dest, path); err != nil {
+		return err
+	}
+	return recordCompressed(path, dest, stats)
+}
+
+// recordCompressed gives the compressed file the modification time of the
+// original, so Last-Modified and ETag do not depend on which file nginx serves.
+func recordCompressed(original, compressed string, stats *precompressStats) error {
+	originalInfo, err := os.Stat(original)
+	if err != nil {
+		return err
+	}
+	compressedInfo, err := os.Stat(compressed)
+	if err != nil {
+		return err
+	}
+
+	if compressedInfo.Size() >= originalInfo.Size() {
+		return os.Remove(compressed)
+	}
+	if err := os.Chtimes(compressed, originalInfo.ModTime(), originalInfo.ModTime()); err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&stats.files, 1)
+	atomic.AddInt64(&stats.saved, originalInfo.Size()-compressedInfo.Size())
+	return nil
+}
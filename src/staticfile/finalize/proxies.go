@@ -0,0 +1,113 @@
+package finalize
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/launch"
+)
+
+type Proxy struct {
+	Prefix         string
+	URL            string
+	URLEnv         string
+	Headers        []Header
+	ConnectTimeout string
+	Timeout        string
+	WebSocket      bool
+	SSLVerify      bool
+}
+
+type ProxyTemp struct {
+	URL            string            `yaml:"url"`
+	Headers        map[string]string `yaml:"headers"`
+	ConnectTimeout string            `yaml:"connect_timeout"`
+	Timeout        string            `yaml:"timeout"`
+	WebSocket      string            `yaml:"websocket"`
+	SSLVerify      string            `yaml:"ssl_verify"`
+}
+
+var (
+	proxyPrefixPattern = regexp.MustCompile(`^/[A-Za-z0-9._~%@+,=:/-]*$`)
+	proxyURLPattern    = launch.ProxyURLPattern
+	proxyEnvPattern    = regexp.MustCompile(`^\$\{?([A-Za-z_][A-Za-z0-9_]*)\}?$`)
+	durationPattern    = regexp.MustCompile(`^[0-9]+(ms|s|m|h|d)?$`)
+)
+
+// Location returns the prefix that the proxy location matches. It always ends in
+// a slash, so that /api does not also match /apidocs, and nginx redirects /api
+// to /api/.
+func (p Proxy) Location() string {
+	return withTrailingSlash(p.Prefix)
+}
+
+// Upstream returns the URL for proxy_pass. A URL taken from an environment
+// variable is a placeholder that the launcher replaces at startup.
+func (p Proxy) Upstream() string {
+	if p.URLEnv != "" {
+		return fmt.Sprintf("((PROXY_URL:%s))", p.URLEnv)
+	}
+	return p.URL
+}
+
+func withTrailingSlash(path string) string {
+	if strings.HasSuffix(path, "/") {
+		return path
+	}
+	return path + "/"
+}
+
+// parseProxyURL returns the upstream URL with a trailing slash, so that the
+// prefix is replaced by the path of the URL, or the name of the environment
+// variable that holds it.
+func parseProxyURL(url string) (string, string, error) {
+	if match := proxyEnvPattern.FindStringSubmatch(url); match != nil {
+		return "", match[1], nil
+	}
+	if !proxyURLPattern.MatchString(url) {
+		return "", "", fmt.Errorf("expected an http(s) URL without a query, or an environment variable such as ${API_URL}")
+	}
+	return withTrailingSlash(url), "", nil
+}
+
+func validDuration(duration string) bool {
+	return durationPattern.MatchString(duration)
+}
+
+func (s Staticfile) HasWebSocketProxy() bool {
+	for _, proxy := range s.Proxies {
+		if proxy.WebSocket {
+			return true
+		}
+	}
+	return false
+}
+
+func (sf *Finalizer) getProxies(raw map[string]ProxyTemp, isEnabled func(string) bool) []Proxy {
+	var proxies []Proxy
+	for prefix, proxyTemp := range raw {
+		url, urlEnv, err := parseProxyURL(proxyTemp.URL)
+		if err != nil {
+			sf.Log.Warning("Ignoring the proxy for %s: %s", prefix, err.Error())
+			continue
+		}
+
+		proxies = append(proxies, Proxy{
+			Prefix:         prefix,
+			URL:            url,
+			URLEnv:         urlEnv,
+			Headers:        sortHeaders(proxyTemp.Headers),
+			ConnectTimeout: proxyTemp.ConnectTimeout,
+			Timeout:        proxyTemp.Timeout,
+			WebSocket:      isEnabled(proxyTemp.WebSocket),
+			SSLVerify:      isEnabled(proxyTemp.SSLVerify),
+		})
+	}
+
+	sort.Slice(proxies, func(i, j int) bool {
+		return proxies[i].Prefix < proxies[j].Prefix
+	})
+	return proxies
+}
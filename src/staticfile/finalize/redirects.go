@@ -0,0 +1,377 @@
+package finalize
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Redirect struct {
+	From   string
+	To     string
+	Status int
+	Force  bool
+	Line   int `yaml:"-"`
+}
+
+type RedirectEntry struct {
+	Key   string
+	Value string
+}
+
+var redirectStatuses = map[int]bool{200: true, 301: true, 302: true, 303: true, 307: true, 308: true}
+
+var (
+	redirectSourcePattern      = regexp.MustCompile(`^/([A-Za-z0-9._~%@+,=:/-]*|([A-Za-z0-9._~%@+,=:/-]*/)?\*)$`)
+	redirectDestinationPattern = regexp.MustCompile(`^(/|https?://[A-Za-z0-9.-]+(:[0-9]+)?(/|$))[^\s"'\\{}]*$`)
+	redirectPlaceholderPattern = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// parseRedirect checks a single redirect. The status defaults to 301, and a 200
+// status rewrites the request internally. Redirects always apply, while a rewrite
+// only applies to paths that are not files, unless a trailing ! forces it.
+func parseRedirect(from, to, status string) (Redirect, error) {
+	redirect := Redirect{From: from, To: to, Status: 301}
+
+	status, redirect.Force = strings.CutSuffix(status, "!")
+	if status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil || !redirectStatuses[code] {
+			return redirect, fmt.Errorf("invalid status %q: expected one of 200, 301, 302, 303, 307, 308", status)
+		}
+		redirect.Status = code
+	}
+
+	if !redirectSourcePattern.MatchString(from) {
+		return redirect, fmt.Errorf("invalid source %q: expected a path, optionally ending in /*", from)
+	}
+	if !redirectDestinationPattern.MatchString(to) {
+		return redirect, fmt.Errorf("invalid destination %q: expected a path or an http(s) URL", to)
+	}
+	if redirect.Status == 200 && !strings.HasPrefix(to, "/") {
+		return redirect, fmt.Errorf("invalid destination %q: a rewrite with status 200 must point to a path", to)
+	}
+
+	placeholders, err := redirect.placeholders()
+	if err != nil {
+		return redirect, err
+	}
+	for _, match := range redirectPlaceholderPattern.FindAllStringSubmatch(to, -1) {
+		if !placeholders[match[1]] {
+			return redirect, fmt.Errorf("destination %q uses :%s, which is not set by the source %q", to, match[1], from)
+		}
+	}
+
+	return redirect, nil
+}
+
+// parseRedirectLine parses a line of a _redirects file, returning false for blank
+// lines and comments.
+func parseRedirectLine(line string) (Redirect, bool, error) {
+	if index := strings.Index(line, "#"); index >= 0 {
+		line = line[:index]
+	}
+
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 0:
+		return Redirect{}, false, nil
+	case len(fields) < 2:
+		return Redirect{}, true, fmt.Errorf("expected a source, a destination and an optional status")
+	case len(fields) > 3 || strings.Contains(fields[1], "="):
+		return Redirect{}, true, fmt.Errorf("query parameter, country, language and role conditions are not supported")
+	}
+
+	status := ""
+	if len(fields) == 3 {
+		status = fields[2]
+	}
+	redirect, err := parseRedirect(fields[0], fields[1], status)
+	return redirect, true, err
+}
+
+// ParseRedirects parses a file in the _redirects format, reporting every line it
+// cannot parse along with duplicate sources and loops.
+func ParseRedirects(data []byte) ([]Redirect, error) {
+	var redirects []Redirect
+	var problems []error
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		redirect, ok, err := parseRedirectLine(scanner.Text())
+		if err != nil {
+			problems = append(problems, &StaticfileError{Line: line, Message: err.Error()})
+			continue
+		}
+		if ok {
+			redirect.Line = line
+			redirects = append(redirects, redirect)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	problems = append(problems, checkRedirects(redirects)...)
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return redirects, nil
+}
+
+// checkRedirects reports sources that are redirected twice and redirects that
+// lead back to themselves. Loops are found by following each internal
+// destination through the other rules, with placeholders filled in.
+func checkRedirects(redirects []Redirect) []error {
+	var problems []error
+
+	seen := map[string]int{}
+	for _, redirect := range redirects {
+		if line, ok := seen[redirect.From]; ok {
+			problems = append(problems, &StaticfileError{Line: redirect.Line, Message: fmt.Sprintf("duplicate redirect source %q (first set on line %d)", redirect.From, line)})
+			continue
+		}
+		seen[redirect.From] = redirect.Line
+	}
+
+	patterns := make([]*regexp.Regexp, len(redirects))
+	for i, redirect := range redirects {
+		patterns[i] = regexp.MustCompile(redirect.sourceRegex())
+	}
+
+	// A rewrite with status 200 is applied once and is not followed by the
+	// server rewrites again, so only redirects can loop. Each loop is reported
+	// once, from its lowest numbered rule.
+	for i, redirect := range redirects {
+		if redirect.Status == 200 {
+			continue
+		}
+
+		path := []string{redirect.From}
+		current := i
+		for hops := 0; hops <= len(redirects); hops++ {
+			target := redirects[current].sample()
+			next := matchRedirect(patterns, target)
+			if next < 0 || next < i || redirects[next].Status == 200 {
+				break
+			}
+			path = append(path, target)
+			if next == i {
+				problems = append(problems, &StaticfileError{Line: redirect.Line, Message: fmt.Sprintf("redirect loop: %s", strings.Join(path, " -> "))})
+				break
+			}
+			current = next
+		}
+	}
+
+	return problems
+}
+
+// matchRedirect returns the first rule that matches path, as nginx checks them
+// in order, or -1 if there is none.
+func matchRedirect(patterns []*regexp.Regexp, path string) int {
+	if !strings.HasPrefix(path, "/") {
+		return -1
+	}
+	path, _, _ = strings.Cut(path, "?")
+
+	for i, pattern := range patterns {
+		if pattern.MatchString(path) {
+			return i
+		}
+	}
+	return -1
+}
+
+// placeholders returns the names the source sets: one per path segment that
+// starts with a colon, and splat for a trailing /*.
+func (r Redirect) placeholders() (map[string]bool, error) {
+	placeholders := map[string]bool{}
+	for _, segment := range strings.Split(r.From, "/") {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		name := segment[1:]
+		if redirectPlaceholderPattern.FindString(segment) != segment {
+			return nil, fmt.Errorf("invalid placeholder %q in source %q", segment, r.From)
+		}
+		if name == "splat" {
+			return nil, fmt.Errorf("placeholder :splat is set by a trailing /* in source %q", r.From)
+		}
+		if placeholders[name] {
+			return nil, fmt.Errorf("placeholder :%s is used more than once in source %q", name, r.From)
+		}
+		placeholders[name] = true
+	}
+	if strings.HasSuffix(r.From, "/*") {
+		placeholders["splat"] = true
+	}
+	return placeholders, nil
+}
+
+// sourceRegex converts the source into an anchored regular expression with a
+// named capture per placeholder. The captures are prefixed so that they cannot
+// clash with nginx variables such as $host.
+func (r Redirect) sourceRegex() string {
+	from, splat := strings.CutSuffix(r.From, "/*")
+
+	var segments []string
+	for _, segment := range strings.Split(from, "/") {
+		if strings.HasPrefix(segment, ":") {
+			segments = append(segments, fmt.Sprintf("(?<redirect_%s>[^/]+)", segment[1:]))
+		} else {
+			segments = append(segments, regexp.QuoteMeta(segment))
+		}
+	}
+
+	regex := "^" + strings.Join(segments, "/")
+	if splat {
+		regex += "/(?<redirect_splat>.*)"
+	}
+	return regex + "$"
+}
+
+// destination returns the destination as the inside of a double quoted nginx
+// string, with each placeholder replaced by its capture. Redirects keep the query
+// string, as rewrites already do.
+func (r Redirect) destination() string {
+	var value strings.Builder
+	last := 0
+	for _, match := range redirectPlaceholderPattern.FindAllStringSubmatchIndex(r.To, -1) {
+		value.WriteString(strings.Trim(quoteNginxString(r.To[last:match[0]]), `"`))
+		value.WriteString("${redirect_" + r.To[match[2]:match[3]] + "}")
+		last = match[1]
+	}
+	value.WriteString(strings.Trim(quoteNginxString(r.To[last:]), `"`))
+
+	if r.Status != 200 && !strings.Contains(r.To, "?") {
+		value.WriteString("$is_args$args")
+	}
+	return value.String()
+}
+
+// code is the status in the $redirect map. A forced rewrite is applied with the
+// redirects, before nginx looks for a file.
+func (r Redirect) code() string {
+	if r.Status == 200 && r.Force {
+		return "200!"
+	}
+	return strconv.Itoa(r.Status)
+}
+
+// sample returns the destination with every placeholder filled in, for following
+// redirects when looking for loops.
+func (r Redirect) sample() string {
+	return redirectPlaceholderPattern.ReplaceAllString(r.To, "placeholder")
+}
+
+// RedirectEntries returns the rules of the $redirect map, which nginx checks in
+// order. Exact sources are regular expressions too, as nginx would otherwise
+// check them before every pattern. The value holds the status and the
+// destination, which the $redirect_status and $redirect_to maps split.
+func (s Staticfile) RedirectEntries() []RedirectEntry {
+	var entries []RedirectEntry
+	for _, redirect := range s.Redirects {
+		entries = append(entries, RedirectEntry{
+			Key:   fmt.Sprintf(`"~%s"`, redirect.sourceRegex()),
+			Value: fmt.Sprintf(`"%s %s"`, redirect.code(), redirect.destination()),
+		})
+	}
+	return entries
+}
+
+// RedirectCodes returns the codes that the server applies before nginx looks for
+// a file, as return needs the status to be known when nginx starts. Rewrites
+// that are not forced are left to the @redirect location.
+func (s Staticfile) RedirectCodes() []string {
+	var codes []string
+	for _, redirect := range s.Redirects {
+		if code := redirect.code(); code != "200" && !slices.Contains(codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// HasRewrites reports whether a rewrite applies to paths that are not files.
+func (s Staticfile) HasRewrites() bool {
+	return slices.ContainsFunc(s.Redirects, func(redirect Redirect) bool {
+		return redirect.code() == "200"
+	})
+}
+
+// LoadRedirectsFile adds the rules from a _redirects file in the public directory
+// to those from the Staticfile, and removes the file so that it is not served.
+// Each source is checked for loops on its own first, so a loop in the merged
+// rules goes through both, and is reported from a rule in the Staticfile.
+func (sf *Finalizer) LoadRedirectsFile() error {
+	redirectsFile := filepath.Join(sf.BuildDir, "public", "_redirects")
+	data, err := os.ReadFile(redirectsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	sf.Log.BeginStep("Enabling redirects from _redirects")
+	redirects, err := ParseRedirects(data)
+	if err != nil {
+		return fmt.Errorf("invalid _redirects file:\n%w", err)
+	}
+
+	existing := map[string]bool{}
+	for _, redirect := range sf.Config.Redirects {
+		existing[redirect.From] = true
+	}
+	merged := slices.Clone(sf.Config.Redirects)
+	for _, redirect := range redirects {
+		if existing[redirect.From] {
+			sf.Log.Warning("Ignoring the redirect for %s on line %d of _redirects, as the Staticfile already redirects it.", redirect.From, redirect.Line)
+			continue
+		}
+		merged = append(merged, redirect)
+	}
+	if problems := checkRedirects(merged); len(problems) > 0 {
+		return fmt.Errorf("the redirects in the Staticfile and _redirects form a loop:\n%w", errors.Join(problems...))
+	}
+	sf.Log.Info("Loaded %d redirects", len(merged)-len(sf.Config.Redirects))
+	sf.Config.Redirects = merged
+
+	return os.Remove(redirectsFile)
+}
+
+func (sf *Finalizer) getRedirects(rules []interface{}) []Redirect {
+	var redirects []Redirect
+	for _, rule := range rules {
+		var redirect Redirect
+		var err error
+		switch rule := rule.(type) {
+		case map[interface{}]interface{}:
+			field := func(name string) string {
+				if value, ok := rule[name]; ok && value != nil {
+					return fmt.Sprint(value)
+				}
+				return ""
+			}
+			redirect, err = parseRedirect(field("from"), field("to"), field("status"))
+		default:
+			redirect, _, err = parseRedirectLine(fmt.Sprint(rule))
+		}
+		if err != nil {
+			sf.Log.Warning("Ignoring redirect: %s", err.Error())
+			continue
+		}
+		redirects = append(redirects, redirect)
+	}
+	return redirects
+}
@@ -0,0 +1,84 @@
+package finalize_test
+
+import (
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/finalize"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseRedirects", func() {
+	var (
+		err       error
+		redirects []finalize.Redirect
+		data      string
+	)
+
+	JustBeforeEach(func() {
+		redirects, err = finalize.ParseRedirects([]byte(data))
+	})
+
+	Context("the file has redirects, rewrites and comments", func() {
+		BeforeEach(func() {
+			data = `# Redirects from the old site
+/home              /                     301
+/blog/:year/:slug  /news/:year/:slug     302!
+/docs/*            https://docs.example.com/:splat
+
+/*                 /index.html           200
+`
+		})
+
+		It("parses every rule in order", func() {
+			Expect(err).To(BeNil())
+			Expect(redirects).To(Equal([]finalize.Redirect{
+				{From: "/home", To: "/", Status: 301, Line: 2},
+				{From: "/blog/:year/:slug", To: "/news/:year/:slug", Status: 302, Force: true, Line: 3},
+				{From: "/docs/*", To: "https://docs.example.com/:splat", Status: 301, Line: 4},
+				{From: "/*", To: "/index.html", Status: 200, Line: 6},
+			}))
+		})
+	})
+
+	Context("the file has lines that cannot be parsed", func() {
+		BeforeEach(func() {
+			data = `/lonely
+/store id=:id /blog/:id 301
+/old /new 404
+old /new
+/blog/:slug /news/:title
+/a /b
+/a /c
+`
+		})
+
+		It("reports every line", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("line 1: expected a source, a destination and an optional status\n" +
+				"line 2: query parameter, country, language and role conditions are not supported\n" +
+				`line 3: invalid status "404": expected one of 200, 301, 302, 303, 307, 308` + "\n" +
+				`line 4: invalid source "old": expected a path, optionally ending in /*` + "\n" +
+				`line 5: destination "/news/:title" uses :title, which is not set by the source "/blog/:slug"` + "\n" +
+				`line 7: duplicate redirect source "/a" (first set on line 6)`))
+		})
+	})
+
+	Context("the file has redirect loops", func() {
+		BeforeEach(func() {
+			data = `/a /b
+/b /c 302
+/c /a
+/self /self
+/blog/* /blog/:splat/
+/* /index.html 200
+`
+		})
+
+		It("reports each loop once", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("line 1: redirect loop: /a -> /b -> /c -> /a\n" +
+				"line 4: redirect loop: /self -> /self\n" +
+				"line 5: redirect loop: /blog/* -> /blog/placeholder/"))
+		})
+	})
+})
@@ -0,0 +1,194 @@
+package finalize
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/launch"
+)
+
+type RuntimeEnv struct {
+	Variables  []string
+	Path       string
+	Substitute []string
+	// Files are the files in public that FindRuntimeEnvFiles found.
+	Files []launch.RuntimeEnvFile
+}
+
+// RuntimeEnvLocation serves a file that the launcher renders into nginx/runtime,
+// with the settings of the location that would serve it from public.
+type RuntimeEnvLocation struct {
+	Path     string
+	Location Location
+}
+
+type RuntimeEnvTemp struct {
+	Variables  []string `yaml:"variables"`
+	Path       string   `yaml:"path"`
+	Substitute []string `yaml:"substitute"`
+}
+
+const defaultRuntimeEnvPath = "/env.js"
+
+var (
+	envVariablePattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	runtimeEnvPathPattern = regexp.MustCompile(`^(/[A-Za-z0-9_~@+,=-][A-Za-z0-9._~@+,=-]*)+\.(js|json)$`)
+	runtimeEnvFilePattern = regexp.MustCompile(`^/[A-Za-z0-9._~@+,=:/-]+$`)
+)
+
+var runtimeEnvEscapes = map[string]string{
+	".js":    "json",
+	".mjs":   "json",
+	".cjs":   "json",
+	".json":  "json",
+	".html":  "html",
+	".htm":   "html",
+	".shtml": "html",
+}
+
+func validEnvVariable(name string) bool {
+	return envVariablePattern.MatchString(name)
+}
+
+func validRuntimeEnvPath(path string) bool {
+	return runtimeEnvPathPattern.MatchString(path)
+}
+
+// FindRuntimeEnvFiles lists the files in public that match the substitute globs
+// and contain a ${VAR} placeholder for one of the variables. Only JavaScript, JSON
+// and HTML files are substituted, as values are escaped for those formats, and
+// only paths that an exact nginx location can name without quoting.
+func (sf *Finalizer) FindRuntimeEnvFiles() ([]launch.RuntimeEnvFile, error) {
+	runtimeEnv := sf.Config.RuntimeEnv
+	publicDir := filepath.Join(sf.BuildDir, "public")
+
+	var patterns []*regexp.Regexp
+	for _, glob := range runtimeEnv.Substitute {
+		patterns = append(patterns, regexp.MustCompile(globToRegex(glob)))
+	}
+
+	var placeholders [][]byte
+	for _, name := range runtimeEnv.Variables {
+		placeholders = append(placeholders, []byte("${"+name+"}"))
+	}
+
+	matched := make([]bool, len(patterns))
+	var files []launch.RuntimeEnvFile
+	err := filepath.WalkDir(publicDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(publicDir, path)
+		if err != nil {
+			return err
+		}
+		uri := "/" + filepath.ToSlash(rel)
+
+		matches := false
+		for i, pattern := range patterns {
+			if pattern.MatchString(uri) {
+				matches, matched[i] = true, true
+			}
+		}
+		if !matches {
+			return nil
+		}
+
+		escape, ok := runtimeEnvEscapes[strings.ToLower(filepath.Ext(path))]
+		if !ok {
+			sf.Log.Warning("Not substituting environment variables in %s, only JavaScript, JSON and HTML files are supported.", uri)
+			return nil
+		}
+		if !runtimeEnvFilePattern.MatchString(uri) {
+			sf.Log.Warning("Not substituting environment variables in %q, only paths of letters, digits and ._~@+,=:/- are supported.", uri)
+			return nil
+		}
+
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, placeholder := range placeholders {
+			if bytes.Contains(contents, placeholder) {
+				files = append(files, launch.RuntimeEnvFile{Path: filepath.ToSlash(rel), Escape: escape})
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, glob := range runtimeEnv.Substitute {
+		if !matched[i] {
+			sf.Log.Warning("The runtime_env substitute glob %s does not match any files.", glob)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// RuntimeEnvLocations returns an exact location for the rendered runtime_env file
+// and for each substituted file, as the launcher writes them to nginx/runtime and
+// leaves public untouched. Each one takes the settings of the path glob location
+// that matches its path, or of location /. Paths under a proxy are left to it.
+func (d nginxConfData) RuntimeEnvLocations() []RuntimeEnvLocation {
+	if d.RuntimeEnv == nil || d.LocationPrefix != "" {
+		return nil
+	}
+
+	paths := []string{d.RuntimeEnv.Path}
+	for _, file := range d.RuntimeEnv.Files {
+		paths = append(paths, "/"+file.Path)
+	}
+
+	var locations []RuntimeEnvLocation
+paths:
+	for _, path := range paths {
+		for _, proxy := range d.Proxies {
+			if strings.HasPrefix(path, proxy.Location()) {
+				continue paths
+			}
+		}
+		location := d.Root
+		for _, candidate := range d.Locations {
+			if regexp.MustCompile(globToRegex(candidate.Path)).MatchString(path) {
+				location = candidate
+				break
+			}
+		}
+		locations = append(locations, RuntimeEnvLocation{Path: path, Location: location})
+	}
+	return locations
+}
+
+func (sf *Finalizer) getRuntimeEnv(raw *RuntimeEnvTemp) *RuntimeEnv {
+	runtimeEnv := &RuntimeEnv{Path: raw.Path, Substitute: raw.Substitute}
+	if runtimeEnv.Path == "" {
+		runtimeEnv.Path = defaultRuntimeEnvPath
+	}
+
+	seen := map[string]bool{}
+	for _, name := range raw.Variables {
+		if !validEnvVariable(name) || seen[name] {
+			sf.Log.Warning("Ignoring runtime_env variable %q", name)
+			continue
+		}
+		seen[name] = true
+		runtimeEnv.Variables = append(runtimeEnv.Variables, name)
+	}
+	return runtimeEnv
+}
@@ -0,0 +1,185 @@
+package finalize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// SBOMFile is the CycloneDX SBOM of the droplet, relative to the build dir. It
+// is outside public, so nginx only serves it through the SBOM route.
+const SBOMFile = ".staticfile/sbom.cdx.json"
+
+// dependencyReport is where supply describes the nginx it installed, relative to
+// the dep dir.
+const dependencyReport = "staticfile/dependencies.json"
+
+type SBOMRoute struct {
+	Path string
+}
+
+const defaultSBOMPath = "/__sbom"
+
+// getSBOMRoute reads sbom_route.
+func (sf *Finalizer) getSBOMRoute(raw interface{}, isEnabled func(string) bool) (*SBOMRoute, error) {
+	fields, err := getRoute("sbom_route", raw, defaultSBOMPath, sbomRouteSchema, isEnabled)
+	if fields == nil {
+		return nil, err
+	}
+	return &SBOMRoute{Path: fields["path"]}, nil
+}
+
+type cycloneDX struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cycloneDXMetadata    `json:"metadata"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Tools struct {
+		Components []cycloneDXComponent `json:"components"`
+	} `json:"tools"`
+}
+
+type cycloneDXComponent struct {
+	Type               string              `json:"type"`
+	Name               string              `json:"name"`
+	Version            string              `json:"version,omitempty"`
+	PURL               string              `json:"purl,omitempty"`
+	Hashes             []cycloneDXHash     `json:"hashes,omitempty"`
+	ExternalReferences []cycloneDXExternal `json:"externalReferences,omitempty"`
+}
+
+type cycloneDXHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cycloneDXExternal struct {
+	Type   string          `json:"type"`
+	URL    string          `json:"url"`
+	Hashes []cycloneDXHash `json:"hashes,omitempty"`
+}
+
+// WriteSBOM writes a CycloneDX SBOM with the buildpack, the nginx that supply
+// installed and every file in public. It has no timestamp or serial number, so
+// that the same app stages to the same SBOM.
+func (sf *Finalizer) WriteSBOM() error {
+	bom := cycloneDX{BOMFormat: "CycloneDX", SpecVersion: "1.5", Version: 1}
+	bom.Metadata.Tools.Components = []cycloneDXComponent{{Type: "application", Name: "staticfile-buildpack", Version: sf.BuildpackVersion}}
+
+	nginx, err := sf.nginxComponents()
+	if err != nil {
+		return err
+	}
+	bom.Components = nginx
+
+	publicDir := filepath.Join(sf.BuildDir, "public")
+	err = filepath.WalkDir(publicDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		sum, err := sha256File(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(sf.BuildDir, path)
+		if err != nil {
+			return err
+		}
+		bom.Components = append(bom.Components, cycloneDXComponent{
+			Type:   "file",
+			Name:   filepath.ToSlash(name),
+			Hashes: []cycloneDXHash{{Alg: "SHA-256", Content: sum}},
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(bom, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(sf.BuildDir, filepath.FromSlash(SBOMFile))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	sf.Log.BeginStep("Writing a CycloneDX SBOM of %d files to %s", len(bom.Components)-len(nginx), SBOMFile)
+	return nil
+}
+
+// nginxComponents reads the dependency report that supply wrote. Without one,
+// for example when another buildpack supplied nginx, the SBOM lists only files.
+func (sf *Finalizer) nginxComponents() ([]cycloneDXComponent, error) {
+	data, err := os.ReadFile(filepath.Join(sf.DepDir, filepath.FromSlash(dependencyReport)))
+	if os.IsNotExist(err) {
+		sf.Log.Warning("There is no %s, so the SBOM does not list nginx", dependencyReport)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var report struct {
+		Dependencies []struct {
+			Name         string `json:"name"`
+			Version      string `json:"version"`
+			URI          string `json:"uri"`
+			SHA256       string `json:"sha256"`
+			Source       string `json:"source"`
+			SourceSHA256 string `json:"source_sha256"`
+		} `json:"dependencies"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", dependencyReport, err)
+	}
+
+	var components []cycloneDXComponent
+	for _, dep := range report.Dependencies {
+		component := cycloneDXComponent{
+			Type:    "application",
+			Name:    dep.Name,
+			Version: dep.Version,
+			PURL:    fmt.Sprintf("pkg:generic/%s@%s", dep.Name, dep.Version),
+			Hashes:  []cycloneDXHash{{Alg: "SHA-256", Content: dep.SHA256}},
+			ExternalReferences: []cycloneDXExternal{
+				{Type: "distribution", URL: dep.URI, Hashes: []cycloneDXHash{{Alg: "SHA-256", Content: dep.SHA256}}},
+			},
+		}
+		if dep.Source != "" {
+			source := cycloneDXExternal{Type: "source-distribution", URL: dep.Source}
+			if dep.SourceSHA256 != "" {
+				source.Hashes = []cycloneDXHash{{Alg: "SHA-256", Content: dep.SourceSHA256}}
+			}
+			component.ExternalReferences = append(component.ExternalReferences, source)
+		}
+		components = append(components, component)
+	}
+	return components, nil
+}
+
+func sha256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,31 @@
+package finalize
+
+import (
+	"fmt"
+	"strings"
+)
+
+type SSO struct {
+	Service     string
+	Groups      []string
+	GroupsClaim string
+	Scopes      []string
+}
+
+type SSOTemp struct {
+	Service     string   `yaml:"service"`
+	Groups      []string `yaml:"groups"`
+	GroupsClaim string   `yaml:"groups_claim"`
+	Scopes      []string `yaml:"scopes"`
+}
+
+func (s SSO) String() string {
+	source := "the SSO_ISSUER, SSO_CLIENT_ID and SSO_CLIENT_SECRET environment variables"
+	if s.Service != "" {
+		source = fmt.Sprintf("the %s service", s.Service)
+	}
+	if len(s.Groups) > 0 {
+		return fmt.Sprintf("%s, for members of %s", source, strings.Join(s.Groups, ", "))
+	}
+	return source
+}
@@ -0,0 +1,897 @@
+package finalize
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/detect"
+	yaml "go.yaml.in/yaml/v3"
+)
+
+type StaticfileError struct {
+	Line    int
+	Message string
+}
+
+func (e *StaticfileError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+type staticfileValidator func(key string, value *yaml.Node) []error
+
+var staticfileSchema = map[string]staticfileValidator{
+	"root":                           validateString,
+	"host_dot_files":                 validateBool,
+	"location_include":               validateString,
+	"directory":                      validateScalar,
+	"ssi":                            validateBool,
+	"pushstate":                      validateBool,
+	"http_strict_transport_security": validateBool,
+	"http_strict_transport_security_include_subdomains": validateBool,
+	"http_strict_transport_security_preload":            validateBool,
+	"force_https":                                       validateBool,
+	"enable_http2":                                      validateBool,
+	"status_codes":                                      validateStatusCodes,
+	"headers":                                           validateHeaders,
+	"content_security_policy":                           validateContentSecurityPolicy,
+	"cache_control":                                     validateCacheControl,
+	"fingerprinted_assets":                              validateBool,
+	"precompress":                                       validateBool,
+	"redirects":                                         validateRedirects,
+	"proxies":                                           validateProxies,
+	"runtime_env":                                       validateRuntimeEnv,
+	"basic_auth":                                        validateBasicAuth,
+	"auth":                                              validateAuthRules,
+	"sso":                                               validateSSO,
+	"log_format":                                        validateLogFormat,
+	"access_log":                                        validateAccessLog,
+	"error_log_level":                                   validateErrorLogLevel,
+	"error_log_instance_index":                          validateBool,
+	"health_check":                                      validateRoute(healthCheckSchema),
+	"metrics":                                           validateRoute(metricsSchema),
+	"sbom_route":                                        validateRoute(sbomRouteSchema),
+	"trusted_proxies":                                   validateAddresses,
+	"nginx_version":                                     validateString,
+}
+
+var contentSecurityPolicySchema = map[string]staticfileValidator{
+	"directives":  validateCSPDirectives,
+	"report_only": validateBool,
+	"report_uri":  validateCSPReportURI,
+}
+
+var proxySchema = map[string]staticfileValidator{
+	"url":             validateProxyURL,
+	"headers":         validateProxyHeaders,
+	"connect_timeout": validateDuration,
+	"timeout":         validateDuration,
+	"websocket":       validateBool,
+	"ssl_verify":      validateBool,
+}
+
+var runtimeEnvSchema = map[string]staticfileValidator{
+	"variables":  validateEnvVariables,
+	"path":       validateRuntimeEnvPath,
+	"substitute": validateSubstituteGlobs,
+}
+
+var basicAuthSchema = map[string]staticfileValidator{
+	"env":     validateEnvVariable,
+	"service": validateString,
+}
+
+var authRuleSchema = map[string]staticfileValidator{
+	"basic_auth": validateBool,
+	"allow":      validateAddresses,
+	"deny":       validateAddresses,
+}
+
+var accessLogSchema = map[string]staticfileValidator{
+	"exclude_paths":       validateSubstituteGlobs,
+	"exclude_status":      validateStatuses,
+	"exclude_user_agents": validateUserAgents,
+	"sample":              validateSample,
+}
+
+var healthCheckSchema = map[string]staticfileValidator{
+	"path":      validateExactPath,
+	"readiness": validateBool,
+}
+
+var metricsSchema = map[string]staticfileValidator{
+	"path":  validateExactPath,
+	"port":  validatePort,
+	"allow": validateAddresses,
+}
+
+var sbomRouteSchema = map[string]staticfileValidator{
+	"path": validateExactPath,
+}
+
+var ssoSchema = map[string]staticfileValidator{
+	"service":      validateString,
+	"groups":       validateGroups,
+	"groups_claim": validateString,
+	"scopes":       validateScopes,
+}
+
+var redirectSchema = map[string]staticfileValidator{
+	"from":   validateString,
+	"to":     validateString,
+	"status": validateScalar,
+}
+
+var boolValues = []string{"true", "false", "enabled", "disabled"}
+
+var statusCodePattern = regexp.MustCompile(`^([1-5][0-9][0-9]|4xx|5xx)$`)
+
+// exactPathPattern keeps the paths of the health check and the metrics usable as
+// exact locations without quoting, and out of the dotfile location.
+var exactPathPattern = regexp.MustCompile(`^/[A-Za-z0-9_~/-]*$`)
+
+// ValidateStaticfile checks the Staticfile, at the top of the app or in its root
+// directory, against the known keys and their allowed values, reporting every
+// problem it finds.
+func (sf *Finalizer) ValidateStaticfile() error {
+	staticfile, _, err := detect.FindStaticfile(sf.BuildDir, sf.Config.RootDir)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(staticfile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	_, err = os.Stat(filepath.Join(sf.BuildDir, "Staticfile.auth"))
+	return validateStaticfile(data, err == nil)
+}
+
+func validateStaticfile(data []byte, authFile bool) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := resolveNode(doc.Content[0])
+	if isNull(root) {
+		return nil
+	}
+	if root.Kind != yaml.MappingNode {
+		return &StaticfileError{Line: root.Line, Message: "the Staticfile must be a map of keys to values"}
+	}
+
+	problems := validateMapping("", root, staticfileSchema)
+	if len(problems) == 0 {
+		problems = checkMetricsAccess(root, authFile)
+	}
+	return errors.Join(problems...)
+}
+
+// checkMetricsAccess requires basic authentication or an allow list for metrics.
+// Routed requests reach nginx from the proxy in the container, so loopback is
+// not a boundary, and a separate port is meant to be scraped from other apps.
+func checkMetricsAccess(root *yaml.Node, authFile bool) []error {
+	var metrics *yaml.Node
+	basicAuth := authFile
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		switch root.Content[i].Value {
+		case "metrics":
+			metrics = resolveNode(root.Content[i+1])
+		case "basic_auth":
+			basicAuth = true
+		}
+	}
+	if metrics == nil || basicAuth || (metrics.Kind == yaml.ScalarNode && metrics.Value != "true" && metrics.Value != "enabled") {
+		return nil
+	}
+	for i := 0; metrics.Kind == yaml.MappingNode && i+1 < len(metrics.Content); i += 2 {
+		if metrics.Content[i].Value == "allow" {
+			return nil
+		}
+	}
+	return []error{&StaticfileError{Line: metrics.Line, Message: "metrics needs basic authentication or an allow list, add Staticfile.auth, basic_auth or metrics.allow"}}
+}
+
+func validateMapping(key string, value *yaml.Node, schema map[string]staticfileValidator) []error {
+	if value.Kind != yaml.MappingNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a map", key)}}
+	}
+
+	var problems []error
+	seen := map[string]int{}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		keyNode, valueNode := value.Content[i], resolveNode(value.Content[i+1])
+		field := keyNode.Value
+		if key != "" {
+			field = fmt.Sprintf("%s.%s", key, keyNode.Value)
+		}
+
+		if line, ok := seen[keyNode.Value]; ok {
+			problems = append(problems, &StaticfileError{Line: keyNode.Line, Message: fmt.Sprintf("duplicate key %q (first set on line %d)", field, line)})
+			continue
+		}
+		seen[keyNode.Value] = keyNode.Line
+
+		validate, ok := schema[keyNode.Value]
+		if !ok {
+			message := fmt.Sprintf("unknown key %q", field)
+			if suggestion := closestKey(keyNode.Value, schema); suggestion != "" {
+				message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+			}
+			problems = append(problems, &StaticfileError{Line: keyNode.Line, Message: message})
+			continue
+		}
+
+		if isNull(valueNode) {
+			continue
+		}
+		problems = append(problems, validate(field, valueNode)...)
+	}
+	return problems
+}
+
+func validateScalar(key string, value *yaml.Node) []error {
+	if value.Kind != yaml.ScalarNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a single value", key)}}
+	}
+	return nil
+}
+
+func validateString(key string, value *yaml.Node) []error {
+	if errs := validateScalar(key, value); errs != nil {
+		return errs
+	}
+	if strings.TrimSpace(value.Value) == "" {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a non-empty string", key)}}
+	}
+	return nil
+}
+
+func validateBool(key string, value *yaml.Node) []error {
+	if errs := validateScalar(key, value); errs != nil {
+		return errs
+	}
+	for _, allowed := range boolValues {
+		if value.Value == allowed {
+			return nil
+		}
+	}
+	return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value %q for %s: expected one of %s", value.Value, key, strings.Join(boolValues, ", "))}}
+}
+
+func validateLogFormat(key string, value *yaml.Node) []error {
+	if errs := validateScalar(key, value); errs != nil {
+		return errs
+	}
+	if !slices.Contains(logFormats, value.Value) {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value %q for %s: expected one of %s", value.Value, key, strings.Join(logFormats, ", "))}}
+	}
+	return nil
+}
+
+// validateRoute checks a setting that serves a route, such as health_check,
+// metrics and sbom_route. It is either a boolean or a map with the keys in
+// schema, one of which is the path.
+func validateRoute(schema map[string]staticfileValidator) staticfileValidator {
+	return func(key string, value *yaml.Node) []error {
+		switch value.Kind {
+		case yaml.ScalarNode:
+			return validateBool(key, value)
+		case yaml.MappingNode:
+			return validateMapping(key, value, schema)
+		}
+		keys := sortedKeys(schema)
+		if len(keys) > 1 {
+			keys = append(keys[:len(keys)-2], keys[len(keys)-2]+" and "+keys[len(keys)-1])
+		}
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected true, false or a map with %s", key, strings.Join(keys, ", "))}}
+	}
+}
+
+// getRoute reads a setting that validateRoute checks, as the YAML loader decoded
+// it. It returns nil if the setting is missing or disabled, and otherwise its
+// fields with the path defaulting to defaultPath. The values are checked with
+// the same schema, so that a bad one is an error rather than a zero value. The
+// items of a list, such as IP addresses, are joined with spaces.
+func getRoute(key string, raw interface{}, defaultPath string, schema map[string]staticfileValidator, isEnabled func(string) bool) (map[string]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	fields := map[string]string{"path": defaultPath}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(raw)}
+	if raw, ok := raw.(map[interface{}]interface{}); ok {
+		value = &yaml.Node{Kind: yaml.MappingNode}
+		for name, field := range raw {
+			if field == nil {
+				continue
+			}
+			node := &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(field)}
+			if items, ok := field.([]interface{}); ok {
+				node = &yaml.Node{Kind: yaml.SequenceNode}
+				var values []string
+				for _, item := range items {
+					node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(item)})
+					values = append(values, fmt.Sprint(item))
+				}
+				node.Value = strings.Join(values, " ")
+			}
+			fields[fmt.Sprint(name)] = node.Value
+			value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(name)}, node)
+		}
+	}
+	if problems := validateRoute(schema)(key, value); len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	if value.Kind == yaml.ScalarNode && !isEnabled(value.Value) {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+func sortedKeys(schema map[string]staticfileValidator) []string {
+	var keys []string
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validatePort rejects the privileged ports, which the app cannot listen on.
+func validatePort(key string, value *yaml.Node) []error {
+	if errs := validateScalar(key, value); errs != nil {
+		return errs
+	}
+	if port, err := strconv.Atoi(value.Value); err != nil || port < 1024 || port > 65535 {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value %q for %s: expected a port from 1024 to 65535", value.Value, key)}}
+	}
+	return nil
+}
+
+func validateExactPath(key string, value *yaml.Node) []error {
+	if errs := validateString(key, value); errs != nil {
+		return errs
+	}
+	if !exactPathPattern.MatchString(value.Value) {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value %q for %s: expected a path of letters, digits, -, _, ~ and /", value.Value, key)}}
+	}
+	return nil
+}
+
+func validateErrorLogLevel(key string, value *yaml.Node) []error {
+	if errs := validateScalar(key, value); errs != nil {
+		return errs
+	}
+	if !slices.Contains(errorLogLevels, value.Value) {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value %q for %s: expected one of %s", value.Value, key, strings.Join(errorLogLevels, ", "))}}
+	}
+	return nil
+}
+
+func validateAccessLog(key string, value *yaml.Node) []error {
+	return validateMapping(key, value, accessLogSchema)
+}
+
+func validateStatuses(key string, value *yaml.Node) []error {
+	return validateList(key, value, "status codes or classes such as 2xx", func(item *yaml.Node) string {
+		if !validStatus(item.Value) {
+			return fmt.Sprintf("invalid status %q in %s: expected a status code such as 404 or a class such as 2xx", item.Value, key)
+		}
+		return ""
+	})
+}
+
+func validateUserAgents(key string, value *yaml.Node) []error {
+	return validateList(key, value, "user agents", func(item *yaml.Node) string {
+		if strings.TrimSpace(item.Value) == "" || strings.ContainsAny(item.Value, "\r\n") {
+			return fmt.Sprintf("invalid user agent %q in %s", item.Value, key)
+		}
+		return ""
+	})
+}
+
+func validateSample(key string, value *yaml.Node) []error {
+	if errs := validateScalar(key, value); errs != nil {
+		return errs
+	}
+	if !validSample(value.Value) {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value %q for %s: expected a percentage above 0%% and below 100%%, such as 10%% or 0.5%%", value.Value, key)}}
+	}
+	return nil
+}
+
+func validateStatusCodes(key string, value *yaml.Node) []error {
+	if value.Kind != yaml.MappingNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a map of status codes to pages", key)}}
+	}
+
+	var problems []error
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		codeNode, pageNode := value.Content[i], resolveNode(value.Content[i+1])
+
+		if strings.TrimSpace(codeNode.Value) == "" {
+			problems = append(problems, &StaticfileError{Line: codeNode.Line, Message: fmt.Sprintf("missing status code in %s", key)})
+		}
+		for _, code := range strings.Fields(codeNode.Value) {
+			if !statusCodePattern.MatchString(code) {
+				problems = append(problems, &StaticfileError{Line: codeNode.Line, Message: fmt.Sprintf("invalid status code %q in %s: expected a code between 100 and 599, 4xx or 5xx", code, key)})
+			}
+		}
+
+		problems = append(problems, validateString(fmt.Sprintf("%s.%s", key, codeNode.Value), pageNode)...)
+	}
+	return problems
+}
+
+func validateHeaders(key string, value *yaml.Node) []error {
+	if value.Kind != yaml.MappingNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a map of header names or path globs", key)}}
+	}
+
+	var problems []error
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		nameNode, headerNode := value.Content[i], resolveNode(value.Content[i+1])
+
+		if !strings.HasPrefix(nameNode.Value, "/") {
+			problems = append(problems, validateHeader(key, nameNode, headerNode)...)
+			continue
+		}
+
+		if !validPathGlob(nameNode.Value) {
+			problems = append(problems, &StaticfileError{Line: nameNode.Line, Message: fmt.Sprintf("invalid path glob %q in %s", nameNode.Value, key)})
+		}
+		if headerNode.Kind != yaml.MappingNode {
+			problems = append(problems, &StaticfileError{Line: headerNode.Line, Message: fmt.Sprintf("invalid value for %s.%s: expected a map of header names to values", key, nameNode.Value)})
+			continue
+		}
+		for j := 0; j+1 < len(headerNode.Content); j += 2 {
+			problems = append(problems, validateHeader(fmt.Sprintf("%s.%s", key, nameNode.Value), headerNode.Content[j], resolveNode(headerNode.Content[j+1]))...)
+		}
+	}
+	return problems
+}
+
+func validateHeader(key string, name, value *yaml.Node) []error {
+	if !validHeaderName(name.Value) {
+		return []error{&StaticfileError{Line: name.Line, Message: fmt.Sprintf("invalid header name %q in %s", name.Value, key)}}
+	}
+
+	field := fmt.Sprintf("%s.%s", key, name.Value)
+	if errs := validateString(field, value); errs != nil {
+		return errs
+	}
+	if !validHeaderValue(value.Value) {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: header values cannot contain control characters", field)}}
+	}
+	return nil
+}
+
+func validateContentSecurityPolicy(key string, value *yaml.Node) []error {
+	return validateMapping(key, value, contentSecurityPolicySchema)
+}
+
+func validateCSPDirectives(key string, value *yaml.Node) []error {
+	if value.Kind != yaml.MappingNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a map of directives to sources", key)}}
+	}
+
+	var problems []error
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		nameNode, sourcesNode := value.Content[i], resolveNode(value.Content[i+1])
+		directive := nameNode.Value
+
+		if _, ok := cspDirectives[directive]; !ok {
+			message := fmt.Sprintf("unknown directive %q in %s", directive, key)
+			if suggestion := closestKey(directive, cspDirectives); suggestion != "" {
+				message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+			}
+			problems = append(problems, &StaticfileError{Line: nameNode.Line, Message: message})
+			continue
+		}
+
+		var sources []string
+		switch {
+		case isNull(sourcesNode):
+		case sourcesNode.Kind == yaml.ScalarNode:
+			sources = strings.Fields(sourcesNode.Value)
+		case sourcesNode.Kind == yaml.SequenceNode:
+			for _, item := range sourcesNode.Content {
+				item = resolveNode(item)
+				if item.Kind != yaml.ScalarNode {
+					problems = append(problems, &StaticfileError{Line: item.Line, Message: fmt.Sprintf("invalid value for %s.%s: expected a list of sources", key, directive)})
+					continue
+				}
+				sources = append(sources, item.Value)
+			}
+		default:
+			problems = append(problems, &StaticfileError{Line: sourcesNode.Line, Message: fmt.Sprintf("invalid value for %s.%s: expected a list of sources", key, directive)})
+			continue
+		}
+
+		if _, err := normalizeCSPValues(directive, sources); err != nil {
+			problems = append(problems, &StaticfileError{Line: sourcesNode.Line, Message: fmt.Sprintf("invalid %s: %s", key, err.Error())})
+		}
+	}
+	return problems
+}
+
+func validateCSPReportURI(key string, value *yaml.Node) []error {
+	if errs := validateString(key, value); errs != nil {
+		return errs
+	}
+	if !validCSPReportURI(value.Value) {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value %q for %s: expected a URI", value.Value, key)}}
+	}
+	return nil
+}
+
+func validateCacheControl(key string, value *yaml.Node) []error {
+	if value.Kind != yaml.SequenceNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a list of path globs to policies", key)}}
+	}
+
+	var problems []error
+	seen := map[string]int{}
+	for _, rule := range value.Content {
+		rule = resolveNode(rule)
+		if rule.Kind != yaml.MappingNode || len(rule.Content) != 2 {
+			problems = append(problems, &StaticfileError{Line: rule.Line, Message: fmt.Sprintf("invalid rule in %s: expected a single path glob and policy, such as \"/assets/**: public, max-age=86400\"", key)})
+			continue
+		}
+
+		globNode, policyNode := rule.Content[0], resolveNode(rule.Content[1])
+		if !validPathGlob(globNode.Value) {
+			problems = append(problems, &StaticfileError{Line: globNode.Line, Message: fmt.Sprintf("invalid path glob %q in %s", globNode.Value, key)})
+			continue
+		}
+		if line, ok := seen[globNode.Value]; ok {
+			problems = append(problems, &StaticfileError{Line: globNode.Line, Message: fmt.Sprintf("duplicate path glob %q in %s (first set on line %d)", globNode.Value, key, line)})
+			continue
+		}
+		seen[globNode.Value] = globNode.Line
+
+		field := fmt.Sprintf("%s.%s", key, globNode.Value)
+		if errs := validateString(field, policyNode); errs != nil {
+			problems = append(problems, errs...)
+			continue
+		}
+		if err := validCachePolicy(policyNode.Value); err != nil {
+			problems = append(problems, &StaticfileError{Line: policyNode.Line, Message: fmt.Sprintf("invalid policy for %s: %s", field, err.Error())})
+		}
+	}
+	return problems
+}
+
+func validateRedirects(key string, value *yaml.Node) []error {
+	if value.Kind != yaml.SequenceNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a list of redirects", key)}}
+	}
+
+	var problems []error
+	var redirects []Redirect
+	for _, rule := range value.Content {
+		rule = resolveNode(rule)
+
+		var redirect Redirect
+		var err error
+		switch rule.Kind {
+		case yaml.ScalarNode:
+			redirect, _, err = parseRedirectLine(rule.Value)
+		case yaml.MappingNode:
+			if errs := validateMapping(key, rule, redirectSchema); errs != nil {
+				problems = append(problems, errs...)
+				continue
+			}
+			fields := map[string]string{}
+			for i := 0; i+1 < len(rule.Content); i += 2 {
+				fields[rule.Content[i].Value] = resolveNode(rule.Content[i+1]).Value
+			}
+			if fields["from"] == "" || fields["to"] == "" {
+				err = fmt.Errorf("expected both from and to")
+				break
+			}
+			redirect, err = parseRedirect(fields["from"], fields["to"], fields["status"])
+		default:
+			err = fmt.Errorf("expected \"/from /to 301\" or a map with from, to and status")
+		}
+		if err != nil {
+			problems = append(problems, &StaticfileError{Line: rule.Line, Message: fmt.Sprintf("invalid redirect in %s: %s", key, err.Error())})
+			continue
+		}
+
+		redirect.Line = rule.Line
+		redirects = append(redirects, redirect)
+	}
+	return append(problems, checkRedirects(redirects)...)
+}
+
+func validateProxies(key string, value *yaml.Node) []error {
+	if value.Kind != yaml.MappingNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a map of path prefixes to upstreams", key)}}
+	}
+
+	var problems []error
+	seen := map[string]int{}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		prefixNode, proxyNode := value.Content[i], resolveNode(value.Content[i+1])
+		if !proxyPrefixPattern.MatchString(prefixNode.Value) {
+			problems = append(problems, &StaticfileError{Line: prefixNode.Line, Message: fmt.Sprintf("invalid path prefix %q in %s", prefixNode.Value, key)})
+			continue
+		}
+
+		location := withTrailingSlash(prefixNode.Value)
+		if line, ok := seen[location]; ok {
+			problems = append(problems, &StaticfileError{Line: prefixNode.Line, Message: fmt.Sprintf("duplicate path prefix %q in %s (first set on line %d)", prefixNode.Value, key, line)})
+			continue
+		}
+		seen[location] = prefixNode.Line
+
+		field := fmt.Sprintf("%s.%s", key, prefixNode.Value)
+		if proxyNode.Kind != yaml.MappingNode {
+			problems = append(problems, &StaticfileError{Line: proxyNode.Line, Message: fmt.Sprintf("invalid value for %s: expected a map with a url", field)})
+			continue
+		}
+		problems = append(problems, validateMapping(field, proxyNode, proxySchema)...)
+
+		hasURL := false
+		for j := 0; j+1 < len(proxyNode.Content); j += 2 {
+			hasURL = hasURL || proxyNode.Content[j].Value == "url"
+		}
+		if !hasURL {
+			problems = append(problems, &StaticfileError{Line: proxyNode.Line, Message: fmt.Sprintf("missing url for %s", field)})
+		}
+	}
+	return problems
+}
+
+func validateProxyURL(key string, value *yaml.Node) []error {
+	if errs := validateString(key, value); errs != nil {
+		return errs
+	}
+	if _, _, err := parseProxyURL(value.Value); err != nil {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value %q for %s: %s", value.Value, key, err.Error())}}
+	}
+	return nil
+}
+
+func validateProxyHeaders(key string, value *yaml.Node) []error {
+	if value.Kind != yaml.MappingNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a map of header names to values", key)}}
+	}
+
+	var problems []error
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		problems = append(problems, validateHeader(key, value.Content[i], resolveNode(value.Content[i+1]))...)
+	}
+	return problems
+}
+
+func validateBasicAuth(key string, value *yaml.Node) []error {
+	return validateMapping(key, value, basicAuthSchema)
+}
+
+func validateAuthRules(key string, value *yaml.Node) []error {
+	if value.Kind != yaml.MappingNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a map of path globs to rules", key)}}
+	}
+
+	var problems []error
+	seen := map[string]int{}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		globNode, ruleNode := value.Content[i], resolveNode(value.Content[i+1])
+		if !validPathGlob(globNode.Value) {
+			problems = append(problems, &StaticfileError{Line: globNode.Line, Message: fmt.Sprintf("invalid path glob %q in %s", globNode.Value, key)})
+			continue
+		}
+		if line, ok := seen[globNode.Value]; ok {
+			problems = append(problems, &StaticfileError{Line: globNode.Line, Message: fmt.Sprintf("duplicate path glob %q in %s (first set on line %d)", globNode.Value, key, line)})
+			continue
+		}
+		seen[globNode.Value] = globNode.Line
+
+		field := fmt.Sprintf("%s.%s", key, globNode.Value)
+		if ruleNode.Kind != yaml.MappingNode || len(ruleNode.Content) == 0 {
+			problems = append(problems, &StaticfileError{Line: ruleNode.Line, Message: fmt.Sprintf("invalid value for %s: expected a map with basic_auth, allow or deny", field)})
+			continue
+		}
+		problems = append(problems, validateMapping(field, ruleNode, authRuleSchema)...)
+
+		// geo keeps only one value per network, so an address cannot be
+		// both allowed and denied.
+		addresses := map[string]string{}
+		for j := 0; j+1 < len(ruleNode.Content); j += 2 {
+			list, items := ruleNode.Content[j].Value, resolveNode(ruleNode.Content[j+1])
+			if (list != "allow" && list != "deny") || items.Kind != yaml.SequenceNode {
+				continue
+			}
+			for _, item := range items.Content {
+				item = resolveNode(item)
+				if other, ok := addresses[item.Value]; ok && other != list {
+					problems = append(problems, &StaticfileError{Line: item.Line, Message: fmt.Sprintf("%s is both allowed and denied in %s", item.Value, field)})
+				}
+				addresses[item.Value] = list
+			}
+		}
+	}
+	return problems
+}
+
+func validateAddresses(key string, value *yaml.Node) []error {
+	return validateList(key, value, "IP addresses or CIDR ranges", func(item *yaml.Node) string {
+		if !validAddress(item.Value) {
+			return fmt.Sprintf("invalid IP address or CIDR range %q in %s", item.Value, key)
+		}
+		return ""
+	})
+}
+
+func validateSSO(key string, value *yaml.Node) []error {
+	return validateMapping(key, value, ssoSchema)
+}
+
+func validateGroups(key string, value *yaml.Node) []error {
+	return validateList(key, value, "group names", func(item *yaml.Node) string {
+		if item.Value == "" {
+			return fmt.Sprintf("empty group name in %s", key)
+		}
+		return ""
+	})
+}
+
+func validateScopes(key string, value *yaml.Node) []error {
+	problems := validateList(key, value, "scopes", func(item *yaml.Node) string {
+		if item.Value == "" || strings.ContainsAny(item.Value, " \t\"\\") {
+			return fmt.Sprintf("invalid scope %q in %s", item.Value, key)
+		}
+		return ""
+	})
+	if problems == nil && !slices.ContainsFunc(value.Content, func(item *yaml.Node) bool { return resolveNode(item).Value == "openid" }) {
+		problems = append(problems, &StaticfileError{Line: value.Line, Message: fmt.Sprintf("%s must include openid, or the issuer does not return an ID token", key)})
+	}
+	return problems
+}
+
+func validateDuration(key string, value *yaml.Node) []error {
+	if errs := validateScalar(key, value); errs != nil {
+		return errs
+	}
+	if !validDuration(value.Value) {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value %q for %s: expected a duration such as 500ms, 30s or 5m", value.Value, key)}}
+	}
+	return nil
+}
+
+func validateRuntimeEnv(key string, value *yaml.Node) []error {
+	return validateMapping(key, value, runtimeEnvSchema)
+}
+
+func validateEnvVariables(key string, value *yaml.Node) []error {
+	return validateList(key, value, "environment variable names", func(item *yaml.Node) string {
+		if !validEnvVariable(item.Value) {
+			return fmt.Sprintf("invalid environment variable name %q in %s", item.Value, key)
+		}
+		return ""
+	})
+}
+
+func validateEnvVariable(key string, value *yaml.Node) []error {
+	if errs := validateString(key, value); errs != nil {
+		return errs
+	}
+	if !validEnvVariable(value.Value) {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid environment variable name %q for %s", value.Value, key)}}
+	}
+	return nil
+}
+
+func validateRuntimeEnvPath(key string, value *yaml.Node) []error {
+	if errs := validateString(key, value); errs != nil {
+		return errs
+	}
+	if !validRuntimeEnvPath(value.Value) {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value %q for %s: expected a path ending in .js or .json, such as /env.js", value.Value, key)}}
+	}
+	return nil
+}
+
+func validateSubstituteGlobs(key string, value *yaml.Node) []error {
+	return validateList(key, value, "path globs", func(item *yaml.Node) string {
+		if !validPathGlob(item.Value) {
+			return fmt.Sprintf("invalid path glob %q in %s", item.Value, key)
+		}
+		return ""
+	})
+}
+
+// validateList checks that value is a list of unique strings that each pass
+// check, which returns a message for an invalid item.
+func validateList(key string, value *yaml.Node, items string, check func(*yaml.Node) string) []error {
+	if value.Kind != yaml.SequenceNode {
+		return []error{&StaticfileError{Line: value.Line, Message: fmt.Sprintf("invalid value for %s: expected a list of %s", key, items)}}
+	}
+
+	var problems []error
+	seen := map[string]int{}
+	for _, item := range value.Content {
+		item = resolveNode(item)
+		if errs := validateString(key, item); errs != nil {
+			problems = append(problems, errs...)
+			continue
+		}
+		if message := check(item); message != "" {
+			problems = append(problems, &StaticfileError{Line: item.Line, Message: message})
+			continue
+		}
+		if line, ok := seen[item.Value]; ok {
+			problems = append(problems, &StaticfileError{Line: item.Line, Message: fmt.Sprintf("duplicate value %q in %s (first set on line %d)", item.Value, key, line)})
+			continue
+		}
+		seen[item.Value] = item.Line
+	}
+	return problems
+}
+
+func resolveNode(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null"
+}
+
+func closestKey[V any](key string, schema map[string]V) string {
+	var keys []string
+	for known := range schema {
+		keys = append(keys, known)
+	}
+	sort.Strings(keys)
+
+	best, bestDistance := "", max(2, len(key)/3)+1
+	for _, known := range keys {
+		if distance := editDistance(strings.ToLower(key), known); distance < bestDistance {
+			best, bestDistance = known, distance
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
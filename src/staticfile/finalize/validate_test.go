@@ -0,0 +1,718 @@
+package finalize_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/finalize"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidateStaticfile", func() {
+	var (
+		err        error
+		buildDir   string
+		finalizer  *finalize.Finalizer
+		staticfile string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "staticfile-buildpack.build.")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, buildDir)
+
+		finalizer = &finalize.Finalizer{
+			BuildDir: buildDir,
+			Log:      libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer))),
+		}
+	})
+
+	JustBeforeEach(func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "Staticfile"), []byte(staticfile), 0644)).To(Succeed())
+		err = finalizer.ValidateStaticfile()
+	})
+
+	Context("the Staticfile does not exist", func() {
+		JustBeforeEach(func() {
+			Expect(os.Remove(filepath.Join(buildDir, "Staticfile"))).To(Succeed())
+			err = finalizer.ValidateStaticfile()
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile is empty", func() {
+		BeforeEach(func() {
+			staticfile = ""
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile sets every known key", func() {
+		BeforeEach(func() {
+			staticfile = `root: public
+host_dot_files: true
+location_include: includes/*.conf
+directory: visible
+ssi: enabled
+pushstate: enabled
+http_strict_transport_security: true
+http_strict_transport_security_include_subdomains: true
+http_strict_transport_security_preload: false
+force_https: disabled
+enable_http2: true
+log_format: json
+error_log_level: warn
+error_log_instance_index: true
+health_check: true
+metrics:
+  allow: [10.255.0.0/16]
+sbom_route: true
+nginx_version: mainline
+precompress: true
+status_codes:
+  404: /404.html
+  5xx: /5xx.html
+  "502 503": /maintenance.html
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has an unknown key", func() {
+		BeforeEach(func() {
+			staticfile = "root: public\npushsate: enabled\n"
+		})
+
+		It("reports the key, the line and the closest valid key", func() {
+			Expect(err).To(MatchError(`line 2: unknown key "pushsate" (did you mean "pushstate"?)`))
+		})
+	})
+
+	Context("the Staticfile has an unknown key with no close match", func() {
+		BeforeEach(func() {
+			staticfile = "something_else: true\n"
+		})
+
+		It("does not suggest a key", func() {
+			Expect(err).To(MatchError(`line 1: unknown key "something_else"`))
+		})
+	})
+
+	Context("the Staticfile has an invalid boolean value", func() {
+		BeforeEach(func() {
+			staticfile = "root: public\n\nforce_https: yes\n"
+		})
+
+		It("reports the value, the key and the line", func() {
+			Expect(err).To(MatchError(`line 3: invalid value "yes" for force_https: expected one of true, false, enabled, disabled`))
+		})
+	})
+
+	Context("the Staticfile has an unknown log_format", func() {
+		BeforeEach(func() {
+			staticfile = "log_format: logfmt\n"
+		})
+
+		It("lists the formats", func() {
+			Expect(err).To(MatchError(`line 1: invalid value "logfmt" for log_format: expected one of plain, json`))
+		})
+	})
+
+	Context("the Staticfile has an unknown error_log_level", func() {
+		BeforeEach(func() {
+			staticfile = "error_log_level: verbose\n"
+		})
+
+		It("lists the levels", func() {
+			Expect(err).To(MatchError(`line 1: invalid value "verbose" for error_log_level: expected one of debug, info, notice, warn, error, crit, alert, emerg`))
+		})
+	})
+
+	Context("the Staticfile has access_log filters", func() {
+		BeforeEach(func() {
+			staticfile = `access_log:
+  exclude_paths: [/healthz, /assets/**]
+  exclude_status: [2xx, 304]
+  exclude_user_agents: [kube-probe]
+  sample: 0.5%
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has invalid access_log filters", func() {
+		BeforeEach(func() {
+			staticfile = `access_log:
+  exclude_paths: [healthz]
+  exclude_status: [2XX, 600]
+  exclude_user_agents: kube-probe
+  sample: 100%
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 2: invalid path glob "healthz" in access_log.exclude_paths` + "\n" +
+				`line 3: invalid status "2XX" in access_log.exclude_status: expected a status code such as 404 or a class such as 2xx` + "\n" +
+				`line 3: invalid status "600" in access_log.exclude_status: expected a status code such as 404 or a class such as 2xx` + "\n" +
+				"line 4: invalid value for access_log.exclude_user_agents: expected a list of user agents\n" +
+				`line 5: invalid value "100%" for access_log.sample: expected a percentage above 0% and below 100%, such as 10% or 0.5%`))
+		})
+	})
+
+	Context("the Staticfile sets a key twice", func() {
+		BeforeEach(func() {
+			staticfile = "ssi: enabled\nssi: disabled\n"
+		})
+
+		It("reports the duplicate", func() {
+			Expect(err).To(MatchError(`line 2: duplicate key "ssi" (first set on line 1)`))
+		})
+	})
+
+	Context("the Staticfile has a list where a single value is expected", func() {
+		BeforeEach(func() {
+			staticfile = "root:\n  - public\n  - dist\n"
+		})
+
+		It("reports the key and the line", func() {
+			Expect(err).To(MatchError("line 2: invalid value for root: expected a single value"))
+		})
+	})
+
+	Context("the Staticfile has invalid status_codes", func() {
+		BeforeEach(func() {
+			staticfile = "status_codes:\n  404: /404.html\n  6xx: /6xx.html\n  500:\n"
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("line 3: invalid status code \"6xx\" in status_codes: expected a code between 100 and 599, 4xx or 5xx\n" +
+				"line 4: invalid value for status_codes.500: expected a non-empty string"))
+		})
+	})
+
+	Context("status_codes is not a map", func() {
+		BeforeEach(func() {
+			staticfile = "status_codes: /error.html\n"
+		})
+
+		It("reports the key and the line", func() {
+			Expect(err).To(MatchError("line 1: invalid value for status_codes: expected a map of status codes to pages"))
+		})
+	})
+
+	Context("the Staticfile has headers", func() {
+		BeforeEach(func() {
+			staticfile = `headers:
+  X-Frame-Options: DENY
+  Content-Security-Policy: "default-src 'self'"
+  /assets/**/*.js:
+    Cache-Control: public, max-age=3600
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has invalid headers", func() {
+		BeforeEach(func() {
+			staticfile = `headers:
+  X Frame Options: DENY
+  X-Multiline: |
+    one
+    two
+  /assets/{a,b}:
+    Cache-Control: public
+  /fonts/*:
+    - Cache-Control
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 2: invalid header name "X Frame Options" in headers` + "\n" +
+				"line 3: invalid value for headers.X-Multiline: header values cannot contain control characters\n" +
+				`line 6: invalid path glob "/assets/{a,b}" in headers` + "\n" +
+				"line 9: invalid value for headers./fonts/*: expected a map of header names to values"))
+		})
+	})
+
+	Context("the Staticfile has a content_security_policy", func() {
+		BeforeEach(func() {
+			staticfile = `content_security_policy:
+  report_only: true
+  report_uri: https://example.com/csp
+  directives:
+    default-src: self
+    script-src: ["'self'", "'nonce-abc123'", "https://*.example.com:443/js/"]
+    img-src: [data:, blob:]
+    frame-ancestors: "'none'"
+    sandbox: allow-forms allow-scripts
+    upgrade-insecure-requests:
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has an invalid content_security_policy", func() {
+		BeforeEach(func() {
+			staticfile = `content_security_policy:
+  report_onyl: true
+  report_uri: "/csp; script-src *"
+  directives:
+    scirpt-src: self
+    default-src: ["'self'", "'none'"]
+    img-src: "https://example.com;"
+    upgrade-insecure-requests: self
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 2: unknown key "content_security_policy.report_onyl" (did you mean "report_only"?)` + "\n" +
+				`line 3: invalid value "/csp; script-src *" for content_security_policy.report_uri: expected a URI` + "\n" +
+				`line 5: unknown directive "scirpt-src" in content_security_policy.directives (did you mean "script-src"?)` + "\n" +
+				`line 6: invalid content_security_policy.directives: 'none' cannot be combined with other sources in directive default-src` + "\n" +
+				`line 7: invalid content_security_policy.directives: invalid source expression "https://example.com;" for directive img-src` + "\n" +
+				`line 8: invalid content_security_policy.directives: directive upgrade-insecure-requests does not take any values`))
+		})
+	})
+
+	Context("the Staticfile has cache_control rules", func() {
+		BeforeEach(func() {
+			staticfile = `fingerprinted_assets: true
+cache_control:
+  - /assets/**: public, max-age=86400, stale-while-revalidate=60
+  - /*.html: no-cache
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has invalid cache_control rules", func() {
+		BeforeEach(func() {
+			staticfile = `cache_control:
+  - /assets/**: public, max-age=forever
+  - /assets/**: no-cache
+  - assets/*: no-cache
+  - /api/*: cache-everything
+  - /a: public
+    /b: private
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("line 2: invalid policy for cache_control./assets/**: directive max-age requires a number of seconds\n" +
+				`line 3: duplicate path glob "/assets/**" in cache_control (first set on line 2)` + "\n" +
+				`line 4: invalid path glob "assets/*" in cache_control` + "\n" +
+				`line 5: invalid policy for cache_control./api/*: unknown directive "cache-everything"` + "\n" +
+				`line 6: invalid rule in cache_control: expected a single path glob and policy, such as "/assets/**: public, max-age=86400"`))
+		})
+	})
+
+	Context("the Staticfile has redirects", func() {
+		BeforeEach(func() {
+			staticfile = `redirects:
+  - /old /new
+  - /news/:year/* /blog/:year/:splat 302
+  - from: /app/*
+    to: /index.html
+    status: 200
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has invalid redirects", func() {
+		BeforeEach(func() {
+			staticfile = `redirects:
+  - /old /new 418
+  - from: /a
+  - from: /b
+    to: /a
+    code: 301
+  - /a /b
+  - /b /a
+  - [/c, /d]
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 2: invalid redirect in redirects: invalid status "418": expected one of 200, 301, 302, 303, 307, 308` + "\n" +
+				"line 3: invalid redirect in redirects: expected both from and to\n" +
+				`line 6: unknown key "redirects.code"` + "\n" +
+				`line 9: invalid redirect in redirects: expected "/from /to 301" or a map with from, to and status` + "\n" +
+				"line 7: redirect loop: /a -> /b -> /a"))
+		})
+	})
+
+	Context("the Staticfile has proxies", func() {
+		BeforeEach(func() {
+			staticfile = `proxies:
+  /api:
+    url: https://api.example.com:8443/v1
+    headers:
+      X-Api-Key: secret
+    connect_timeout: 500ms
+    timeout: 5m
+    ssl_verify: true
+  /chat/:
+    url: ${CHAT_URL}
+    websocket: enabled
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has invalid proxies", func() {
+		BeforeEach(func() {
+			staticfile = `proxies:
+  /api:
+    url: ftp://files.example.com
+    timeout: forever
+  /api/:
+    url: https://api.example.com
+  api:
+    url: https://api.example.com
+  /chat:
+    websockets: true
+  /docs: https://docs.example.com
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 3: invalid value "ftp://files.example.com" for proxies./api.url: expected an http(s) URL without a query, or an environment variable such as ${API_URL}` + "\n" +
+				`line 4: invalid value "forever" for proxies./api.timeout: expected a duration such as 500ms, 30s or 5m` + "\n" +
+				`line 5: duplicate path prefix "/api/" in proxies (first set on line 2)` + "\n" +
+				`line 7: invalid path prefix "api" in proxies` + "\n" +
+				`line 10: unknown key "proxies./chat.websockets" (did you mean "websocket"?)` + "\n" +
+				"line 10: missing url for proxies./chat\n" +
+				"line 11: invalid value for proxies./docs: expected a map with a url"))
+		})
+	})
+
+	Context("the Staticfile has runtime_env", func() {
+		BeforeEach(func() {
+			staticfile = `runtime_env:
+  variables: [API_URL, SENTRY_DSN]
+  path: /config/env.json
+  substitute:
+    - /index.html
+    - /assets/**/*.js
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has an invalid runtime_env", func() {
+		BeforeEach(func() {
+			staticfile = `runtime_env:
+  variables: [API_URL, API-KEY, API_URL]
+  path: /env.txt
+  substitute: /index.html
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 2: invalid environment variable name "API-KEY" in runtime_env.variables` + "\n" +
+				`line 2: duplicate value "API_URL" in runtime_env.variables (first set on line 2)` + "\n" +
+				`line 3: invalid value "/env.txt" for runtime_env.path: expected a path ending in .js or .json, such as /env.js` + "\n" +
+				"line 4: invalid value for runtime_env.substitute: expected a list of path globs"))
+		})
+	})
+
+	Context("the Staticfile has basic_auth", func() {
+		BeforeEach(func() {
+			staticfile = `basic_auth:
+  env: SITE_USERS
+  service: site-users
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has an invalid basic_auth", func() {
+		BeforeEach(func() {
+			staticfile = `basic_auth:
+  env: SITE-USERS
+  service: [site-users]
+  users: bob
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 2: invalid environment variable name "SITE-USERS" for basic_auth.env` + "\n" +
+				"line 3: invalid value for basic_auth.service: expected a single value\n" +
+				`line 4: unknown key "basic_auth.users"`))
+		})
+	})
+
+	Context("the Staticfile has auth rules", func() {
+		BeforeEach(func() {
+			staticfile = `auth:
+  /**:
+    basic_auth: false
+  /admin/**:
+    basic_auth: true
+    allow: [10.0.0.0/8, "2001:db8::/32", 192.168.1.5]
+    deny: [10.1.2.3]
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has invalid auth rules", func() {
+		BeforeEach(func() {
+			staticfile = `auth:
+  admin: {basic_auth: true}
+  /admin/**:
+    basic_auth: maybe
+    allow: [10.0.0.0/33, 10.0.0.0/8]
+    deny: [10.0.0.0/8]
+  /healthz: {}
+  /private/**:
+    users: [bob]
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 2: invalid path glob "admin" in auth` + "\n" +
+				`line 4: invalid value "maybe" for auth./admin/**.basic_auth: expected one of true, false, enabled, disabled` + "\n" +
+				`line 5: invalid IP address or CIDR range "10.0.0.0/33" in auth./admin/**.allow` + "\n" +
+				"line 6: 10.0.0.0/8 is both allowed and denied in auth./admin/**\n" +
+				"line 7: invalid value for auth./healthz: expected a map with basic_auth, allow or deny\n" +
+				`line 9: unknown key "auth./private/**.users"`))
+		})
+	})
+
+	Context("the Staticfile has sso", func() {
+		BeforeEach(func() {
+			staticfile = `sso:
+  service: corp-sso
+  groups: [staff, admins]
+  groups_claim: roles
+  scopes: [openid, email, roles]
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has an invalid sso", func() {
+		BeforeEach(func() {
+			staticfile = `sso:
+  issuer: https://login.example.com
+  groups: staff
+  groups_claim: [roles]
+  scopes: [email, profile]
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 2: unknown key "sso.issuer"` + "\n" +
+				"line 3: invalid value for sso.groups: expected a list of group names\n" +
+				"line 4: invalid value for sso.groups_claim: expected a single value\n" +
+				"line 5: sso.scopes must include openid, or the issuer does not return an ID token"))
+		})
+	})
+
+	Context("the Staticfile has a health_check", func() {
+		BeforeEach(func() {
+			staticfile = `health_check:
+  path: /healthz
+  readiness: true
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has an invalid health_check", func() {
+		BeforeEach(func() {
+			staticfile = `health_check:
+  path: /health check
+  readiness: soon
+  port: 8081
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 2: invalid value "/health check" for health_check.path: expected a path of letters, digits, -, _, ~ and /` + "\n" +
+				`line 3: invalid value "soon" for health_check.readiness: expected one of true, false, enabled, disabled` + "\n" +
+				`line 4: unknown key "health_check.port"`))
+		})
+	})
+
+	Context("the Staticfile has metrics", func() {
+		BeforeEach(func() {
+			staticfile = `metrics:
+  path: /metrics
+  port: 9113
+  allow:
+    - 10.255.0.0/16
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has invalid trusted_proxies", func() {
+		BeforeEach(func() {
+			staticfile = `trusted_proxies:
+  - 10.0.16.0/24
+  - gorouter
+`
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(`line 3: invalid IP address or CIDR range "gorouter" in trusted_proxies`))
+		})
+	})
+
+	Context("the Staticfile has metrics without basic authentication or an allow list", func() {
+		BeforeEach(func() {
+			staticfile = `metrics:
+  port: 9113
+`
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError("line 2: metrics needs basic authentication or an allow list, add Staticfile.auth, basic_auth or metrics.allow"))
+		})
+
+		Context("and there is a Staticfile.auth", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "Staticfile.auth"), []byte("user:hash"), 0644)).To(Succeed())
+			})
+
+			It("does not return an error", func() {
+				Expect(err).To(BeNil())
+			})
+		})
+	})
+
+	Context("the Staticfile has invalid metrics", func() {
+		BeforeEach(func() {
+			staticfile = `metrics:
+  path: metrics
+  port: 80
+`
+		})
+
+		It("reports every problem", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`line 2: invalid value "metrics" for metrics.path: expected a path of letters, digits, -, _, ~ and /` + "\n" +
+				`line 3: invalid value "80" for metrics.port: expected a port from 1024 to 65535`))
+		})
+	})
+
+	Context("the Staticfile has metrics that are a list", func() {
+		BeforeEach(func() {
+			staticfile = `metrics:
+  - /metrics
+`
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError("line 2: invalid value for metrics: expected true, false or a map with allow, path and port"))
+		})
+	})
+
+	Context("the Staticfile has an sbom_route", func() {
+		BeforeEach(func() {
+			staticfile = `sbom_route:
+  path: /sbom
+`
+		})
+
+		It("does not return an error", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the Staticfile has an invalid sbom_route", func() {
+		BeforeEach(func() {
+			staticfile = `sbom_route: [/sbom]
+`
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError("line 1: invalid value for sbom_route: expected true, false or a map with path"))
+		})
+	})
+
+	Context("the Staticfile is not a map", func() {
+		BeforeEach(func() {
+			staticfile = "- root\n- public\n"
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError("line 1: the Staticfile must be a map of keys to values"))
+		})
+	})
+
+	Context("the Staticfile is not valid YAML", func() {
+		BeforeEach(func() {
+			staticfile = "root: [public\n"
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
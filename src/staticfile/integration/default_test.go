@@ -269,6 +269,26 @@ func testDefault(platform switchblade.Platform, fixtures string) func(*testing.T
 			})
 		})
 
+		context("when deploying a CSP app", func() {
+			it("provides the Content-Security-Policy header", func() {
+				deployment, _, err := platform.Deploy.
+					Execute(name, filepath.Join(fixtures, "default", "with_csp"))
+				Expect(err).NotTo(HaveOccurred())
+
+				uri, err := url.Parse(deployment.ExternalURL)
+				Expect(err).NotTo(HaveOccurred())
+
+				req, err := http.NewRequest("GET", uri.String(), nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				var resp *http.Response
+				Eventually(func() error { resp, err = http.DefaultClient.Do(req); return err }).Should(Succeed())
+				resp.Body.Close()
+
+				Expect(resp.Header["Content-Security-Policy"]).To(Equal([]string{"default-src 'self'; img-src 'self' data:; object-src 'none'"}))
+			})
+		})
+
 		context("when deploying a large page app", func() {
 			it("responds with the Vary: Accept-Encoding header", func() {
 				deployment, _, err := platform.Deploy.
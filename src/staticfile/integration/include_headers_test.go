@@ -109,5 +109,50 @@ func testIncludeHeaders(platform switchblade.Platform, fixtures string) func(*te
 				Expect(resp.Header).To(HaveKey("X-Superspecialroot"))
 			})
 		})
+
+		context("with headers in the Staticfile", func() {
+			it("adds headers", func() {
+				deployment, _, err := platform.Deploy.
+					Execute(name, filepath.Join(fixtures, "include_headers", "staticfile"))
+				Expect(err).NotTo(HaveOccurred())
+
+				uri, err := url.Parse(deployment.ExternalURL)
+				Expect(err).NotTo(HaveOccurred())
+
+				req, err := http.NewRequest("GET", uri.String(), nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				var resp *http.Response
+				Eventually(func() error { resp, err = http.DefaultClient.Do(req); return err }).Should(Succeed())
+				defer resp.Body.Close()
+
+				contents, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(contents).To(ContainSubstring("Test add headers"), string(contents))
+				Expect(resp.Header).To(HaveKeyWithValue("X-Superspecialstaticfile", []string{"Test add headers"}))
+				Expect(resp.Header).NotTo(HaveKey("X-Superspecialasset"))
+			})
+
+			it("adds path specific headers", func() {
+				deployment, _, err := platform.Deploy.
+					Execute(name, filepath.Join(fixtures, "include_headers", "staticfile"))
+				Expect(err).NotTo(HaveOccurred())
+
+				uri, err := url.Parse(deployment.ExternalURL)
+				Expect(err).NotTo(HaveOccurred())
+				uri.Path = "/assets/app.js"
+
+				req, err := http.NewRequest("GET", uri.String(), nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				var resp *http.Response
+				Eventually(func() error { resp, err = http.DefaultClient.Do(req); return err }).Should(Succeed())
+				defer resp.Body.Close()
+
+				Expect(resp.Header).To(HaveKeyWithValue("X-Superspecialstaticfile", []string{"Test add headers"}))
+				Expect(resp.Header).To(HaveKeyWithValue("X-Superspecialasset", []string{"Test add asset headers"}))
+			})
+		})
 	}
 }
@@ -0,0 +1,120 @@
+package launch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// passwordHashPrefixes are the hashes that are written to .htpasswd as they are,
+// any other password is treated as plaintext and hashed first.
+var passwordHashPrefixes = []string{"$apr1$", "$1$", "$5$", "$6$", "$2a$", "$2b$", "$2y$", "$y$", "{SHA}", "{SSHA}", "{PLAIN}"}
+
+type vcapService struct {
+	Name        string `json:"name"`
+	Credentials struct {
+		Users map[string]string `json:"users"`
+	} `json:"credentials"`
+}
+
+// RenderHtpasswd writes .htpasswd from the users in the bound service, or in the
+// environment variable when the service is not bound. The app does not start
+// without users, as nginx would reject every request.
+func (l *Launcher) RenderHtpasswd(basicAuth *BasicAuth) error {
+	users, source, err := l.basicAuthUsers(basicAuth)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return fmt.Errorf("no users are set in %s", source)
+	}
+
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var htpasswd strings.Builder
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, ": \t\r\n") {
+			return fmt.Errorf("invalid user name %q in %s", name, source)
+		}
+
+		password := users[name]
+		if password == "" || strings.ContainsAny(password, "\r\n") {
+			return fmt.Errorf("invalid password for user %q in %s", name, source)
+		}
+		if !isPasswordHash(password) {
+			if password, err = hashPassword(password); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(&htpasswd, "%s:%s\n", name, password)
+	}
+
+	// The file is replaced rather than rewritten, so that an existing file
+	// cannot leave it with broader permissions.
+	path := filepath.Join(l.AppRoot, "nginx", "conf", ".htpasswd")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.WriteFile(path, []byte(htpasswd.String()), 0600)
+}
+
+func (l *Launcher) basicAuthUsers(basicAuth *BasicAuth) (map[string]string, string, error) {
+	if basicAuth.Service != "" {
+		source := fmt.Sprintf("the credentials of the %s service", basicAuth.Service)
+
+		var services map[string][]vcapService
+		if vcapServices, ok := l.Env("VCAP_SERVICES"); ok && vcapServices != "" {
+			if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
+				return nil, "", fmt.Errorf("unable to read VCAP_SERVICES: %w", err)
+			}
+		}
+		for _, instances := range services {
+			for _, service := range instances {
+				if service.Name == basicAuth.Service {
+					return service.Credentials.Users, source, nil
+				}
+			}
+		}
+
+		if basicAuth.Env == "" {
+			return nil, "", fmt.Errorf("the %s service is not bound to the app", basicAuth.Service)
+		}
+	}
+
+	source := fmt.Sprintf("the %s environment variable", basicAuth.Env)
+	value, _ := l.Env(basicAuth.Env)
+
+	users := map[string]string{}
+	for i, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, password, found := strings.Cut(line, ":")
+		if !found {
+			return nil, "", fmt.Errorf("line %d of %s: expected user:password", i+1, source)
+		}
+		if _, ok := users[name]; ok {
+			return nil, "", fmt.Errorf("line %d of %s: duplicate user %q", i+1, source, name)
+		}
+		users[name] = password
+	}
+	return users, source, nil
+}
+
+func isPasswordHash(password string) bool {
+	for _, prefix := range passwordHashPrefixes {
+		if strings.HasPrefix(password, prefix) {
+			return true
+		}
+	}
+	return false
+}
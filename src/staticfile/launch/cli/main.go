@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/launch"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func main() {
+	if len(os.Args) == 4 && os.Args[1] == launch.ForwardLogCommand {
+		if err := launch.ForwardLog(os.Args[2], os.Args[3], os.Stderr); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to forward %s: %s\n", os.Args[2], err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) == 4 && os.Args[1] == launch.MetricsCommand {
+		if err := launch.ServeMetrics(os.Args[2], os.Args[3]); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to serve metrics: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	appRoot := os.Getenv("APP_ROOT")
+	if appRoot == "" {
+		appRoot = os.Getenv("HOME")
+	}
+
+	// Signals are caught from the start, so that stopping the app while nginx
+	// starts still stops every child.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	l := launch.Launcher{
+		AppRoot: appRoot,
+		Env:     os.LookupEnv,
+		Command: &libbuildpack.Command{},
+		Start:   start,
+		Signals: signals,
+		Drain:   launch.DrainPeriod,
+		Environ: os.Environ,
+		Stderr:  os.Stderr,
+	}
+
+	if err := launch.Run(&l); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to run nginx: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// start runs nginx or a process next to it, writing to the same logs.
+func start(path string, args []string, env []string) (launch.Process, error) {
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return process{cmd}, nil
+}
+
+type process struct {
+	*exec.Cmd
+}
+
+func (p process) Signal(sig os.Signal) error {
+	return p.Process.Signal(sig)
+}
@@ -0,0 +1,387 @@
+package launch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/sso"
+)
+
+// ConfigFile is written by finalize, relative to the app root, and tells the
+// launcher what to render in addition to nginx.conf.
+const ConfigFile = ".staticfile/launch.json"
+
+type Config struct {
+	RuntimeEnv *RuntimeEnv   `json:"runtime_env,omitempty"`
+	BasicAuth  *BasicAuth    `json:"basic_auth,omitempty"`
+	SSO        *sso.Settings `json:"sso,omitempty"`
+	// ErrorLogInstanceIndex prefixes the lines of the error log with
+	// CF_INSTANCE_INDEX.
+	ErrorLogInstanceIndex bool `json:"error_log_instance_index,omitempty"`
+	// Metrics starts the exporter for the nginx stub_status.
+	Metrics bool `json:"metrics,omitempty"`
+	// Readiness writes ReadyFile once nginx and its sidecars have started, and
+	// removes it when the app stops, for the health check to answer 503 without it.
+	Readiness bool `json:"readiness,omitempty"`
+}
+
+type RuntimeEnv struct {
+	Variables []string         `json:"variables"`
+	Path      string           `json:"path"`
+	Files     []RuntimeEnvFile `json:"files,omitempty"`
+}
+
+type RuntimeEnvFile struct {
+	Path   string `json:"path"`
+	Escape string `json:"escape"`
+}
+
+type BasicAuth struct {
+	Env     string `json:"env,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+type Command interface {
+	Execute(string, io.Writer, io.Writer, string, ...string) error
+}
+
+type Launcher struct {
+	AppRoot string
+	Env     func(string) (string, bool)
+	Command Command
+	Start   func(string, []string, []string) (Process, error)
+	Signals <-chan os.Signal
+	// Drain is how long nginx keeps serving with the health check answering 503
+	// once the app stops, when readiness is enabled.
+	Drain   time.Duration
+	Environ func() []string
+	Stderr  io.Writer
+
+	children []child
+	ready    string
+}
+
+const forceHTTPSDirective = `if ($best_proto != "https") { return 301 https://$best_host$best_prefix$request_uri; }`
+
+var proxyURLPlaceholder = regexp.MustCompile(`\(\(PROXY_URL:([A-Za-z_][A-Za-z0-9_]*)\)\)`)
+
+// ProxyURLPattern matches the upstream URLs that nginx.conf can proxy to without
+// quoting. finalize checks the URLs in the Staticfile with it, and the launcher
+// the ones from the environment.
+var ProxyURLPattern = regexp.MustCompile(`^https?://[A-Za-z0-9.-]+(:[0-9]+)?(/[A-Za-z0-9._~%@+,=:/-]*)?$`)
+
+// SSOSocket is where the sso sidecar listens, relative to the app root.
+const SSOSocket = "nginx/sso.sock"
+
+// ReadyFile is written, relative to the app root, once nginx and its sidecars
+// have started.
+const ReadyFile = ".staticfile/ready"
+
+// DrainPeriod leaves the router time to notice the failing health check, within
+// the 10 seconds Cloud Foundry waits before it kills the app.
+const DrainPeriod = 5 * time.Second
+
+// sidecarStartTimeout is how long nginx waits for a sidecar to listen.
+const sidecarStartTimeout = 10 * time.Second
+
+// ReadConfig reads the launch.json written by finalize. Droplets staged before
+// there was a launch.json start with an empty config.
+func ReadConfig(appRoot string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(filepath.Join(appRoot, ConfigFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("unable to read %s: %w", ConfigFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return config, err
+	}
+	return config, nil
+}
+
+func Run(l *Launcher) error {
+	config, err := ReadConfig(l.AppRoot)
+	if err != nil {
+		return err
+	}
+
+	ready := filepath.Join(l.AppRoot, ReadyFile)
+	if err := os.Remove(ready); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	confFile, err := l.RenderNginxConf()
+	if err != nil {
+		return fmt.Errorf("unable to render nginx.conf: %w", err)
+	}
+
+	if err := l.LinkLogs(); err != nil {
+		return fmt.Errorf("unable to set up nginx logs: %w", err)
+	}
+
+	if config.ErrorLogInstanceIndex {
+		if err := l.ForwardErrorLog(); err != nil {
+			return fmt.Errorf("unable to set up the nginx error log: %w", err)
+		}
+	}
+
+	if config.RuntimeEnv != nil {
+		if err := l.RenderRuntimeEnv(config.RuntimeEnv); err != nil {
+			return fmt.Errorf("unable to render runtime environment variables: %w", err)
+		}
+	}
+
+	if config.BasicAuth != nil {
+		if err := l.RenderHtpasswd(config.BasicAuth); err != nil {
+			return fmt.Errorf("unable to set up basic authentication: %w", err)
+		}
+	}
+
+	prefix := filepath.Join(l.AppRoot, "nginx")
+	output := new(bytes.Buffer)
+	if err := l.Command.Execute(l.AppRoot, output, output, "nginx", "-t", "-q", "-p", prefix, "-c", confFile); err != nil {
+		io.Copy(l.Stderr, output)
+		return fmt.Errorf("nginx configuration test failed: %w", err)
+	}
+
+	if config.SSO != nil {
+		if err := l.StartSSO(config.SSO); err != nil {
+			return fmt.Errorf("unable to start single sign-on: %w", err)
+		}
+	}
+
+	if config.Metrics {
+		if err := l.StartMetrics(); err != nil {
+			return fmt.Errorf("unable to start the metrics exporter: %w", err)
+		}
+	}
+
+	nginx, err := l.lookPath("nginx")
+	if err != nil {
+		return err
+	}
+	if err := l.start("nginx", nginx, []string{"nginx", "-p", prefix, "-c", confFile}); err != nil {
+		return fmt.Errorf("unable to start nginx: %w", err)
+	}
+
+	if config.Readiness {
+		if err := os.WriteFile(ready, nil, 0644); err != nil {
+			return err
+		}
+		l.ready = ready
+	}
+	return l.Supervise()
+}
+
+// StartSSO starts the sso sidecar, which keeps running next to nginx, and waits
+// until it listens. The issuer and the client are checked first, so that a
+// missing variable stops the app with a clear message.
+func (l *Launcher) StartSSO(settings *sso.Settings) error {
+	if _, err := sso.LoadConfig(l.Env, *settings); err != nil {
+		return err
+	}
+
+	socket := filepath.Join(l.AppRoot, SSOSocket)
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sidecar := filepath.Join(l.AppRoot, ".staticfile", "sso")
+	if err := l.start("the sso sidecar", sidecar, []string{"sso", "-config", filepath.Join(l.AppRoot, ConfigFile), "-socket", socket}); err != nil {
+		return err
+	}
+	return l.waitForSocket("the sso sidecar", SSOSocket)
+}
+
+// waitForSocket waits until the sidecar that was just started listens on socket,
+// relative to the app root.
+func (l *Launcher) waitForSocket(name, socket string) error {
+	for deadline := time.Now().Add(sidecarStartTimeout); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if _, err := os.Stat(filepath.Join(l.AppRoot, socket)); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s did not listen on %s within %s", name, socket, sidecarStartTimeout)
+}
+
+// RenderNginxConf fills in the placeholders that depend on the environment, and
+// writes the result next to nginx.conf so that relative includes still work. The
+// nginx.conf written by finalize is left untouched, so restarting renders it again.
+func (l *Launcher) RenderNginxConf() (string, error) {
+	confDir := filepath.Join(l.AppRoot, "nginx", "conf")
+	template, err := os.ReadFile(filepath.Join(confDir, "nginx.conf"))
+	if err != nil {
+		return "", err
+	}
+
+	conf, err := l.Render(string(template))
+	if err != nil {
+		return "", err
+	}
+
+	confFile := filepath.Join(confDir, "nginx.rendered.conf")
+	return confFile, os.WriteFile(confFile, []byte(conf), 0644)
+}
+
+// Render replaces the ((PLACEHOLDERS)) that finalize leaves in nginx.conf.
+func (l *Launcher) Render(template string) (string, error) {
+	port := l.getenv("PORT", "8080")
+	listen := fmt.Sprintf("listen %s;", port)
+	if l.getenv("ENABLE_HTTP2", "") != "" {
+		listen = fmt.Sprintf("listen %s http2;", port)
+	}
+	forceHTTPS := ""
+	if l.getenv("FORCE_HTTPS", "") != "" {
+		forceHTTPS = forceHTTPSDirective
+	}
+	errorLogLevel := "error"
+	if l.getenv("BP_DEBUG", "") != "" {
+		errorLogLevel = "debug"
+	}
+
+	conf := strings.NewReplacer(
+		"((APP_ROOT))", l.AppRoot,
+		"((PORT))", port,
+		"((LISTEN_DIRECTIVE))", listen,
+		"((FORCE_HTTPS_DIRECTIVE))", forceHTTPS,
+		"((ERROR_LOG_LEVEL))", errorLogLevel,
+	).Replace(template)
+
+	var missing, invalid []string
+	conf = proxyURLPlaceholder.ReplaceAllStringFunc(conf, func(placeholder string) string {
+		name := proxyURLPlaceholder.FindStringSubmatch(placeholder)[1]
+		url := l.getenv(name, "")
+		if url == "" {
+			missing = append(missing, name)
+			return placeholder
+		}
+		if !ProxyURLPattern.MatchString(url) {
+			invalid = append(invalid, name)
+			return placeholder
+		}
+		if !strings.HasSuffix(url, "/") {
+			url += "/"
+		}
+		return url
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("the Staticfile proxies to environment variables that are not set: %s", strings.Join(missing, ", "))
+	}
+	if len(invalid) > 0 {
+		return "", fmt.Errorf("the Staticfile proxies to environment variables that are not http(s) URLs without a query: %s", strings.Join(invalid, ", "))
+	}
+	return conf, nil
+}
+
+// LinkLogs points the nginx logs at the launcher's stdout and stderr, which nginx
+// inherits when it is started.
+func (l *Launcher) LinkLogs() error {
+	logsDir := filepath.Join(l.AppRoot, "nginx", "logs")
+	if err := os.MkdirAll(logsDir, 0755); err != nil {
+		return err
+	}
+
+	for log, target := range map[string]string{"access.log": "/dev/stdout", "error.log": "/dev/stderr"} {
+		path := filepath.Join(logsDir, log)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(target, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RuntimeDir is where the launcher renders the runtime_env file and the files
+// with ${VAR} placeholders, relative to the app root. nginx serves them from there
+// at their paths in public, which stays as it was staged, compressed copies and all.
+const RuntimeDir = "nginx/runtime"
+
+// RenderRuntimeEnv writes the allowed environment variables to the runtime_env
+// file and substitutes their ${VAR} placeholders in copies of the listed files.
+func (l *Launcher) RenderRuntimeEnv(runtimeEnv *RuntimeEnv) error {
+	publicDir := filepath.Join(l.AppRoot, "public")
+	runtimeDir := filepath.Join(l.AppRoot, RuntimeDir)
+
+	values := map[string]string{}
+	for _, name := range runtimeEnv.Variables {
+		if value, ok := l.Env(name); ok {
+			values[name] = value
+		}
+	}
+
+	env, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(runtimeEnv.Path, ".js") {
+		env = []byte(fmt.Sprintf("window.__ENV__ = %s;", escapeScript(string(env))))
+	}
+	if err := writeFile(filepath.Join(runtimeDir, filepath.FromSlash(runtimeEnv.Path)), append(env, '\n')); err != nil {
+		return err
+	}
+
+	for _, file := range runtimeEnv.Files {
+		contents, err := os.ReadFile(filepath.Join(publicDir, filepath.FromSlash(file.Path)))
+		if err != nil {
+			return err
+		}
+
+		var replacements []string
+		for _, name := range runtimeEnv.Variables {
+			replacements = append(replacements, "${"+name+"}", escape(file.Escape, values[name]))
+		}
+		if err := writeFile(filepath.Join(runtimeDir, filepath.FromSlash(file.Path)), []byte(strings.NewReplacer(replacements...).Replace(string(contents)))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func escape(kind, value string) string {
+	if kind == "html" {
+		return html.EscapeString(value)
+	}
+
+	quoted, _ := json.Marshal(value)
+	return escapeScript(strings.TrimSuffix(strings.TrimPrefix(string(quoted), `"`), `"`))
+}
+
+// escapeScript makes JSON safe to use inside a single quoted JavaScript string
+// and inside an inline script. json.Marshal already escapes <, > and &.
+func escapeScript(value string) string {
+	return strings.ReplaceAll(value, "'", `\u0027`)
+}
+
+func writeFile(path string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0644)
+}
+
+func (l *Launcher) getenv(name, fallback string) string {
+	if value, ok := l.Env(name); ok {
+		return value
+	}
+	return fallback
+}
+
+func (l *Launcher) lookPath(program string) (string, error) {
+	path, _ := l.Env("PATH")
+	for _, dir := range filepath.SplitList(path) {
+		candidate := filepath.Join(dir, program)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find %s on the PATH", program)
+}
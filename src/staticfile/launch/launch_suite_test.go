@@ -0,0 +1,13 @@
+package launch_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLaunch(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Launch Suite")
+}
@@ -0,0 +1,660 @@
+package launch_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/launch"
+	"github.com/golang/mock/gomock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+//go:generate mockgen -source=launch.go --destination=mocks_test.go --package=launch_test
+
+var _ = Describe("Launch", func() {
+	var (
+		err       error
+		appRoot   string
+		binDir    string
+		env       map[string]string
+		launcher  *launch.Launcher
+		mockCtrl  *gomock.Controller
+		mockCmd   *MockCommand
+		stderr    *bytes.Buffer
+		signals   chan os.Signal
+		started   [][]string
+		signalled []string
+		exits     map[string]error
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	readFile := func(path string) string {
+		contents, err := os.ReadFile(path)
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	BeforeEach(func() {
+		appRoot, err = os.MkdirTemp("", "staticfile-buildpack.app.")
+		Expect(err).To(BeNil())
+
+		binDir, err = os.MkdirTemp("", "staticfile-buildpack.bin.")
+		Expect(err).To(BeNil())
+		Expect(os.WriteFile(filepath.Join(binDir, "nginx"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+		DeferCleanup(func() {
+			Expect(os.RemoveAll(appRoot)).To(Succeed())
+			Expect(os.RemoveAll(binDir)).To(Succeed())
+		})
+
+		writeFile(filepath.Join(appRoot, "nginx", "conf", "nginx.conf"), "root ((APP_ROOT))/public;\n((LISTEN_DIRECTIVE))\nport ((PORT));\n((FORCE_HTTPS_DIRECTIVE))\n")
+		writeFile(filepath.Join(appRoot, ".staticfile", "launch"), "")
+
+		env = map[string]string{"PATH": binDir}
+		stderr = new(bytes.Buffer)
+		signals = make(chan os.Signal, 1)
+		started, signalled = nil, nil
+		exits = map[string]error{}
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCmd = NewMockCommand(mockCtrl)
+	})
+
+	JustBeforeEach(func() {
+		launcher = &launch.Launcher{
+			AppRoot: appRoot,
+			Env: func(name string) (string, bool) {
+				value, ok := env[name]
+				return value, ok
+			},
+			Command: mockCmd,
+			Start: func(path string, args []string, _ []string) (launch.Process, error) {
+				started = append(started, append([]string{path}, args...))
+				name := args[0]
+				if name == "launch" {
+					name = args[1]
+				}
+				if name == "sso" || name == "metrics" {
+					// The sidecar creates its socket once it listens.
+					writeFile(args[len(args)-1], "")
+				}
+				process := &fakeProcess{exit: make(chan error, 1)}
+				process.signal = func(sig os.Signal) {
+					signalled = append(signalled, fmt.Sprintf("%s %s", name, sig))
+					process.exit <- nil
+				}
+				if err, ok := exits[name]; ok {
+					process.exit <- err
+				}
+				return process, nil
+			},
+			Signals: signals,
+			Drain:   10 * time.Millisecond,
+			Environ: func() []string { return nil },
+			Stderr:  stderr,
+		}
+	})
+
+	Describe("Run", func() {
+		var (
+			confFile string
+			nginx    []string
+		)
+
+		BeforeEach(func() {
+			confFile = filepath.Join(appRoot, "nginx", "conf", "nginx.rendered.conf")
+			nginx = []string{filepath.Join(binDir, "nginx"), "nginx", "-p", filepath.Join(appRoot, "nginx"), "-c", confFile}
+			// The app is stopped once everything has started.
+			signals <- syscall.SIGTERM
+		})
+
+		Context("the configuration is valid", func() {
+			BeforeEach(func() {
+				mockCmd.EXPECT().Execute(appRoot, gomock.Any(), gomock.Any(), "nginx", "-t", "-q", "-p", filepath.Join(appRoot, "nginx"), "-c", confFile).Return(nil)
+			})
+
+			It("starts nginx with the rendered configuration and stops it with the app", func() {
+				Expect(launch.Run(launcher)).To(Succeed())
+				Expect(started).To(Equal([][]string{nginx}))
+				Expect(signalled).To(Equal([]string{"nginx terminated"}))
+			})
+
+			It("does not modify nginx.conf", func() {
+				Expect(launch.Run(launcher)).To(Succeed())
+				Expect(readFile(filepath.Join(appRoot, "nginx", "conf", "nginx.conf"))).To(ContainSubstring("((APP_ROOT))"))
+			})
+		})
+
+		Context("the configuration test fails", func() {
+			BeforeEach(func() {
+				mockCmd.EXPECT().Execute(appRoot, gomock.Any(), gomock.Any(), "nginx", "-t", "-q", "-p", filepath.Join(appRoot, "nginx"), "-c", confFile).DoAndReturn(
+					func(_ string, stdout, _ io.Writer, _ string, _ ...string) error {
+						stdout.Write([]byte("nginx: [emerg] unknown directive\n"))
+						return errors.New("exit status 1")
+					})
+			})
+
+			It("reports the nginx output and does not start nginx", func() {
+				Expect(launch.Run(launcher)).To(MatchError(ContainSubstring("nginx configuration test failed")))
+				Expect(stderr.String()).To(Equal("nginx: [emerg] unknown directive\n"))
+				Expect(started).To(BeEmpty())
+			})
+
+			It("removes the ready file left by an earlier start", func() {
+				writeFile(filepath.Join(appRoot, launch.ReadyFile), "")
+				Expect(launch.Run(launcher)).NotTo(Succeed())
+				Expect(filepath.Join(appRoot, launch.ReadyFile)).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("launch.json contains invalid JSON", func() {
+			BeforeEach(func() {
+				writeFile(filepath.Join(appRoot, launch.ConfigFile), "{")
+			})
+
+			It("returns an error", func() {
+				Expect(launch.Run(launcher)).To(MatchError(ContainSubstring("unable to read .staticfile/launch.json")))
+				Expect(started).To(BeEmpty())
+			})
+		})
+
+		Context("launch.json prefixes the error log with the instance index", func() {
+			BeforeEach(func() {
+				writeFile(filepath.Join(appRoot, launch.ConfigFile), `{"error_log_instance_index": true}`)
+				env["CF_INSTANCE_INDEX"] = "3"
+				mockCmd.EXPECT().Execute(appRoot, gomock.Any(), gomock.Any(), "nginx", "-t", "-q", "-p", filepath.Join(appRoot, "nginx"), "-c", confFile).Return(nil)
+			})
+
+			It("starts forwarding the error log before nginx opens it", func() {
+				Expect(launch.Run(launcher)).To(Succeed())
+				errorLog := filepath.Join(appRoot, "nginx", "logs", "error.log")
+				Expect(started).To(Equal([][]string{{
+					filepath.Join(appRoot, ".staticfile", "launch"), "launch", "forward-log", errorLog, "[instance 3] ",
+				}, nginx}))
+
+				info, err := os.Lstat(errorLog)
+				Expect(err).To(BeNil())
+				Expect(info.Mode() & os.ModeNamedPipe).NotTo(BeZero())
+			})
+		})
+
+		Context("launch.json enables readiness", func() {
+			BeforeEach(func() {
+				writeFile(filepath.Join(appRoot, launch.ConfigFile), `{"readiness": true}`)
+				mockCmd.EXPECT().Execute(appRoot, gomock.Any(), gomock.Any(), "nginx", "-t", "-q", "-p", filepath.Join(appRoot, "nginx"), "-c", confFile).Return(nil)
+				<-signals
+			})
+
+			It("marks the app as ready once nginx has started, and drains nginx when the app stops", func() {
+				readyFile := filepath.Join(appRoot, launch.ReadyFile)
+				go func() {
+					defer GinkgoRecover()
+					Eventually(readyFile).Should(BeAnExistingFile())
+					signals <- syscall.SIGTERM
+				}()
+
+				Expect(launch.Run(launcher)).To(Succeed())
+				Expect(readyFile).NotTo(BeAnExistingFile())
+				Expect(signalled).To(Equal([]string{"nginx quit"}))
+			})
+		})
+
+		Context("launch.json enables metrics", func() {
+			BeforeEach(func() {
+				writeFile(filepath.Join(appRoot, launch.ConfigFile), `{"metrics": true}`)
+				mockCmd.EXPECT().Execute(appRoot, gomock.Any(), gomock.Any(), "nginx", "-t", "-q", "-p", filepath.Join(appRoot, "nginx"), "-c", confFile).Return(nil)
+			})
+
+			It("starts the exporter next to nginx", func() {
+				Expect(launch.Run(launcher)).To(Succeed())
+				Expect(started).To(Equal([][]string{{
+					filepath.Join(appRoot, ".staticfile", "launch"), "launch", "metrics", filepath.Join(appRoot, "nginx", "status.sock"), filepath.Join(appRoot, "nginx", "metrics.sock"),
+				}, nginx}))
+			})
+
+			Context("the exporter exits", func() {
+				BeforeEach(func() {
+					<-signals
+					exits["metrics"] = errors.New("exit status 1")
+				})
+
+				It("stops nginx and reports the exporter", func() {
+					Expect(launch.Run(launcher)).To(MatchError("the metrics exporter exited: exit status 1"))
+					Expect(signalled).To(Equal([]string{"nginx terminated"}))
+				})
+			})
+		})
+
+		Context("launch.json enables sso", func() {
+			BeforeEach(func() {
+				writeFile(filepath.Join(appRoot, launch.ConfigFile), `{"sso": {"groups": ["staff"]}}`)
+				mockCmd.EXPECT().Execute(appRoot, gomock.Any(), gomock.Any(), "nginx", "-t", "-q", "-p", filepath.Join(appRoot, "nginx"), "-c", confFile).Return(nil)
+			})
+
+			Context("the issuer and the client are set", func() {
+				BeforeEach(func() {
+					env["SSO_ISSUER"] = "https://login.example.com"
+					env["SSO_CLIENT_ID"] = "portal"
+					env["SSO_CLIENT_SECRET"] = "s3cret"
+				})
+
+				It("starts the sidecar before nginx", func() {
+					Expect(launch.Run(launcher)).To(Succeed())
+					Expect(started).To(Equal([][]string{{
+						filepath.Join(appRoot, ".staticfile", "sso"), "sso",
+						"-config", filepath.Join(appRoot, launch.ConfigFile),
+						"-socket", filepath.Join(appRoot, launch.SSOSocket),
+					}, nginx}))
+				})
+
+				It("stops the sidecar once nginx has stopped", func() {
+					Expect(launch.Run(launcher)).To(Succeed())
+					Expect(signalled).To(Equal([]string{"nginx terminated", "sso terminated"}))
+				})
+
+				Context("the sidecar exits", func() {
+					BeforeEach(func() {
+						<-signals
+						exits["sso"] = nil
+					})
+
+					It("stops nginx and reports the sidecar", func() {
+						Expect(launch.Run(launcher)).To(MatchError("the sso sidecar exited: exit status 0"))
+						Expect(signalled).To(Equal([]string{"nginx terminated"}))
+					})
+				})
+			})
+
+			Context("the client is not set", func() {
+				BeforeEach(func() {
+					env["SSO_ISSUER"] = "https://login.example.com"
+				})
+
+				It("does not start the sidecar or nginx", func() {
+					Expect(launch.Run(launcher)).To(MatchError("unable to start single sign-on: the environment does not set client_id, client_secret"))
+					Expect(started).To(BeEmpty())
+				})
+			})
+		})
+	})
+
+	Describe("RenderNginxConf", func() {
+		var confFile string
+
+		JustBeforeEach(func() {
+			confFile, err = launcher.RenderNginxConf()
+		})
+
+		It("fills in the app root and the default port", func() {
+			Expect(err).To(BeNil())
+			Expect(confFile).To(Equal(filepath.Join(appRoot, "nginx", "conf", "nginx.rendered.conf")))
+			Expect(readFile(confFile)).To(Equal("root " + appRoot + "/public;\nlisten 8080;\nport 8080;\n\n"))
+		})
+
+		Context("the app root contains characters that sed treats specially", func() {
+			BeforeEach(func() {
+				oldRoot := appRoot
+				appRoot = filepath.Join(oldRoot, "a#b&c")
+				writeFile(filepath.Join(appRoot, "nginx", "conf", "nginx.conf"), "root ((APP_ROOT))/public;\n")
+			})
+
+			It("uses the path as it is", func() {
+				Expect(err).To(BeNil())
+				Expect(readFile(confFile)).To(Equal("root " + appRoot + "/public;\n"))
+			})
+		})
+
+		Context("nginx.conf leaves the error log level to the launcher", func() {
+			BeforeEach(func() {
+				writeFile(filepath.Join(appRoot, "nginx", "conf", "nginx.conf"), "error_log logs/error.log ((ERROR_LOG_LEVEL));\n")
+			})
+
+			It("logs errors", func() {
+				Expect(err).To(BeNil())
+				Expect(readFile(confFile)).To(Equal("error_log logs/error.log error;\n"))
+			})
+
+			Context("BP_DEBUG is set", func() {
+				BeforeEach(func() {
+					env["BP_DEBUG"] = "true"
+				})
+
+				It("logs everything", func() {
+					Expect(err).To(BeNil())
+					Expect(readFile(confFile)).To(Equal("error_log logs/error.log debug;\n"))
+				})
+			})
+		})
+
+		Context("PORT, ENABLE_HTTP2 and FORCE_HTTPS are set", func() {
+			BeforeEach(func() {
+				env["PORT"] = "9000"
+				env["ENABLE_HTTP2"] = "true"
+				env["FORCE_HTTPS"] = "true"
+			})
+
+			It("listens with http2 and redirects to https", func() {
+				Expect(err).To(BeNil())
+				Expect(readFile(confFile)).To(ContainSubstring("listen 9000 http2;\nport 9000;\n"))
+				Expect(readFile(confFile)).To(ContainSubstring(`if ($best_proto != "https") { return 301 https://$best_host$best_prefix$request_uri; }`))
+			})
+		})
+
+		Context("nginx.conf proxies to a URL from the environment", func() {
+			BeforeEach(func() {
+				writeFile(filepath.Join(appRoot, "nginx", "conf", "nginx.conf"), "proxy_pass ((PROXY_URL:API_URL));\nproxy_pass ((PROXY_URL:CHAT_URL));\n")
+			})
+
+			Context("the variables are set", func() {
+				BeforeEach(func() {
+					env["API_URL"] = "https://api.example.com/v1"
+					env["CHAT_URL"] = "http://chat.example.com/"
+				})
+
+				It("fills in the URLs with a trailing slash", func() {
+					Expect(err).To(BeNil())
+					Expect(readFile(confFile)).To(Equal("proxy_pass https://api.example.com/v1/;\nproxy_pass http://chat.example.com/;\n"))
+				})
+			})
+
+			Context("the variables are not set", func() {
+				It("returns an error naming them", func() {
+					Expect(err).To(MatchError("the Staticfile proxies to environment variables that are not set: API_URL, CHAT_URL"))
+				})
+			})
+
+			Context("a variable is not a URL that nginx.conf can hold", func() {
+				BeforeEach(func() {
+					env["API_URL"] = "http://api.example.com/; return 200 owned; }"
+					env["CHAT_URL"] = "http://chat.example.com/"
+				})
+
+				It("returns an error naming it", func() {
+					Expect(err).To(MatchError("the Staticfile proxies to environment variables that are not http(s) URLs without a query: API_URL"))
+					Expect(filepath.Join(appRoot, "nginx", "conf", "nginx.rendered.conf")).NotTo(BeAnExistingFile())
+				})
+			})
+		})
+	})
+
+	Describe("LinkLogs", func() {
+		It("links the nginx logs to stdout and stderr", func() {
+			writeFile(filepath.Join(appRoot, "nginx", "logs", "access.log"), "old")
+
+			Expect(launcher.LinkLogs()).To(Succeed())
+
+			target, err := os.Readlink(filepath.Join(appRoot, "nginx", "logs", "access.log"))
+			Expect(err).To(BeNil())
+			Expect(target).To(Equal("/dev/stdout"))
+
+			target, err = os.Readlink(filepath.Join(appRoot, "nginx", "logs", "error.log"))
+			Expect(err).To(BeNil())
+			Expect(target).To(Equal("/dev/stderr"))
+		})
+	})
+
+	Describe("ForwardLog", func() {
+		It("copies every line with the prefix in front", func() {
+			log := filepath.Join(appRoot, "error.log")
+			writeFile(log, "2024/01/02 03:04:05 [error] 12#0: open() failed\nsecond line\n")
+
+			output := new(bytes.Buffer)
+			Expect(launch.ForwardLog(log, "[instance 0] ", output)).To(Succeed())
+			Expect(output.String()).To(Equal("[instance 0] 2024/01/02 03:04:05 [error] 12#0: open() failed\n[instance 0] second line\n"))
+		})
+	})
+
+	Describe("ParseStubStatus", func() {
+		It("reads the connections and requests", func() {
+			status, err := launch.ParseStubStatus("Active connections: 2 \nserver accepts handled requests\n 10 9 25 \nReading: 0 Writing: 1 Waiting: 1 \n")
+			Expect(err).To(BeNil())
+			Expect(status).To(Equal(launch.StubStatus{Active: 2, Accepted: 10, Handled: 9, Requests: 25, Reading: 0, Writing: 1, Waiting: 1}))
+		})
+
+		It("rejects anything else", func() {
+			_, err := launch.ParseStubStatus("<html>404 Not Found</html>")
+			Expect(err).To(MatchError(ContainSubstring("unexpected stub_status output")))
+		})
+	})
+
+	Describe("NewMetricsHandler", func() {
+		var status string
+
+		BeforeEach(func() {
+			status = filepath.Join(appRoot, "status.sock")
+		})
+
+		scrape := func() string {
+			recorder := httptest.NewRecorder()
+			launch.NewMetricsHandler(status).ServeHTTP(recorder, httptest.NewRequest("GET", "/__metrics", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+			return recorder.Body.String()
+		}
+
+		Context("nginx serves stub_status", func() {
+			BeforeEach(func() {
+				listener, err := net.Listen("unix", status)
+				Expect(err).To(BeNil())
+				server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					Expect(r.URL.Path).To(Equal("/stub_status"))
+					w.Write([]byte("Active connections: 2 \nserver accepts handled requests\n 10 9 25 \nReading: 0 Writing: 1 Waiting: 1 \n"))
+				})}
+				go server.Serve(listener)
+				DeferCleanup(server.Close)
+			})
+
+			It("writes the Prometheus text format", func() {
+				metrics := scrape()
+				Expect(metrics).To(ContainSubstring("# TYPE nginx_up gauge\nnginx_up 1\n"))
+				Expect(metrics).To(ContainSubstring("# TYPE nginx_connections_active gauge\nnginx_connections_active 2\n"))
+				Expect(metrics).To(ContainSubstring("# TYPE nginx_connections_handled counter\nnginx_connections_handled 9\n"))
+				Expect(metrics).To(ContainSubstring("# TYPE nginx_http_requests_total counter\nnginx_http_requests_total 25\n"))
+			})
+		})
+
+		Context("nginx is not listening", func() {
+			It("reports that nginx is down", func() {
+				Expect(scrape()).To(Equal("# HELP nginx_up Whether the nginx status could be read.\n# TYPE nginx_up gauge\nnginx_up 0\n"))
+			})
+		})
+	})
+
+	Describe("RenderRuntimeEnv", func() {
+		var runtimeEnv *launch.RuntimeEnv
+
+		BeforeEach(func() {
+			env["API_URL"] = `https://api.example.com/?a=1&b='2'`
+			env["TITLE"] = `<Tom & "Jerry">`
+			env["SECRET"] = "not allowed"
+			runtimeEnv = &launch.RuntimeEnv{
+				Variables: []string{"API_URL", "TITLE", "UNSET"},
+				Path:      "/env.js",
+			}
+		})
+
+		JustBeforeEach(func() {
+			err = launcher.RenderRuntimeEnv(runtimeEnv)
+		})
+
+		It("writes the allowed variables that are set as a script", func() {
+			Expect(err).To(BeNil())
+			Expect(readFile(filepath.Join(appRoot, launch.RuntimeDir, "env.js"))).To(Equal(
+				`window.__ENV__ = {"API_URL":"https://api.example.com/?a=1\u0026b=\u00272\u0027","TITLE":"\u003cTom \u0026 \"Jerry\"\u003e"};` + "\n"))
+		})
+
+		Context("the path is a JSON file", func() {
+			BeforeEach(func() {
+				runtimeEnv.Path = "/config/env.json"
+			})
+
+			It("writes the variables as a JSON document", func() {
+				Expect(err).To(BeNil())
+				Expect(readFile(filepath.Join(appRoot, launch.RuntimeDir, "config", "env.json"))).To(Equal(
+					`{"API_URL":"https://api.example.com/?a=1\u0026b='2'","TITLE":"\u003cTom \u0026 \"Jerry\"\u003e"}` + "\n"))
+			})
+		})
+
+		Context("files contain placeholders", func() {
+			BeforeEach(func() {
+				writeFile(filepath.Join(appRoot, "public", "index.html"), `<title>${TITLE}</title>${UNSET}`)
+				writeFile(filepath.Join(appRoot, "public", "index.html.gz"), "compressed")
+				writeFile(filepath.Join(appRoot, "public", "assets", "app.js"), `fetch('${API_URL}')`)
+				writeFile(filepath.Join(appRoot, "public", "assets", "app.js.br"), "compressed")
+				runtimeEnv.Files = []launch.RuntimeEnvFile{
+					{Path: "assets/app.js", Escape: "json"},
+					{Path: "index.html", Escape: "html"},
+				}
+			})
+
+			It("substitutes escaped values in copies", func() {
+				Expect(err).To(BeNil())
+				Expect(readFile(filepath.Join(appRoot, launch.RuntimeDir, "index.html"))).To(Equal(`<title>&lt;Tom &amp; &#34;Jerry&#34;&gt;</title>`))
+				Expect(readFile(filepath.Join(appRoot, launch.RuntimeDir, "assets", "app.js"))).To(Equal(`fetch('https://api.example.com/?a=1\u0026b=\u00272\u0027')`))
+			})
+
+			It("leaves public as it was staged", func() {
+				Expect(err).To(BeNil())
+				Expect(readFile(filepath.Join(appRoot, "public", "index.html"))).To(Equal(`<title>${TITLE}</title>${UNSET}`))
+				Expect(readFile(filepath.Join(appRoot, "public", "index.html.gz"))).To(Equal("compressed"))
+				Expect(readFile(filepath.Join(appRoot, "public", "assets", "app.js.br"))).To(Equal("compressed"))
+			})
+		})
+	})
+
+	Describe("RenderHtpasswd", func() {
+		var (
+			basicAuth *launch.BasicAuth
+			htpasswd  string
+		)
+
+		BeforeEach(func() {
+			htpasswd = filepath.Join(appRoot, "nginx", "conf", ".htpasswd")
+			basicAuth = &launch.BasicAuth{Env: "STATICFILE_AUTH_USERS"}
+			env["STATICFILE_AUTH_USERS"] = "# editors\nbob:$apr1$DuUQEQp8$ZccZCHQElNSjrg.erwSFC0\nalice:s3cret:with:colons\n"
+		})
+
+		JustBeforeEach(func() {
+			err = launcher.RenderHtpasswd(basicAuth)
+		})
+
+		It("writes the users with plaintext passwords hashed", func() {
+			Expect(err).To(BeNil())
+			Expect(readFile(htpasswd)).To(MatchRegexp(`^alice:\$6\$[./0-9A-Za-z]{16}\$[./0-9A-Za-z]{86}\nbob:\$apr1\$DuUQEQp8\$ZccZCHQElNSjrg\.erwSFC0\n$`))
+		})
+
+		It("is only readable by the app user", func() {
+			Expect(err).To(BeNil())
+			info, err := os.Stat(htpasswd)
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		Context(".htpasswd already exists", func() {
+			BeforeEach(func() {
+				writeFile(htpasswd, "old")
+			})
+
+			It("replaces it with a file only readable by the app user", func() {
+				Expect(err).To(BeNil())
+				Expect(readFile(htpasswd)).NotTo(ContainSubstring("old"))
+				info, err := os.Stat(htpasswd)
+				Expect(err).To(BeNil())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			})
+		})
+
+		Context("the environment variable is not set", func() {
+			BeforeEach(func() {
+				delete(env, "STATICFILE_AUTH_USERS")
+			})
+
+			It("refuses to start", func() {
+				Expect(err).To(MatchError("no users are set in the STATICFILE_AUTH_USERS environment variable"))
+				Expect(htpasswd).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("an entry is malformed", func() {
+			BeforeEach(func() {
+				env["STATICFILE_AUTH_USERS"] = "bob:secret\nalice\n"
+			})
+
+			It("reports the line without the passwords", func() {
+				Expect(err).To(MatchError("line 2 of the STATICFILE_AUTH_USERS environment variable: expected user:password"))
+			})
+		})
+
+		Context("users come from a service", func() {
+			BeforeEach(func() {
+				basicAuth = &launch.BasicAuth{Env: "STATICFILE_AUTH_USERS", Service: "site-users"}
+				env["VCAP_SERVICES"] = `{"user-provided": [{"name": "other", "credentials": {}}, {"name": "site-users", "credentials": {"users": {"carol": "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="}}}]}`
+			})
+
+			It("uses the service instead of the environment variable", func() {
+				Expect(err).To(BeNil())
+				Expect(readFile(htpasswd)).To(Equal("carol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+			})
+
+			Context("the service is not bound", func() {
+				BeforeEach(func() {
+					env["VCAP_SERVICES"] = `{}`
+				})
+
+				It("falls back to the environment variable", func() {
+					Expect(err).To(BeNil())
+					Expect(readFile(htpasswd)).To(ContainSubstring("bob:$apr1$"))
+				})
+
+				Context("and there is no environment variable to fall back to", func() {
+					BeforeEach(func() {
+						basicAuth.Env = ""
+					})
+
+					It("refuses to start", func() {
+						Expect(err).To(MatchError("the site-users service is not bound to the app"))
+					})
+				})
+			})
+
+			Context("the service has no users", func() {
+				BeforeEach(func() {
+					env["VCAP_SERVICES"] = `{"user-provided": [{"name": "site-users", "credentials": {}}]}`
+				})
+
+				It("refuses to start", func() {
+					Expect(err).To(MatchError("no users are set in the credentials of the site-users service"))
+				})
+			})
+		})
+	})
+})
+
+type fakeProcess struct {
+	exit   chan error
+	signal func(os.Signal)
+}
+
+func (p *fakeProcess) Signal(sig os.Signal) error {
+	p.signal(sig)
+	return nil
+}
+
+func (p *fakeProcess) Wait() error {
+	return <-p.exit
+}
@@ -0,0 +1,51 @@
+package launch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ForwardLogCommand is the first argument that makes the launcher forward a log
+// instead of starting nginx.
+const ForwardLogCommand = "forward-log"
+
+// ForwardErrorLog replaces the error.log link with a FIFO, and starts the
+// launcher again to copy its lines to stderr with the instance index in front.
+// nginx cannot add the prefix itself.
+func (l *Launcher) ForwardErrorLog() error {
+	path := filepath.Join(l.AppRoot, "nginx", "logs", "error.log")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		return err
+	}
+
+	prefix := fmt.Sprintf("[instance %s] ", l.getenv("CF_INSTANCE_INDEX", "?"))
+	launcher := filepath.Join(l.AppRoot, ".staticfile", "launch")
+	return l.start("the error log forwarder", launcher, []string{"launch", ForwardLogCommand, path, prefix})
+}
+
+// ForwardLog copies the lines of the log at path to w with prefix in front. A
+// FIFO is opened for writing too, so that it does not reach the end when nginx
+// -t or a log reopen closes it, and the copy goes on for as long as the app runs.
+func ForwardLog(path, prefix string, w io.Writer) error {
+	log, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	scanner := bufio.NewScanner(log)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if _, err := fmt.Fprintf(w, "%s%s\n", prefix, scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
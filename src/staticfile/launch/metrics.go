@@ -0,0 +1,133 @@
+package launch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricsCommand is the first argument that makes the launcher serve the metrics
+// exporter instead of starting nginx.
+const MetricsCommand = "metrics"
+
+// MetricsSocket is where the exporter listens, and StatusSocket is where nginx
+// serves stub_status, both relative to the app root.
+const (
+	MetricsSocket = "nginx/metrics.sock"
+	StatusSocket  = "nginx/status.sock"
+)
+
+// StartMetrics starts the launcher again to serve the exporter next to nginx, and
+// waits until it listens. nginx proxies the metrics location to it.
+func (l *Launcher) StartMetrics() error {
+	socket := filepath.Join(l.AppRoot, MetricsSocket)
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	launcher := filepath.Join(l.AppRoot, ".staticfile", "launch")
+	if err := l.start("the metrics exporter", launcher, []string{"launch", MetricsCommand, filepath.Join(l.AppRoot, StatusSocket), socket}); err != nil {
+		return err
+	}
+	return l.waitForSocket("the metrics exporter", MetricsSocket)
+}
+
+// ServeMetrics serves the exporter on the socket at listen until it fails.
+func ServeMetrics(status, listen string) error {
+	listener, err := net.Listen("unix", listen)
+	if err != nil {
+		return err
+	}
+	return http.Serve(listener, NewMetricsHandler(status))
+}
+
+// NewMetricsHandler returns a handler that reads stub_status from the nginx
+// socket at status on every scrape, and writes it in the Prometheus text format.
+func NewMetricsHandler(status string) http.Handler {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", status)
+			},
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, err := readStubStatus(client)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read the nginx status: %s\n", err.Error())
+			writeMetric(w, "nginx_up", "gauge", "Whether the nginx status could be read.", 0)
+			return
+		}
+
+		writeMetric(w, "nginx_up", "gauge", "Whether the nginx status could be read.", 1)
+		writeMetric(w, "nginx_connections_active", "gauge", "Active client connections, including waiting ones.", stats.Active)
+		writeMetric(w, "nginx_connections_accepted", "counter", "Accepted client connections.", stats.Accepted)
+		writeMetric(w, "nginx_connections_handled", "counter", "Handled client connections.", stats.Handled)
+		writeMetric(w, "nginx_connections_reading", "gauge", "Connections where nginx is reading the request header.", stats.Reading)
+		writeMetric(w, "nginx_connections_writing", "gauge", "Connections where nginx is writing the response.", stats.Writing)
+		writeMetric(w, "nginx_connections_waiting", "gauge", "Idle client connections waiting for a request.", stats.Waiting)
+		writeMetric(w, "nginx_http_requests_total", "counter", "Client requests.", stats.Requests)
+	})
+}
+
+type StubStatus struct {
+	Active, Accepted, Handled, Requests, Reading, Writing, Waiting int64
+}
+
+func readStubStatus(client *http.Client) (StubStatus, error) {
+	resp, err := client.Get("http://nginx/stub_status")
+	if err != nil {
+		return StubStatus{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return StubStatus{}, fmt.Errorf("stub_status answered %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return StubStatus{}, err
+	}
+	return ParseStubStatus(string(body))
+}
+
+// ParseStubStatus reads the seven numbers of the stub_status page, which always
+// come in the same order:
+//
+//	Active connections: 2
+//	server accepts handled requests
+//	 10 10 25
+//	Reading: 0 Writing: 1 Waiting: 1
+func ParseStubStatus(body string) (StubStatus, error) {
+	var numbers []int64
+	for _, field := range strings.Fields(body) {
+		if number, err := strconv.ParseInt(field, 10, 64); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	if len(numbers) != 7 || !strings.HasPrefix(body, "Active connections:") {
+		return StubStatus{}, fmt.Errorf("unexpected stub_status output %q", body)
+	}
+	return StubStatus{
+		Active:   numbers[0],
+		Accepted: numbers[1],
+		Handled:  numbers[2],
+		Requests: numbers[3],
+		Reading:  numbers[4],
+		Writing:  numbers[5],
+		Waiting:  numbers[6],
+	}, nil
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
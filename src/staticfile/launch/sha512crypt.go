@@ -0,0 +1,114 @@
+package launch
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"strings"
+)
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const sha512CryptRounds = 5000
+
+// sha512CryptOrder is the order in which SHA-512 crypt encodes the bytes of the
+// final digest, three at a time.
+var sha512CryptOrder = [][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+	{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+	{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+}
+
+// hashPassword returns a SHA-512 crypt hash of password with a random salt. The
+// glibc crypt(3) of every stack can verify it, unlike bcrypt.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	for i, b := range salt {
+		salt[i] = cryptAlphabet[int(b)%len(cryptAlphabet)]
+	}
+	return sha512Crypt(password, string(salt)), nil
+}
+
+// sha512Crypt implements the $6$ scheme from
+// https://www.akkadia.org/drepper/SHA-crypt.txt with the default number of rounds.
+func sha512Crypt(password, salt string) string {
+	key, saltBytes := []byte(password), []byte(salt)
+
+	alternate := sha512.New()
+	alternate.Write(key)
+	alternate.Write(saltBytes)
+	alternate.Write(key)
+	alternateSum := alternate.Sum(nil)
+
+	digest := sha512.New()
+	digest.Write(key)
+	digest.Write(saltBytes)
+	digest.Write(repeatBytes(alternateSum, len(key)))
+	for i := len(key); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			digest.Write(alternateSum)
+		} else {
+			digest.Write(key)
+		}
+	}
+	sum := digest.Sum(nil)
+
+	keyDigest := sha512.New()
+	for range key {
+		keyDigest.Write(key)
+	}
+	keySequence := repeatBytes(keyDigest.Sum(nil), len(key))
+
+	saltDigest := sha512.New()
+	for i := 0; i < 16+int(sum[0]); i++ {
+		saltDigest.Write(saltBytes)
+	}
+	saltSequence := repeatBytes(saltDigest.Sum(nil), len(saltBytes))
+
+	for round := 0; round < sha512CryptRounds; round++ {
+		digest := sha512.New()
+		if round&1 != 0 {
+			digest.Write(keySequence)
+		} else {
+			digest.Write(sum)
+		}
+		if round%3 != 0 {
+			digest.Write(saltSequence)
+		}
+		if round%7 != 0 {
+			digest.Write(keySequence)
+		}
+		if round&1 != 0 {
+			digest.Write(sum)
+		} else {
+			digest.Write(keySequence)
+		}
+		sum = digest.Sum(nil)
+	}
+
+	var hash strings.Builder
+	hash.WriteString("$6$" + salt + "$")
+	for _, order := range sha512CryptOrder {
+		encodeCrypt(&hash, uint(sum[order[0]])<<16|uint(sum[order[1]])<<8|uint(sum[order[2]]), 4)
+	}
+	encodeCrypt(&hash, uint(sum[63]), 2)
+	return hash.String()
+}
+
+// repeatBytes repeats sum until it is length bytes long.
+func repeatBytes(sum []byte, length int) []byte {
+	sequence := make([]byte, 0, length)
+	for len(sequence) < length {
+		sequence = append(sequence, sum[:min(len(sum), length-len(sequence))]...)
+	}
+	return sequence
+}
+
+func encodeCrypt(hash *strings.Builder, value uint, chars int) {
+	for ; chars > 0; chars-- {
+		hash.WriteByte(cryptAlphabet[value&0x3f])
+		value >>= 6
+	}
+}
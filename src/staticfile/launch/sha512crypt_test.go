@@ -0,0 +1,16 @@
+package launch
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("sha512Crypt", func() {
+	It("matches the reference implementation", func() {
+		Expect(sha512Crypt("Hello world!", "saltstring")).To(Equal("$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"))
+	})
+
+	It("handles passwords longer than the digest", func() {
+		Expect(sha512Crypt("a very long password that exceeds sixty four bytes in length for sure yes", "0123456789abcdef")).To(Equal("$6$0123456789abcdef$hZT09egjyfgrLniWRvQWftM6r9VY1SXN8WDuCLHFxj8k7N7R3KeTz1PsuCORvjSVmG2iV5CF9QL66M59u/AmI."))
+	})
+})
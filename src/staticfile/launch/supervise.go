@@ -0,0 +1,102 @@
+package launch
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Process is a child that the launcher started and waits for.
+type Process interface {
+	Signal(os.Signal) error
+	Wait() error
+}
+
+type child struct {
+	name    string
+	process Process
+}
+
+type exit struct {
+	child
+	err error
+}
+
+// start runs a child next to nginx and keeps it, so that Supervise can wait for it.
+func (l *Launcher) start(name, path string, args []string) error {
+	process, err := l.Start(path, args, l.Environ())
+	if err != nil {
+		return err
+	}
+	l.children = append(l.children, child{name: name, process: process})
+	return nil
+}
+
+// Supervise waits until a child exits or the launcher is signalled, and then
+// stops the others. nginx is stopped first, so that the sidecars keep serving the
+// requests it finishes. A child that exits on its own is an error, which makes the
+// launcher exit and Cloud Foundry restart the app, rather than nginx going on
+// without its sso sidecar or exporter. With readiness, a signal removes the ready
+// file and nginx drains for l.Drain before it shuts down gracefully.
+func (l *Launcher) Supervise() error {
+	exited := make(chan exit, len(l.children))
+	running := map[string]Process{}
+	for _, c := range l.children {
+		running[c.name] = c.process
+		go func(c child) { exited <- exit{c, c.process.Wait()} }(c)
+	}
+
+	var result error
+	select {
+	case sig := <-l.Signals:
+		if l.ready != "" {
+			result = l.drain(exited, running)
+			sig = syscall.SIGQUIT
+		}
+		if nginx, ok := running["nginx"]; ok {
+			nginx.Signal(sig)
+		}
+	case e := <-exited:
+		delete(running, e.name)
+		result = e.failure()
+		if nginx, ok := running["nginx"]; ok {
+			nginx.Signal(syscall.SIGTERM)
+		}
+	}
+
+	stopping := false
+	for len(running) > 0 {
+		if _, ok := running["nginx"]; !ok && !stopping {
+			for _, process := range running {
+				process.Signal(syscall.SIGTERM)
+			}
+			stopping = true
+		}
+		e := <-exited
+		delete(running, e.name)
+	}
+	return result
+}
+
+// drain removes the ready file, and waits for l.Drain or until a child exits.
+func (l *Launcher) drain(exited <-chan exit, running map[string]Process) error {
+	if err := os.Remove(l.ready); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(l.Stderr, "Unable to remove %s: %s\n", ReadyFile, err.Error())
+	}
+
+	select {
+	case <-time.After(l.Drain):
+		return nil
+	case e := <-exited:
+		delete(running, e.name)
+		return e.failure()
+	}
+}
+
+func (e exit) failure() error {
+	if e.err == nil {
+		return fmt.Errorf("%s exited: exit status 0", e.name)
+	}
+	return fmt.Errorf("%s exited: %s", e.name, e.err.Error())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/launch"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/sso"
+)
+
+func main() {
+	configFile := flag.String("config", "", "the launch.json written by finalize")
+	socket := flag.String("socket", "", "the unix socket nginx connects to")
+	flag.Parse()
+
+	if err := run(*configFile, *socket); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to start single sign-on: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(configFile, socket string) error {
+	if configFile == "" || socket == "" {
+		return fmt.Errorf("usage: sso -config launch.json -socket path")
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var config launch.Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("unable to read %s: %w", configFile, err)
+	}
+	if config.SSO == nil {
+		return fmt.Errorf("%s does not enable sso", configFile)
+	}
+
+	ssoConfig, err := sso.LoadConfig(os.LookupEnv, *config.SSO)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: sso.NewServer(ssoConfig), ReadHeaderTimeout: 10 * time.Second}
+	return server.Serve(listener)
+}
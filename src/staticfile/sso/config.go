@@ -0,0 +1,133 @@
+package sso
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Settings come from the sso key of the Staticfile. The issuer and the client
+// are not part of the droplet, they are read from the environment or from a
+// bound service when the app starts.
+type Settings struct {
+	Service     string   `json:"service,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	GroupsClaim string   `json:"groups_claim,omitempty"`
+	Scopes      []string `json:"scopes,omitempty"`
+}
+
+type Config struct {
+	Settings
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	CookieSecret []byte
+	SessionTTL   time.Duration
+}
+
+const (
+	defaultGroupsClaim = "groups"
+	defaultSessionTTL  = 8 * time.Hour
+)
+
+var defaultScopes = []string{"openid", "email", "profile"}
+
+type credentials struct {
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	CookieSecret string `json:"cookie_secret"`
+}
+
+type vcapService struct {
+	Name        string      `json:"name"`
+	Credentials credentials `json:"credentials"`
+}
+
+// LoadConfig reads the issuer and the client from the credentials of the bound
+// service named in the settings, or from the SSO_ISSUER, SSO_CLIENT_ID,
+// SSO_CLIENT_SECRET and SSO_COOKIE_SECRET environment variables.
+func LoadConfig(env func(string) (string, bool), settings Settings) (*Config, error) {
+	creds, source, err := loadCredentials(env, settings.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for name, value := range map[string]string{"issuer": creds.Issuer, "client_id": creds.ClientID, "client_secret": creds.ClientSecret} {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%s does not set %s", source, strings.Join(missing, ", "))
+	}
+
+	issuer, err := url.Parse(creds.Issuer)
+	if err != nil || issuer.Host == "" || (issuer.Scheme != "https" && !(issuer.Scheme == "http" && isLoopback(issuer.Hostname()))) {
+		return nil, fmt.Errorf("the issuer %q in %s must be an https URL", creds.Issuer, source)
+	}
+
+	config := &Config{
+		Settings:     settings,
+		Issuer:       strings.TrimSuffix(creds.Issuer, "/"),
+		ClientID:     creds.ClientID,
+		ClientSecret: creds.ClientSecret,
+		CookieSecret: []byte(creds.CookieSecret),
+		SessionTTL:   defaultSessionTTL,
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = defaultGroupsClaim
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = defaultScopes
+	}
+	if len(config.CookieSecret) == 0 {
+		// Every instance of the app derives the same secret, so that sessions
+		// work whichever instance serves the request.
+		mac := hmac.New(sha256.New, []byte(creds.ClientSecret))
+		mac.Write([]byte("staticfile-sso-session"))
+		config.CookieSecret = mac.Sum(nil)
+	}
+	return config, nil
+}
+
+func loadCredentials(env func(string) (string, bool), service string) (credentials, string, error) {
+	if service == "" {
+		get := func(name string) string {
+			value, _ := env(name)
+			return value
+		}
+		return credentials{
+			Issuer:       get("SSO_ISSUER"),
+			ClientID:     get("SSO_CLIENT_ID"),
+			ClientSecret: get("SSO_CLIENT_SECRET"),
+			CookieSecret: get("SSO_COOKIE_SECRET"),
+		}, "the environment", nil
+	}
+
+	var services map[string][]vcapService
+	if vcapServices, ok := env("VCAP_SERVICES"); ok && vcapServices != "" {
+		if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
+			return credentials{}, "", fmt.Errorf("unable to read VCAP_SERVICES: %w", err)
+		}
+	}
+	for _, instances := range services {
+		for _, instance := range instances {
+			if instance.Name == service {
+				return instance.Credentials, fmt.Sprintf("the %s service", service), nil
+			}
+		}
+	}
+	return credentials{}, "", fmt.Errorf("the %s service is not bound to the app", service)
+}
+
+func isLoopback(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
@@ -0,0 +1,134 @@
+package sso_test
+
+import (
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/sso"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadConfig", func() {
+	var (
+		env      map[string]string
+		settings sso.Settings
+		config   *sso.Config
+		err      error
+	)
+
+	BeforeEach(func() {
+		env = map[string]string{
+			"SSO_ISSUER":        "https://login.example.com/",
+			"SSO_CLIENT_ID":     "portal",
+			"SSO_CLIENT_SECRET": "s3cret",
+		}
+		settings = sso.Settings{}
+	})
+
+	JustBeforeEach(func() {
+		config, err = sso.LoadConfig(func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		}, settings)
+	})
+
+	It("reads the issuer and the client from the environment", func() {
+		Expect(err).To(BeNil())
+		Expect(config.Issuer).To(Equal("https://login.example.com"))
+		Expect(config.ClientID).To(Equal("portal"))
+		Expect(config.ClientSecret).To(Equal("s3cret"))
+	})
+
+	It("defaults the scopes and the groups claim", func() {
+		Expect(err).To(BeNil())
+		Expect(config.Scopes).To(Equal([]string{"openid", "email", "profile"}))
+		Expect(config.GroupsClaim).To(Equal("groups"))
+	})
+
+	It("derives the same cookie secret from the client secret on every instance", func() {
+		Expect(err).To(BeNil())
+		Expect(config.CookieSecret).To(HaveLen(32))
+
+		again, err := sso.LoadConfig(func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		}, settings)
+		Expect(err).To(BeNil())
+		Expect(again.CookieSecret).To(Equal(config.CookieSecret))
+	})
+
+	Context("SSO_COOKIE_SECRET is set", func() {
+		BeforeEach(func() {
+			env["SSO_COOKIE_SECRET"] = "0123456789abcdef0123456789abcdef"
+		})
+
+		It("uses it", func() {
+			Expect(err).To(BeNil())
+			Expect(string(config.CookieSecret)).To(Equal("0123456789abcdef0123456789abcdef"))
+		})
+	})
+
+	Context("the client is not set", func() {
+		BeforeEach(func() {
+			delete(env, "SSO_CLIENT_ID")
+			delete(env, "SSO_CLIENT_SECRET")
+		})
+
+		It("names the missing settings", func() {
+			Expect(err).To(MatchError("the environment does not set client_id, client_secret"))
+		})
+	})
+
+	Context("the issuer is not https", func() {
+		BeforeEach(func() {
+			env["SSO_ISSUER"] = "http://login.example.com"
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(`the issuer "http://login.example.com" in the environment must be an https URL`))
+		})
+	})
+
+	Context("the issuer is a local http URL", func() {
+		BeforeEach(func() {
+			env["SSO_ISSUER"] = "http://127.0.0.1:8080"
+		})
+
+		It("accepts it, for testing against a local issuer", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("the settings name a service", func() {
+		BeforeEach(func() {
+			settings = sso.Settings{Service: "corp-sso", Scopes: []string{"openid", "groups"}}
+			env["VCAP_SERVICES"] = `{"user-provided": [{"name": "corp-sso", "credentials": {"issuer": "https://sso.example.com", "client_id": "static", "client_secret": "t0p"}}]}`
+		})
+
+		It("reads the credentials of the service instead of the environment", func() {
+			Expect(err).To(BeNil())
+			Expect(config.Issuer).To(Equal("https://sso.example.com"))
+			Expect(config.ClientID).To(Equal("static"))
+			Expect(config.Scopes).To(Equal([]string{"openid", "groups"}))
+		})
+
+		Context("the service is not bound", func() {
+			BeforeEach(func() {
+				env["VCAP_SERVICES"] = `{}`
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError("the corp-sso service is not bound to the app"))
+			})
+		})
+
+		Context("the service does not set the issuer", func() {
+			BeforeEach(func() {
+				env["VCAP_SERVICES"] = `{"user-provided": [{"name": "corp-sso", "credentials": {"client_id": "static", "client_secret": "t0p"}}]}`
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError("the corp-sso service does not set issuer"))
+			})
+		})
+	})
+})
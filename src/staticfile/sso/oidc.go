@@ -0,0 +1,303 @@
+package sso
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// clockSkew is how far the clocks of the app and the issuer may drift apart.
+const clockSkew = time.Minute
+
+type provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	keys map[string]crypto.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// discover reads the endpoints of the issuer the first time they are needed, so
+// that the app starts even when the issuer is briefly unreachable.
+func (s *Server) discover(ctx context.Context) (*provider, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.provider != nil {
+		return s.provider, nil
+	}
+
+	var p provider
+	if err := s.getJSON(ctx, s.Config.Issuer+"/.well-known/openid-configuration", &p); err != nil {
+		return nil, fmt.Errorf("unable to discover the issuer: %w", err)
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != s.Config.Issuer {
+		return nil, fmt.Errorf("the discovery document is for the issuer %q, not %q", p.Issuer, s.Config.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("the discovery document does not list the authorization, token and jwks endpoints")
+	}
+	s.provider = &p
+	return s.provider, nil
+}
+
+// key returns the key the ID token was signed with. The keys are read again when
+// the key ID is unknown, as issuers rotate their keys.
+func (s *Server) key(ctx context.Context, p *provider, kid string) (crypto.PublicKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := s.getJSON(ctx, p.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("unable to read the signing keys of the issuer: %w", err)
+	}
+	p.keys = map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			p.keys[k.Kid] = key
+		}
+	}
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the issuer has no signing key %q", kid)
+}
+
+// lookupKey falls back to the only key of the issuer when the token does not
+// name one.
+func (p *provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifyIDToken checks the signature and the claims of an ID token, and returns
+// the claims.
+func (s *Server) verifyIDToken(ctx context.Context, p *provider, token, nonce string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("the ID token is not a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid ID token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token signature: %w", err)
+	}
+
+	key, err := s.key(ctx, p, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return nil, errors.New("the ID token signature is invalid")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 ||
+			!ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			return nil, errors.New("the ID token signature is invalid")
+		}
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+
+	now := s.Now()
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != s.Config.Issuer {
+		return nil, fmt.Errorf("the ID token was issued by %q", iss)
+	}
+	if !containsString(claims["aud"], s.Config.ClientID) {
+		return nil, errors.New("the ID token is not for this client")
+	}
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, errors.New("the ID token has expired")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("the ID token nonce does not match the login")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("the ID token has no subject")
+	}
+	return claims, nil
+}
+
+// exchange trades the authorization code for tokens, authenticating the client
+// with HTTP basic authentication.
+func (s *Server) exchange(ctx context.Context, p *provider, code, verifier, redirectURI string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(s.Config.ClientID), url.QueryEscape(s.Config.ClientSecret))
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil && response.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if response.StatusCode != http.StatusOK || tokens.Error != "" {
+		return "", fmt.Errorf("the token endpoint returned %d %s %s", response.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("the token response has no ID token, is the openid scope requested?")
+	}
+	return tokens.IDToken, nil
+}
+
+func (s *Server) getJSON(ctx context.Context, location string, value any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", location, response.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(value)
+}
+
+func decodeSegment(segment string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// containsString reports whether a claim that is a string or a list of strings
+// contains value.
+func containsString(claim any, value string) bool {
+	for _, s := range claimStrings(claim) {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+func claimStrings(claim any) []string {
+	switch claim := claim.(type) {
+	case string:
+		return []string{claim}
+	case []any:
+		var values []string
+		for _, item := range claim {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
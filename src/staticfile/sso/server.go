@@ -0,0 +1,247 @@
+package sso
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix is the path nginx hands to the sidecar. The auth location is internal,
+// the others are visited by the browser during a login.
+const Prefix = "/_sso/"
+
+// loginTimeout is how long a user has to log in at the issuer.
+const loginTimeout = 10 * time.Minute
+
+// Server answers the nginx auth_request subrequests, and runs the OpenID Connect
+// authorization code flow when a request has no valid session.
+type Server struct {
+	Config *Config
+	Client *http.Client
+	Now    func() time.Time
+	Log    io.Writer
+
+	mutex    sync.Mutex
+	provider *provider
+	mux      *http.ServeMux
+}
+
+func NewServer(config *Config) *Server {
+	s := &Server{
+		Config: config,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		Now:    time.Now,
+		Log:    os.Stderr,
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc(Prefix+"auth", s.auth)
+	s.mux.HandleFunc(Prefix+"login", s.login)
+	s.mux.HandleFunc(Prefix+"callback", s.callback)
+	s.mux.HandleFunc(Prefix+"logout", s.logout)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	s.mux.ServeHTTP(w, r)
+}
+
+// auth answers 200 for a valid session of an allowed user, which nginx turns into
+// the requested file. 401 sends the browser to the login, 403 is final.
+func (s *Server) auth(w http.ResponseWriter, r *http.Request) {
+	var sess session
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || open(s.Config.CookieSecret, sessionCookie, cookie.Value, &sess) != nil || s.Now().Unix() >= sess.Expiry {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if !s.allowed(sess.Groups) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("X-Auth-Request-User", sess.Subject)
+	if sess.Email != "" {
+		w.Header().Set("X-Auth-Request-Email", sess.Email)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// login redirects to the issuer. nginx passes the URI the user asked for in
+// X-Original-URI, a link to the login page can pass it as rd instead.
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	p, err := s.discover(r.Context())
+	if err != nil {
+		s.fail(w, http.StatusBadGateway, err)
+		return
+	}
+
+	redirect := r.Header.Get("X-Original-URI")
+	if redirect == "" {
+		redirect = r.URL.Query().Get("rd")
+	}
+
+	state := loginState{Redirect: safeRedirect(redirect), Expiry: s.Now().Add(loginTimeout).Unix()}
+	for _, value := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		if *value, err = randomString(); err != nil {
+			s.fail(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	sealed, err := seal(s.Config.CookieSecret, stateCookie, state)
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	http.SetCookie(w, s.cookie(r, stateCookie, sealed, Prefix, loginTimeout))
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.Config.ClientID},
+		"redirect_uri":          {s.redirectURI(r)},
+		"scope":                 {strings.Join(s.Config.Scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, p.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+func (s *Server) callback(w http.ResponseWriter, r *http.Request) {
+	var state loginState
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || open(s.Config.CookieSecret, stateCookie, cookie.Value, &state) != nil || s.Now().Unix() >= state.Expiry {
+		s.fail(w, http.StatusBadRequest, fmt.Errorf("the login has expired, or was started in another browser"))
+		return
+	}
+	http.SetCookie(w, s.cookie(r, stateCookie, "", Prefix, -1))
+
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+		s.fail(w, http.StatusBadRequest, fmt.Errorf("the login state does not match"))
+		return
+	}
+	if query.Get("error") != "" {
+		s.fail(w, http.StatusForbidden, fmt.Errorf("the issuer refused the login: %s %s", query.Get("error"), query.Get("error_description")))
+		return
+	}
+
+	p, err := s.discover(r.Context())
+	if err != nil {
+		s.fail(w, http.StatusBadGateway, err)
+		return
+	}
+	idToken, err := s.exchange(r.Context(), p, query.Get("code"), state.Verifier, s.redirectURI(r))
+	if err != nil {
+		s.fail(w, http.StatusBadGateway, err)
+		return
+	}
+	claims, err := s.verifyIDToken(r.Context(), p, idToken, state.Nonce)
+	if err != nil {
+		s.fail(w, http.StatusForbidden, err)
+		return
+	}
+
+	sess := session{Groups: claimStrings(claims[s.Config.GroupsClaim]), Expiry: s.Now().Add(s.Config.SessionTTL).Unix()}
+	sess.Subject, _ = claims["sub"].(string)
+	sess.Email, _ = claims["email"].(string)
+	if !s.allowed(sess.Groups) {
+		s.fail(w, http.StatusForbidden, fmt.Errorf("%s is not a member of an allowed group", sess.Subject))
+		return
+	}
+	if len(s.Config.Groups) > 0 {
+		// Only the groups that grant access are kept, so that the cookie stays
+		// small for users in many groups.
+		sess.Groups = slices.DeleteFunc(sess.Groups, func(group string) bool { return !slices.Contains(s.Config.Groups, group) })
+	}
+
+	sealed, err := seal(s.Config.CookieSecret, sessionCookie, sess)
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	http.SetCookie(w, s.cookie(r, sessionCookie, sealed, "/", s.Config.SessionTTL))
+	http.Redirect(w, r, state.Redirect, http.StatusFound)
+}
+
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, s.cookie(r, sessionCookie, "", "/", -1))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// allowed reports whether a user in groups may see the site. Every user the
+// issuer authenticates may, unless the Staticfile lists groups.
+func (s *Server) allowed(groups []string) bool {
+	if len(s.Config.Groups) == 0 {
+		return true
+	}
+	for _, group := range groups {
+		if slices.Contains(s.Config.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) fail(w http.ResponseWriter, status int, err error) {
+	fmt.Fprintf(s.Log, "sso: %s\n", err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (s *Server) cookie(r *http.Request, name, value, path string, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   proto(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(maxAge.Seconds())
+	}
+	return cookie
+}
+
+// redirectURI is the callback as the browser sees it, which nginx passes in the
+// X-Forwarded headers.
+func (s *Server) redirectURI(r *http.Request) string {
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+	return proto(r) + "://" + host + Prefix + "callback"
+}
+
+func proto(r *http.Request) string {
+	if proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ","); proto != "" {
+		return strings.TrimSpace(proto)
+	}
+	return "http"
+}
+
+// safeRedirect only returns to paths of the app, so that the login cannot be used
+// to send users to another site.
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") || strings.HasPrefix(redirect, Prefix) {
+		return "/"
+	}
+	return redirect
+}
@@ -0,0 +1,352 @@
+package sso_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/sso"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// mockProvider is an OpenID Connect issuer that logs in whoever asks, with the
+// claims set by the test.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+
+	// challenge and nonce are remembered from the authorization request.
+	challenge string
+	nonce     string
+}
+
+func newMockProvider() *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).To(BeNil())
+
+	p := &mockProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || id != "portal" || secret != "s3cret" || r.FormValue("code") != "the-code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]any{"nonce": p.nonce}
+		for name, value := range p.claims {
+			claims[name] = value
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "id_token": p.sign(claims)})
+	})
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *mockProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	Expect(err).To(BeNil())
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+var _ = Describe("Server", func() {
+	var (
+		provider *mockProvider
+		config   *sso.Config
+		server   *sso.Server
+		log      *bytes.Buffer
+	)
+
+	request := func(method, target string, headers map[string]string, cookies ...*http.Cookie) *http.Response {
+		r := httptest.NewRequest(method, target, nil)
+		r.Host = "portal.example.com"
+		r.Header.Set("X-Forwarded-Proto", "https")
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	cookie := func(response *http.Response, name string) *http.Cookie {
+		for _, cookie := range response.Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	// login follows the flow nginx starts when the auth subrequest fails, and
+	// returns the response to the callback.
+	login := func() *http.Response {
+		response := request("GET", "/_sso/login", map[string]string{"X-Original-URI": "/reports/q3.html?year=2024"})
+		Expect(response.StatusCode).To(Equal(http.StatusFound))
+
+		location, err := url.Parse(response.Header.Get("Location"))
+		Expect(err).To(BeNil())
+		Expect(location.String()).To(HavePrefix(provider.server.URL + "/authorize?"))
+		query := location.Query()
+		provider.challenge, provider.nonce = query.Get("code_challenge"), query.Get("nonce")
+
+		return request("GET", "/_sso/callback?code=the-code&state="+url.QueryEscape(query.Get("state")), nil, cookie(response, "_staticfile_sso_state"))
+	}
+
+	BeforeEach(func() {
+		provider = newMockProvider()
+		DeferCleanup(provider.server.Close)
+		provider.claims = map[string]any{
+			"iss":    provider.server.URL,
+			"aud":    "portal",
+			"sub":    "alice",
+			"email":  "alice@example.com",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"groups": []string{"everyone", "staff"},
+		}
+
+		var err error
+		config, err = sso.LoadConfig(func(name string) (string, bool) {
+			value, ok := map[string]string{
+				"SSO_ISSUER":        provider.server.URL,
+				"SSO_CLIENT_ID":     "portal",
+				"SSO_CLIENT_SECRET": "s3cret",
+			}[name]
+			return value, ok
+		}, sso.Settings{Groups: []string{"staff"}})
+		Expect(err).To(BeNil())
+
+		log = new(bytes.Buffer)
+	})
+
+	JustBeforeEach(func() {
+		server = sso.NewServer(config)
+		server.Log = log
+	})
+
+	Describe("auth", func() {
+		It("rejects requests without a session", func() {
+			Expect(request("GET", "/_sso/auth", nil).StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects a forged session", func() {
+			forged := &http.Cookie{Name: "_staticfile_sso", Value: "eyJzdWIiOiJtYWxsb3J5IiwiZXhwIjo0MTAyNDQ0ODAwfQ.c2lnbmF0dXJl"}
+			Expect(request("GET", "/_sso/auth", nil, forged).StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("accepts the session set by a login", func() {
+			session := cookie(login(), "_staticfile_sso")
+			Expect(session).NotTo(BeNil())
+
+			response := request("GET", "/_sso/auth", nil, session)
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			Expect(response.Header.Get("X-Auth-Request-User")).To(Equal("alice"))
+			Expect(response.Header.Get("X-Auth-Request-Email")).To(Equal("alice@example.com"))
+		})
+
+		It("rejects the session once it expires", func() {
+			session := cookie(login(), "_staticfile_sso")
+			server.Now = func() time.Time { return time.Now().Add(9 * time.Hour) }
+			Expect(request("GET", "/_sso/auth", nil, session).StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Describe("login", func() {
+		It("sends the browser to the issuer with PKCE", func() {
+			response := request("GET", "/_sso/login", nil)
+			Expect(response.StatusCode).To(Equal(http.StatusFound))
+
+			location, err := url.Parse(response.Header.Get("Location"))
+			Expect(err).To(BeNil())
+			query := location.Query()
+			Expect(query.Get("response_type")).To(Equal("code"))
+			Expect(query.Get("client_id")).To(Equal("portal"))
+			Expect(query.Get("redirect_uri")).To(Equal("https://portal.example.com/_sso/callback"))
+			Expect(query.Get("scope")).To(Equal("openid email profile"))
+			Expect(query.Get("code_challenge_method")).To(Equal("S256"))
+			Expect(query.Get("state")).NotTo(BeEmpty())
+			Expect(query.Get("nonce")).NotTo(BeEmpty())
+
+			state := cookie(response, "_staticfile_sso_state")
+			Expect(state).NotTo(BeNil())
+			Expect(state.HttpOnly).To(BeTrue())
+			Expect(state.Secure).To(BeTrue())
+			Expect(state.Path).To(Equal("/_sso/"))
+		})
+
+		It("uses the host the browser sees", func() {
+			response := request("GET", "/_sso/login", map[string]string{"X-Forwarded-Host": "docs.example.com"})
+			location, err := url.Parse(response.Header.Get("Location"))
+			Expect(err).To(BeNil())
+			Expect(location.Query().Get("redirect_uri")).To(Equal("https://docs.example.com/_sso/callback"))
+		})
+
+		Context("the issuer is unreachable", func() {
+			BeforeEach(func() {
+				provider.server.Close()
+			})
+
+			It("returns a bad gateway and logs why", func() {
+				Expect(request("GET", "/_sso/login", nil).StatusCode).To(Equal(http.StatusBadGateway))
+				Expect(log.String()).To(ContainSubstring("sso: unable to discover the issuer"))
+			})
+		})
+	})
+
+	Describe("callback", func() {
+		It("returns to the page that was requested", func() {
+			response := login()
+			Expect(response.StatusCode).To(Equal(http.StatusFound))
+			Expect(response.Header.Get("Location")).To(Equal("/reports/q3.html?year=2024"))
+
+			session := cookie(response, "_staticfile_sso")
+			Expect(session.HttpOnly).To(BeTrue())
+			Expect(session.Secure).To(BeTrue())
+			Expect(session.SameSite).To(Equal(http.SameSiteLaxMode))
+		})
+
+		Context("the user is not in an allowed group", func() {
+			BeforeEach(func() {
+				provider.claims["groups"] = []string{"everyone"}
+			})
+
+			It("refuses the login", func() {
+				response := login()
+				Expect(response.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(cookie(response, "_staticfile_sso")).To(BeNil())
+				Expect(log.String()).To(ContainSubstring("alice is not a member of an allowed group"))
+			})
+		})
+
+		Context("the groups claim is a single string", func() {
+			BeforeEach(func() {
+				provider.claims["groups"] = "staff"
+			})
+
+			It("accepts the login", func() {
+				Expect(login().StatusCode).To(Equal(http.StatusFound))
+			})
+		})
+
+		Context("the ID token is for another client", func() {
+			BeforeEach(func() {
+				provider.claims["aud"] = []string{"other"}
+			})
+
+			It("refuses the login", func() {
+				Expect(login().StatusCode).To(Equal(http.StatusForbidden))
+				Expect(log.String()).To(ContainSubstring("the ID token is not for this client"))
+			})
+		})
+
+		Context("the ID token has expired", func() {
+			BeforeEach(func() {
+				provider.claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			})
+
+			It("refuses the login", func() {
+				Expect(login().StatusCode).To(Equal(http.StatusForbidden))
+				Expect(log.String()).To(ContainSubstring("the ID token has expired"))
+			})
+		})
+
+		Context("the ID token was issued by another issuer", func() {
+			BeforeEach(func() {
+				provider.claims["iss"] = "https://evil.example.com"
+			})
+
+			It("refuses the login", func() {
+				Expect(login().StatusCode).To(Equal(http.StatusForbidden))
+			})
+		})
+
+		It("refuses a callback without the state cookie", func() {
+			response := request("GET", "/_sso/callback?code=the-code&state=guess", nil)
+			Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		It("refuses a callback whose state does not match", func() {
+			response := request("GET", "/_sso/login", nil)
+			callback := request("GET", "/_sso/callback?code=the-code&state=guess", nil, cookie(response, "_staticfile_sso_state"))
+			Expect(callback.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		It("reports an error from the issuer", func() {
+			response := request("GET", "/_sso/login", nil)
+			location, _ := url.Parse(response.Header.Get("Location"))
+			callback := request("GET", "/_sso/callback?error=access_denied&state="+url.QueryEscape(location.Query().Get("state")), nil, cookie(response, "_staticfile_sso_state"))
+			Expect(callback.StatusCode).To(Equal(http.StatusForbidden))
+			Expect(log.String()).To(ContainSubstring("the issuer refused the login: access_denied"))
+		})
+	})
+
+	Describe("logout", func() {
+		It("clears the session", func() {
+			response := request("GET", "/_sso/logout", nil)
+			Expect(response.StatusCode).To(Equal(http.StatusFound))
+			Expect(cookie(response, "_staticfile_sso").MaxAge).To(Equal(-1))
+		})
+	})
+
+	DescribeTable("only returns to paths of the app",
+		func(redirect, expected string) {
+			response := request("GET", "/_sso/login?rd="+url.QueryEscape(redirect), nil)
+			location, _ := url.Parse(response.Header.Get("Location"))
+			query := location.Query()
+			provider.challenge, provider.nonce = query.Get("code_challenge"), query.Get("nonce")
+			callback := request("GET", "/_sso/callback?code=the-code&state="+url.QueryEscape(query.Get("state")), nil, cookie(response, "_staticfile_sso_state"))
+			Expect(callback.Header.Get("Location")).To(Equal(expected))
+		},
+		Entry("a path", "/docs/", "/docs/"),
+		Entry("another site", "https://evil.example.com/", "/"),
+		Entry("a protocol relative URL", "//evil.example.com/", "/"),
+		Entry("a backslash", `/\evil.example.com/`, "/"),
+		Entry("the login itself", "/_sso/login", "/"),
+	)
+
+	It("does not let caches keep its responses", func() {
+		Expect(request("GET", "/_sso/auth", nil).Header.Get("Cache-Control")).To(Equal("no-store"))
+	})
+
+	It("does not answer outside its prefix", func() {
+		Expect(request("GET", "/index.html", nil).StatusCode).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,75 @@
+package sso
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	sessionCookie = "_staticfile_sso"
+	stateCookie   = "_staticfile_sso_state"
+)
+
+type session struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Expiry  int64    `json:"exp"`
+}
+
+// loginState lives in a cookie between the redirect to the issuer and the
+// callback, so that any instance of the app can complete the login.
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"rd"`
+	Expiry   int64  `json:"exp"`
+}
+
+var errInvalidCookie = errors.New("invalid cookie")
+
+// seal encodes value as JSON and signs it. The purpose is part of the signature,
+// so that a login state cannot be passed off as a session.
+func seal(secret []byte, purpose string, value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + sign(secret, purpose, payload), nil
+}
+
+func open(secret []byte, purpose, sealed string, value any) error {
+	payload, signature, found := strings.Cut(sealed, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(sign(secret, purpose, payload))) {
+		return errInvalidCookie
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errInvalidCookie
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return errInvalidCookie
+	}
+	return nil
+}
+
+func sign(secret []byte, purpose, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose + "." + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomString() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
@@ -58,7 +58,10 @@ func main() {
 		Stager:    stager,
 		Manifest:  manifest,
 		Installer: installer,
+		YAML:      libbuildpack.NewYAML(),
 		Log:       logger,
+		Getenv:    os.Getenv,
+		Now:       time.Now,
 	}
 
 	err = supply.Run(&ss)
@@ -66,7 +69,7 @@ func main() {
 		os.Exit(14)
 	}
 
-	if err := stager.WriteConfigYml(nil); err != nil {
+	if err := stager.WriteConfigYml(ss.Config); err != nil {
 		logger.Error("Error writing config.yml: %s", err.Error())
 		os.Exit(15)
 	}
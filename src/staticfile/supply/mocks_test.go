@@ -34,6 +34,20 @@ func (m *MockManifest) EXPECT() *MockManifestMockRecorder {
 	return m.recorder
 }
 
+// AllDependencyVersions mocks base method.
+func (m *MockManifest) AllDependencyVersions(arg0 string) []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AllDependencyVersions", arg0)
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// AllDependencyVersions indicates an expected call of AllDependencyVersions.
+func (mr *MockManifestMockRecorder) AllDependencyVersions(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllDependencyVersions", reflect.TypeOf((*MockManifest)(nil).AllDependencyVersions), arg0)
+}
+
 // DefaultVersion mocks base method.
 func (m *MockManifest) DefaultVersion(arg0 string) (libbuildpack.Dependency, error) {
 	m.ctrl.T.Helper()
@@ -49,6 +63,35 @@ func (mr *MockManifestMockRecorder) DefaultVersion(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DefaultVersion", reflect.TypeOf((*MockManifest)(nil).DefaultVersion), arg0)
 }
 
+// GetEntry mocks base method.
+func (m *MockManifest) GetEntry(arg0 libbuildpack.Dependency) (*libbuildpack.ManifestEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEntry", arg0)
+	ret0, _ := ret[0].(*libbuildpack.ManifestEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEntry indicates an expected call of GetEntry.
+func (mr *MockManifestMockRecorder) GetEntry(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEntry", reflect.TypeOf((*MockManifest)(nil).GetEntry), arg0)
+}
+
+// RootDir mocks base method.
+func (m *MockManifest) RootDir() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RootDir")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// RootDir indicates an expected call of RootDir.
+func (mr *MockManifestMockRecorder) RootDir() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RootDir", reflect.TypeOf((*MockManifest)(nil).RootDir))
+}
+
 // MockInstaller is a mock of Installer interface.
 type MockInstaller struct {
 	ctrl     *gomock.Controller
@@ -123,6 +166,20 @@ func (mr *MockStagerMockRecorder) AddBinDependencyLink(arg0, arg1 interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddBinDependencyLink", reflect.TypeOf((*MockStager)(nil).AddBinDependencyLink), arg0, arg1)
 }
 
+// BuildDir mocks base method.
+func (m *MockStager) BuildDir() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BuildDir")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// BuildDir indicates an expected call of BuildDir.
+func (mr *MockStagerMockRecorder) BuildDir() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BuildDir", reflect.TypeOf((*MockStager)(nil).BuildDir))
+}
+
 // DepDir mocks base method.
 func (m *MockStager) DepDir() string {
 	m.ctrl.T.Helper()
@@ -136,3 +193,40 @@ func (mr *MockStagerMockRecorder) DepDir() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DepDir", reflect.TypeOf((*MockStager)(nil).DepDir))
 }
+
+// MockYAML is a mock of YAML interface.
+type MockYAML struct {
+	ctrl     *gomock.Controller
+	recorder *MockYAMLMockRecorder
+}
+
+// MockYAMLMockRecorder is the mock recorder for MockYAML.
+type MockYAMLMockRecorder struct {
+	mock *MockYAML
+}
+
+// NewMockYAML creates a new mock instance.
+func NewMockYAML(ctrl *gomock.Controller) *MockYAML {
+	mock := &MockYAML{ctrl: ctrl}
+	mock.recorder = &MockYAMLMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockYAML) EXPECT() *MockYAMLMockRecorder {
+	return m.recorder
+}
+
+// Load mocks base method.
+func (m *MockYAML) Load(arg0 string, arg1 interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Load", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Load indicates an expected call of Load.
+func (mr *MockYAMLMockRecorder) Load(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Load", reflect.TypeOf((*MockYAML)(nil).Load), arg0, arg1)
+}
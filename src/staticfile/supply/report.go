@@ -0,0 +1,111 @@
+package supply
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// ReportFile is where supply describes the dependencies it installed, relative
+// to the dep dir, which is part of the droplet.
+const ReportFile = "staticfile/dependencies.json"
+
+type Report struct {
+	Dependencies []DependencyReport `json:"dependencies"`
+}
+
+type DependencyReport struct {
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	URI          string   `json:"uri"`
+	SHA256       string   `json:"sha256"`
+	Source       string   `json:"source,omitempty"`
+	SourceSHA256 string   `json:"source_sha256,omitempty"`
+	CFStacks     []string `json:"cf_stacks"`
+	EOLDate      string   `json:"eol_date,omitempty"`
+	EOL          bool     `json:"eol"`
+}
+
+// manifestFile has the parts of manifest.yml that libbuildpack does not read.
+type manifestFile struct {
+	Dependencies []struct {
+		Name         string   `yaml:"name"`
+		Version      string   `yaml:"version"`
+		Source       string   `yaml:"source"`
+		SourceSHA256 string   `yaml:"source_sha256"`
+		CFStacks     []string `yaml:"cf_stacks"`
+	} `yaml:"dependencies"`
+	Deprecations []libbuildpack.DeprecationDate `yaml:"dependency_deprecation_dates"`
+}
+
+// WriteDependencyReport writes the installed nginx, as the manifest describes
+// it, to ReportFile and summarises it. With BP_FAIL_ON_EOL=true, an nginx past
+// its end of life fails staging.
+func (ss *Supplier) WriteDependencyReport() error {
+	dep := libbuildpack.Dependency{Name: "nginx", Version: ss.Config.Nginx.Version}
+	entry, err := ss.Manifest.GetEntry(dep)
+	if err != nil {
+		return err
+	}
+
+	var manifest manifestFile
+	if err := ss.YAML.Load(filepath.Join(ss.Manifest.RootDir(), "manifest.yml"), &manifest); err != nil {
+		return err
+	}
+
+	report := DependencyReport{
+		Name:     dep.Name,
+		Version:  dep.Version,
+		URI:      entry.URI,
+		SHA256:   entry.SHA256,
+		CFStacks: entry.CFStacks,
+	}
+	for _, d := range manifest.Dependencies {
+		if d.Name == dep.Name && d.Version == dep.Version && slices.Equal(d.CFStacks, entry.CFStacks) {
+			report.Source, report.SourceSHA256 = d.Source, d.SourceSHA256
+		}
+	}
+	for _, deprecation := range manifest.Deprecations {
+		if deprecation.Name != dep.Name {
+			continue
+		}
+		if _, err := libbuildpack.FindMatchingVersion(deprecation.VersionLine, []string{dep.Version}); err != nil {
+			continue
+		}
+		eolDate, err := time.Parse("2006-01-02", deprecation.Date)
+		if err != nil {
+			return fmt.Errorf("invalid end of life date %q for %s %s in the manifest", deprecation.Date, dep.Name, deprecation.VersionLine)
+		}
+		report.EOLDate = deprecation.Date
+		report.EOL = !ss.Now().Before(eolDate)
+	}
+
+	data, err := json.MarshalIndent(Report{Dependencies: []DependencyReport{report}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(ss.Stager.DepDir(), filepath.FromSlash(ReportFile))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	ss.Log.BeginStep("Writing the dependency report to %s", ReportFile)
+	ss.Log.Info("nginx %s, sha256 %s", report.Version, report.SHA256)
+	switch {
+	case report.EOL && ss.Getenv("BP_FAIL_ON_EOL") == "true":
+		return fmt.Errorf("nginx %s reached its end of life on %s, and BP_FAIL_ON_EOL is set", report.Version, report.EOLDate)
+	case report.EOL:
+		ss.Log.Warning("nginx %s reached its end of life on %s", report.Version, report.EOLDate)
+	case report.EOLDate != "":
+		ss.Log.Info("nginx %s reaches its end of life on %s", report.Version, report.EOLDate)
+	}
+	return nil
+}
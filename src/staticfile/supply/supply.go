@@ -1,13 +1,23 @@
 package supply
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/detect"
+	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/finalize"
 )
 
 type Manifest interface {
+	AllDependencyVersions(string) []string
 	DefaultVersion(string) (libbuildpack.Dependency, error)
+	GetEntry(libbuildpack.Dependency) (*libbuildpack.ManifestEntry, error)
+	RootDir() string
 }
 
 type Installer interface {
@@ -16,14 +26,50 @@ type Installer interface {
 
 type Stager interface {
 	AddBinDependencyLink(string, string) error
+	BuildDir() string
 	DepDir() string
 }
 
+type YAML interface {
+	Load(string, interface{}) error
+}
+
 type Supplier struct {
 	Stager    Stager
 	Manifest  Manifest
 	Installer Installer
+	YAML      YAML
 	Log       *libbuildpack.Logger
+	Getenv    func(string) string
+	Now       func() time.Time
+	Config    Config
+}
+
+// Config is what supply writes to the config.yml of its dep dir, for the
+// buildpacks that run after it. Paths are relative to the dep dir.
+type Config struct {
+	Nginx NginxConfig `yaml:"nginx"`
+}
+
+type NginxConfig struct {
+	// Path is the nginx binary, which is also linked into the bin directory.
+	Path    string `yaml:"path"`
+	Version string `yaml:"version"`
+	// ConfDir has the nginx.conf that finalize writes for an empty Staticfile,
+	// and mime.types. The nginx.conf serves ((APP_ROOT))/public on ((PORT)), see
+	// the README for the placeholders to fill in.
+	ConfDir string `yaml:"conf_dir"`
+	// PrefixConf, in ConfDir, is the same nginx.conf serving public under the
+	// path in ((LOCATION_PREFIX)) instead of at /.
+	PrefixConf string `yaml:"prefix_conf"`
+}
+
+const prefixConfFile = "nginx.prefix.conf"
+
+// staticfile holds the keys of the Staticfile that supply needs. finalize reads
+// the rest.
+type staticfile struct {
+	NginxVersion string `yaml:"nginx_version"`
 }
 
 func Run(ss *Supplier) error {
@@ -32,13 +78,23 @@ func Run(ss *Supplier) error {
 		return err
 	}
 
+	if err := ss.WriteDependencyReport(); err != nil {
+		ss.Log.Error("Unable to report on nginx: %s", err.Error())
+		return err
+	}
+
+	if err := ss.WriteConfTemplates(); err != nil {
+		ss.Log.Error("Unable to write the nginx configuration templates: %s", err.Error())
+		return err
+	}
+
 	return nil
 }
 
 func (ss *Supplier) InstallNginx() error {
 	ss.Log.BeginStep("Installing nginx")
 
-	nginx, err := ss.Manifest.DefaultVersion("nginx")
+	nginx, err := ss.nginxVersion()
 	if err != nil {
 		return err
 	}
@@ -48,6 +104,84 @@ func (ss *Supplier) InstallNginx() error {
 	if err := ss.Installer.InstallDependency(nginx, nginxDir); err != nil {
 		return err
 	}
+	ss.Config.Nginx.Path = filepath.Join("nginx", "sbin", "nginx")
+	ss.Config.Nginx.Version = nginx.Version
 
 	return ss.Stager.AddBinDependencyLink(filepath.Join(nginxDir, "sbin", "nginx"), "nginx")
 }
+
+// WriteConfTemplates writes the configuration that a final buildpack can start
+// the supplied nginx with, to serve its static assets at / or under a prefix.
+func (ss *Supplier) WriteConfTemplates() error {
+	nginxConf, err := finalize.NginxConfTemplate(finalize.Staticfile{})
+	if err != nil {
+		return err
+	}
+	prefixConf, err := finalize.NginxConfTemplate(finalize.Staticfile{LocationPrefix: "((LOCATION_PREFIX))"})
+	if err != nil {
+		return err
+	}
+
+	confDir := filepath.Join("staticfile", "conf")
+	dir := filepath.Join(ss.Stager.DepDir(), confDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for file, contents := range map[string]string{"nginx.conf": nginxConf, prefixConfFile: prefixConf, "mime.types": finalize.MimeTypes} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+	ss.Config.Nginx.ConfDir = confDir
+	ss.Config.Nginx.PrefixConf = prefixConfFile
+	return nil
+}
+
+// nginxVersion picks the nginx to install. BP_NGINX_VERSION, then nginx_version
+// in the Staticfile, can ask for a version constraint such as 1.26.x, or for the
+// newest mainline or stable nginx. Otherwise the manifest's default is used.
+func (ss *Supplier) nginxVersion() (libbuildpack.Dependency, error) {
+	constraint, source := ss.Getenv("BP_NGINX_VERSION"), "BP_NGINX_VERSION"
+	if constraint == "" {
+		var sf staticfile
+		path, _, err := detect.FindStaticfile(ss.Stager.BuildDir(), ss.Getenv(detect.RootEnv))
+		if err != nil {
+			return libbuildpack.Dependency{}, err
+		}
+		if err := ss.YAML.Load(path, &sf); err != nil && !os.IsNotExist(err) {
+			return libbuildpack.Dependency{}, err
+		}
+		constraint, source = sf.NginxVersion, "nginx_version in the Staticfile"
+	}
+	if constraint == "" {
+		return ss.Manifest.DefaultVersion("nginx")
+	}
+
+	versions := ss.Manifest.AllDependencyVersions("nginx")
+	candidates, match := versions, constraint
+	if constraint == "mainline" || constraint == "stable" {
+		candidates, match = nginxLine(versions, constraint == "mainline"), "x"
+	}
+	version, err := libbuildpack.FindMatchingVersion(match, candidates)
+	if err != nil {
+		return libbuildpack.Dependency{}, fmt.Errorf("no nginx version matches %q from %s, the versions available for this stack are %s", constraint, source, strings.Join(versions, ", "))
+	}
+	ss.Log.Info("Selected nginx %s with %s", constraint, source)
+	return libbuildpack.Dependency{Name: "nginx", Version: version}, nil
+}
+
+// nginxLine keeps the mainline versions, which have an odd minor version, or the
+// stable ones, which have an even one.
+func nginxLine(versions []string, mainline bool) []string {
+	var line []string
+	for _, version := range versions {
+		parts := strings.SplitN(version, ".", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		if minor, err := strconv.Atoi(parts[1]); err == nil && (minor%2 == 1) == mainline {
+			line = append(line, version)
+		}
+	}
+	return line
+}
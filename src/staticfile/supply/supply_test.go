@@ -3,6 +3,8 @@ package supply_test
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"time"
 
 	"github.com/cloudfoundry/staticfile-buildpack/src/staticfile/supply"
 
@@ -20,6 +22,7 @@ import (
 var _ = Describe("Supply", func() {
 	var (
 		err           error
+		buildDir      string
 		depsDir       string
 		depsIdx       string
 		depDir        string
@@ -28,6 +31,9 @@ var _ = Describe("Supply", func() {
 		mockCtrl      *gomock.Controller
 		mockManifest  *MockManifest
 		mockInstaller *MockInstaller
+		mockYaml      *MockYAML
+		env           map[string]string
+		now           time.Time
 		buffer        *bytes.Buffer
 	)
 
@@ -35,6 +41,10 @@ var _ = Describe("Supply", func() {
 		depsDir, err = os.MkdirTemp("", "staticfile-buildpack.deps.")
 		Expect(err).To(BeNil())
 
+		buildDir, err = os.MkdirTemp("", "staticfile-buildpack.build.")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, buildDir)
+
 		depsIdx = "32"
 		depDir = filepath.Join(depsDir, depsIdx)
 
@@ -47,18 +57,24 @@ var _ = Describe("Supply", func() {
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockManifest = NewMockManifest(mockCtrl)
 		mockInstaller = NewMockInstaller(mockCtrl)
+		mockYaml = NewMockYAML(mockCtrl)
+		env = map[string]string{}
+		now = time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
 		DeferCleanup(os.RemoveAll, depsDir)
 	})
 
 	JustBeforeEach(func() {
-		args := []string{"", "", depsDir, depsIdx}
+		args := []string{buildDir, "", depsDir, depsIdx}
 		bps := libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{})
 
 		supplier = &supply.Supplier{
 			Stager:    bps,
 			Manifest:  mockManifest,
 			Installer: mockInstaller,
+			YAML:      mockYaml,
 			Log:       logger,
+			Getenv:    func(name string) string { return env[name] },
+			Now:       func() time.Time { return now },
 		}
 	})
 
@@ -66,6 +82,7 @@ var _ = Describe("Supply", func() {
 		BeforeEach(func() {
 			dep := libbuildpack.Dependency{Name: "nginx", Version: "99.99"}
 
+			mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any())
 			mockManifest.EXPECT().DefaultVersion("nginx").Return(dep, nil)
 			mockInstaller.EXPECT().InstallDependency(dep, filepath.Join(depDir, "nginx"))
 		})
@@ -80,5 +97,204 @@ var _ = Describe("Supply", func() {
 
 			Expect(link).To(Equal("../nginx/sbin/nginx"))
 		})
+
+		It("records nginx for config.yml", func() {
+			Expect(supplier.InstallNginx()).To(Succeed())
+			Expect(supplier.Config.Nginx.Path).To(Equal("nginx/sbin/nginx"))
+			Expect(supplier.Config.Nginx.Version).To(Equal("99.99"))
+		})
+	})
+
+	Describe("WriteConfTemplates", func() {
+		It("writes the default nginx configuration with its placeholders", func() {
+			Expect(supplier.WriteConfTemplates()).To(Succeed())
+			Expect(supplier.Config.Nginx.ConfDir).To(Equal("staticfile/conf"))
+
+			nginxConf, err := os.ReadFile(filepath.Join(depDir, "staticfile", "conf", "nginx.conf"))
+			Expect(err).To(BeNil())
+			Expect(string(nginxConf)).To(ContainSubstring("root ((APP_ROOT))/public;"))
+			Expect(string(nginxConf)).To(ContainSubstring("((LISTEN_DIRECTIVE))"))
+			Expect(filepath.Join(depDir, "staticfile", "conf", "mime.types")).To(BeAnExistingFile())
+		})
+
+		It("writes a configuration that serves public under a location prefix", func() {
+			Expect(supplier.WriteConfTemplates()).To(Succeed())
+			Expect(supplier.Config.Nginx.PrefixConf).To(Equal("nginx.prefix.conf"))
+
+			prefixConf, err := os.ReadFile(filepath.Join(depDir, "staticfile", "conf", "nginx.prefix.conf"))
+			Expect(err).To(BeNil())
+			Expect(string(prefixConf)).To(ContainSubstring("root ((APP_ROOT))/public;"))
+			Expect(string(prefixConf)).To(MatchRegexp(`location \^~ \(\(LOCATION_PREFIX\)\)/ \{\s+rewrite \^\(\(LOCATION_PREFIX\)\)\(/\.\*\)\$ \$1 break;`))
+			Expect(string(prefixConf)).To(MatchRegexp(`location / \{\s+return 404;\s+\}`))
+		})
+	})
+
+	Describe("WriteDependencyReport", func() {
+		var rootDir string
+
+		BeforeEach(func() {
+			rootDir = GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(rootDir, "manifest.yml"), []byte(`---
+dependencies:
+- name: nginx
+  version: 1.27.5
+  uri: https://example.com/nginx_1.27.5_cflinuxfs3.tgz
+  sha256: fs3sha
+  cf_stacks:
+  - cflinuxfs3
+  source: http://nginx.org/download/nginx-1.27.5.tar.gz
+  source_sha256: oldsource
+- name: nginx
+  version: 1.27.5
+  uri: https://example.com/nginx_1.27.5_cflinuxfs4.tgz
+  sha256: fs4sha
+  cf_stacks:
+  - cflinuxfs4
+  source: http://nginx.org/download/nginx-1.27.5.tar.gz
+  source_sha256: sourcesha
+dependency_deprecation_dates:
+- name: nginx
+  version_line: 1.27.x
+  date: 2026-04-15
+`), 0644)).To(Succeed())
+
+			dep := libbuildpack.Dependency{Name: "nginx", Version: "1.27.5"}
+			mockManifest.EXPECT().GetEntry(dep).Return(&libbuildpack.ManifestEntry{
+				Dependency: dep,
+				URI:        "https://example.com/nginx_1.27.5_cflinuxfs4.tgz",
+				SHA256:     "fs4sha",
+				CFStacks:   []string{"cflinuxfs4"},
+			}, nil)
+			mockManifest.EXPECT().RootDir().Return(rootDir)
+			mockYaml.EXPECT().Load(filepath.Join(rootDir, "manifest.yml"), gomock.Any()).DoAndReturn(libbuildpack.NewYAML().Load)
+		})
+
+		JustBeforeEach(func() {
+			supplier.Config.Nginx.Version = "1.27.5"
+		})
+
+		It("writes the installed nginx into the droplet", func() {
+			Expect(supplier.WriteDependencyReport()).To(Succeed())
+
+			report, err := os.ReadFile(filepath.Join(depDir, "staticfile", "dependencies.json"))
+			Expect(err).To(BeNil())
+			Expect(string(report)).To(MatchJSON(`{"dependencies": [{
+				"name": "nginx",
+				"version": "1.27.5",
+				"uri": "https://example.com/nginx_1.27.5_cflinuxfs4.tgz",
+				"sha256": "fs4sha",
+				"source": "http://nginx.org/download/nginx-1.27.5.tar.gz",
+				"source_sha256": "sourcesha",
+				"cf_stacks": ["cflinuxfs4"],
+				"eol_date": "2026-04-15",
+				"eol": true
+			}]}`))
+		})
+
+		It("warns about an nginx past its end of life", func() {
+			Expect(supplier.WriteDependencyReport()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("       nginx 1.27.5, sha256 fs4sha\n"))
+			Expect(buffer.String()).To(ContainSubstring("**WARNING**"))
+			Expect(buffer.String()).To(ContainSubstring("nginx 1.27.5 reached its end of life on 2026-04-15\n"))
+		})
+
+		Context("the end of life is still to come", func() {
+			BeforeEach(func() {
+				now = time.Date(2026, 4, 14, 0, 0, 0, 0, time.UTC)
+				env["BP_FAIL_ON_EOL"] = "true"
+			})
+
+			It("reports the date", func() {
+				Expect(supplier.WriteDependencyReport()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("       nginx 1.27.5 reaches its end of life on 2026-04-15\n"))
+			})
+		})
+
+		Context("BP_FAIL_ON_EOL is set", func() {
+			BeforeEach(func() {
+				env["BP_FAIL_ON_EOL"] = "true"
+			})
+
+			It("fails staging", func() {
+				Expect(supplier.WriteDependencyReport()).To(MatchError("nginx 1.27.5 reached its end of life on 2026-04-15, and BP_FAIL_ON_EOL is set"))
+			})
+		})
+	})
+
+	Describe("InstallNginx with a version", func() {
+		var installed libbuildpack.Dependency
+
+		BeforeEach(func() {
+			installed = libbuildpack.Dependency{}
+			mockManifest.EXPECT().AllDependencyVersions("nginx").Return([]string{"1.26.3", "1.27.5", "1.28.0", "1.29.2"}).AnyTimes()
+			mockInstaller.EXPECT().InstallDependency(gomock.Any(), filepath.Join(depDir, "nginx")).DoAndReturn(func(dep libbuildpack.Dependency, _ string) error {
+				installed = dep
+				return nil
+			}).AnyTimes()
+		})
+
+		staticfileVersion := func(version string) {
+			mockYaml.EXPECT().Load(filepath.Join(buildDir, "Staticfile"), gomock.Any()).Do(func(_ string, sf interface{}) {
+				reflect.ValueOf(sf).Elem().FieldByName("NginxVersion").SetString(version)
+			})
+		}
+
+		Context("nginx_version is a constraint", func() {
+			BeforeEach(func() {
+				staticfileVersion("1.26.x")
+			})
+
+			It("installs the newest matching version", func() {
+				Expect(supplier.InstallNginx()).To(Succeed())
+				Expect(installed).To(Equal(libbuildpack.Dependency{Name: "nginx", Version: "1.26.3"}))
+				Expect(buffer.String()).To(ContainSubstring("       Selected nginx 1.26.x with nginx_version in the Staticfile\n"))
+				Expect(buffer.String()).To(ContainSubstring("       Using nginx version 1.26.3\n"))
+			})
+		})
+
+		Context("nginx_version is mainline", func() {
+			BeforeEach(func() {
+				staticfileVersion("mainline")
+			})
+
+			It("installs the newest version with an odd minor version", func() {
+				Expect(supplier.InstallNginx()).To(Succeed())
+				Expect(installed.Version).To(Equal("1.29.2"))
+			})
+		})
+
+		Context("nginx_version is stable", func() {
+			BeforeEach(func() {
+				staticfileVersion("stable")
+			})
+
+			It("installs the newest version with an even minor version", func() {
+				Expect(supplier.InstallNginx()).To(Succeed())
+				Expect(installed.Version).To(Equal("1.28.0"))
+			})
+		})
+
+		Context("BP_NGINX_VERSION is set", func() {
+			BeforeEach(func() {
+				env["BP_NGINX_VERSION"] = "1.27.x"
+			})
+
+			It("takes precedence over the Staticfile", func() {
+				Expect(supplier.InstallNginx()).To(Succeed())
+				Expect(installed.Version).To(Equal("1.27.5"))
+				Expect(buffer.String()).To(ContainSubstring("       Selected nginx 1.27.x with BP_NGINX_VERSION\n"))
+			})
+		})
+
+		Context("no version matches", func() {
+			BeforeEach(func() {
+				staticfileVersion("1.25.x")
+			})
+
+			It("lists the available versions", func() {
+				Expect(supplier.InstallNginx()).To(MatchError(`no nginx version matches "1.25.x" from nginx_version in the Staticfile, the versions available for this stack are 1.26.3, 1.27.5, 1.28.0, 1.29.2`))
+				Expect(installed).To(Equal(libbuildpack.Dependency{}))
+			})
+		})
 	})
 })